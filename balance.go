@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/source"
+	"ethcrawler/pkg/tokens"
+)
+
+// openingBalances переводит -opening-balance в баланс токена на начало
+// истории. Начальный баланс задается в единицах токена и допустим только для
+// одного токена, остальные токены начинают с нуля.
+func openingBalances(resolver *tokens.Resolver, contract, openingBalance string) (map[string]decimal.Decimal, error) {
+	openings := make(map[string]decimal.Decimal)
+	if openingBalance == "" {
+		return openings, nil
+	}
+	if contract == "" {
+		return nil, fmt.Errorf("-opening-balance needs a single token, not -token all")
+	}
+
+	md, _ := resolver.Resolve(contract)
+	opening, err := decimal.ParseDecimal(openingBalance, md.Decimals)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing opening balance: %v", err)
+	}
+	openings[strings.ToLower(contract)] = opening
+	return openings, nil
+}
+
+// completeLedgers добавляет запись токена без трансферов, чтобы его баланс
+// тоже сверялся с сетью
+func completeLedgers(ledgers []ledger.Ledger, resolver *tokens.Resolver, contract string, openings map[string]decimal.Decimal) []ledger.Ledger {
+	if _, ok := ledger.Find(ledgers, contract); ok || contract == "" {
+		return ledgers
+	}
+
+	md, _ := resolver.Resolve(contract)
+	opening := openings[strings.ToLower(contract)]
+	return append(ledgers, ledger.Ledger{
+		Contract: strings.ToLower(contract),
+		Symbol:   md.Symbol,
+		Decimals: md.Decimals,
+		Opening:  opening,
+		Closing:  opening,
+	})
+}
+
+// checkBalances читает текущий баланс каждого токена из сети, если источник
+// это умеет
+func checkBalances(ctx context.Context, src source.TransferSource, ledgers []ledger.Ledger, address string) error {
+	reader, ok := src.(source.BalanceReader)
+	if !ok {
+		return fmt.Errorf("the transfer source cannot read token balances")
+	}
+
+	for i := range ledgers {
+		units, err := reader.TokenBalance(ctx, ledgers[i].Contract, address)
+		if err != nil {
+			return fmt.Errorf("error reading balance of %s: %v", ledgers[i].Contract, err)
+		}
+		balance := decimal.New(units, ledgers[i].Decimals)
+		ledgers[i].OnChain = &balance
+	}
+	return nil
+}
+
+// reportBalances печатает итоговые балансы и выделяет расхождения
+func reportBalances(ledgers []ledger.Ledger) {
+	for _, l := range ledgers {
+		label := l.Symbol
+		if label == "" {
+			label = l.Contract
+		}
+
+		if !l.Mismatch() {
+			check := ""
+			if l.OnChain != nil {
+				check = ", matches the on-chain balance"
+			}
+			fmt.Fprintf(console, "%sBalance of %s: %s -> %s%s%s\n",
+				etherscan.ColorGreen, label, l.Opening, l.Closing, check, etherscan.ColorReset)
+			continue
+		}
+
+		fmt.Fprintf(console, "%sBalance mismatch for %s: reconstructed %s", etherscan.ColorRed, label, l.Closing)
+		if l.OnChain != nil {
+			fmt.Fprintf(console, ", on-chain %s (difference %s)", l.OnChain, l.OnChain.Sub(l.Closing))
+		}
+		if l.Negative > 0 {
+			fmt.Fprintf(console, ", negative after %d transfers", l.Negative)
+		}
+		fmt.Fprintf(console, ". Transfers are probably missing or the opening balance is wrong.%s\n", etherscan.ColorReset)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/output"
+)
+
+// DefaultConcurrency — число адресов, загружаемых одновременно в пакетном режиме
+const DefaultConcurrency = 3
+
+// batchEntry — адрес из списка с необязательной меткой
+type batchEntry struct {
+	Address string
+	Label   string
+}
+
+// readAddressFile читает список адресов: по одному в строке или CSV с меткой
+// в соседней колонке (разделители: запятая, точка с запятой, табуляция).
+// Строки с # и строки без адреса (например, заголовок CSV) пропускаются,
+// повторы адресов тоже. Ошибкой считаются только строки с некорректным адресом.
+func readAddressFile(path string) ([]batchEntry, []error, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening address list: %v", err)
+	}
+	defer f.Close()
+
+	var entries []batchEntry
+	var invalid []error
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ';' || r == '\t'
+		})
+
+		var entry batchEntry
+		hexLike := false
+		for _, field := range fields {
+			field = strings.Trim(strings.TrimSpace(field), `"`)
+			switch {
+			case entry.Address == "" && isValidEthereumAddress(field):
+				entry.Address = field
+			case strings.HasPrefix(strings.ToLower(field), "0x"):
+				hexLike = true
+			case entry.Label == "" && field != "":
+				entry.Label = field
+			}
+		}
+
+		if entry.Address == "" {
+			// Строка без похожего на адрес поля — заголовок или комментарий
+			if hexLike {
+				invalid = append(invalid, withCode(ExitValidation, fmt.Errorf("line %d: invalid address %q", lineNo, line)))
+			}
+			continue
+		}
+
+		key := strings.ToLower(entry.Address)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading address list: %v", err)
+	}
+
+	return entries, invalid, nil
+}
+
+// runBatch загружает адреса параллельно, не более concurrency одновременно,
+// и передает результат каждого адреса в handle. Все загрузки идут через один
+// источник и делят его ограничение частоты запросов. Ошибка одного адреса не
+// останавливает остальные. Трансферы адресов остаются в памяти до конца
+// пакета, только если keep задан, например для сводной книги.
+func runBatch(ctx context.Context, s crawlSettings, entries []batchEntry, concurrency int, keep bool, handle func(crawlResult) error) []output.AddressResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]output.AddressResult, len(entries))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, entry := range entries {
+		wg.Add(1)
+		go func(i int, entry batchEntry) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result := output.AddressResult{Address: entry.Address, Label: entry.Label}
+			defer func() { results[i] = result }()
+
+			// Адреса, не начатые до Ctrl-C, не загружаются
+			if err := ctx.Err(); err != nil {
+				result.Err = fmt.Errorf("skipped: %v", err)
+				return
+			}
+
+			fmt.Fprintf(console, "%s[%d/%d] Fetching %s%s\n",
+				etherscan.ColorGreen, i+1, len(entries), entry.Address, etherscan.ColorReset)
+			crawled, err := crawlAddress(ctx, s, entry.Address)
+			if err != nil {
+				result.Err = err
+				fmt.Fprintf(console, "%s[%d/%d] %s failed: %v%s\n",
+					etherscan.ColorRed, i+1, len(entries), entry.Address, err, etherscan.ColorReset)
+				return
+			}
+			crawled.Label = entry.Label
+
+			result.Count = crawled.Count
+			if keep {
+				if result.Transfers, err = crawled.Rows.Collect(); err != nil {
+					result.Err = withCode(ExitOutput, fmt.Errorf("error reading transfers: %v", err))
+					return
+				}
+			}
+			result.Ledgers = crawled.Ledgers
+			result.Interrupted = crawled.Interrupted
+			result.Err = handle(crawled)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// reportBatch печатает итог пакетной загрузки и возвращает код завершения:
+// код первой ошибки или ExitOK, если все адреса загружены
+func reportBatch(results []output.AddressResult, invalid []error) int {
+	code := ExitOK
+	failed := len(invalid)
+	fmt.Fprintf(console, "\n%sBatch report:%s\n", etherscan.ColorGreen, etherscan.ColorReset)
+	for _, result := range results {
+		name := result.Address
+		if result.Label != "" {
+			name = fmt.Sprintf("%s (%s)", result.Label, result.Address)
+		}
+
+		switch result.Status() {
+		case "OK":
+			fmt.Fprintf(console, "%s  OK       %s: %d transactions%s\n",
+				etherscan.ColorGreen, name, result.Count, etherscan.ColorReset)
+		case "PARTIAL":
+			fmt.Fprintf(console, "%s  PARTIAL  %s: %d transactions, run again with -resume%s\n",
+				etherscan.ColorYellow, name, result.Count, etherscan.ColorReset)
+		default:
+			failed++
+			if code == ExitOK {
+				code = exitCode(result.Err)
+			}
+			fmt.Fprintf(console, "%s  FAILED   %s: %v%s\n",
+				etherscan.ColorRed, name, result.Err, etherscan.ColorReset)
+		}
+	}
+	for _, err := range invalid {
+		if code == ExitOK {
+			code = exitCode(err)
+		}
+		fmt.Fprintf(console, "%s  INVALID  %v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+	}
+
+	fmt.Fprintf(console, "%d of %d addresses succeeded, %d failed\n",
+		len(results)+len(invalid)-failed, len(results)+len(invalid), failed)
+	return code
+}
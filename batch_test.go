@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"ethcrawler/pkg/source"
+)
+
+func TestRunBatchKeepsTransfersOnlyWhenAsked(t *testing.T) {
+	fake := source.NewFake(0,
+		usdtTransfer(100, 0, otherAddress, testAddress, "100000000"),
+		usdtTransfer(150, 1, testAddress, otherAddress, "30000000"),
+	)
+	entries := []batchEntry{{Address: testAddress, Label: "Treasury"}, {Address: otherAddress}}
+
+	for _, keep := range []bool{false, true} {
+		s := testSettings(t, fake)
+		s.CheckBalance = false
+
+		// Addresses are handled concurrently
+		var handled atomic.Int32
+		results := runBatch(context.Background(), s, entries, 2, keep, func(r crawlResult) error {
+			transfers, err := r.Rows.Collect()
+			handled.Add(int32(len(transfers)))
+			return err
+		})
+
+		if handled := handled.Load(); handled != 4 {
+			t.Errorf("keep %v: handled %d transfers, want 4", keep, handled)
+		}
+		for _, result := range results {
+			if result.Err != nil || result.Count != 2 {
+				t.Errorf("keep %v: %s has %d transfers (%v), want 2", keep, result.Address, result.Count, result.Err)
+			}
+			if kept := result.Transfers != nil; kept != keep {
+				t.Errorf("keep %v: transfers of %s kept = %v", keep, result.Address, kept)
+			}
+		}
+	}
+}
+
+func TestReadAddressFile(t *testing.T) {
+	const (
+		third = "0x3333333333333333333333333333333333333333"
+		mixed = "0xabcdefabcdefabcdefabcdefabcdefabcdefabcd"
+	)
+	tests := []struct {
+		name    string
+		content string
+		want    []batchEntry
+		invalid []int // Line numbers reported as invalid
+	}{
+		{
+			name:    "one address per line",
+			content: testAddress + "\n" + otherAddress + "\n",
+			want:    []batchEntry{{Address: testAddress}, {Address: otherAddress}},
+		},
+		{
+			name:    "comments and blank lines",
+			content: "# treasury wallets\n\n   \n" + testAddress + "\n  # " + otherAddress + "\n",
+			want:    []batchEntry{{Address: testAddress}},
+		},
+		{
+			name:    "byte order mark and CRLF",
+			content: "\ufeff" + testAddress + "\r\n" + otherAddress + "\r\n",
+			want:    []batchEntry{{Address: testAddress}, {Address: otherAddress}},
+		},
+		{
+			name:    "duplicates keep the first label",
+			content: mixed + ",Treasury\n" + "0x" + strings.ToUpper(mixed[2:]) + "\n" + mixed + ",Other\n",
+			want:    []batchEntry{{Address: mixed, Label: "Treasury"}},
+		},
+		{
+			name: "labels with any separator and column order",
+			content: "address,label\n" +
+				testAddress + ",Treasury\n" +
+				`"Payroll";"` + otherAddress + `"` + "\n" +
+				third + "\tCold storage\n",
+			want: []batchEntry{
+				{Address: testAddress, Label: "Treasury"},
+				{Address: otherAddress, Label: "Payroll"},
+				{Address: third, Label: "Cold storage"},
+			},
+		},
+		{
+			name:    "invalid addresses",
+			content: testAddress + "\n0x1234\n0xZZZZ111111111111111111111111111111111111,Broken\n" + otherAddress + "\n",
+			want:    []batchEntry{{Address: testAddress}, {Address: otherAddress}},
+			invalid: []int{2, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "addresses.csv")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, invalid, err := readAddressFile(path)
+			if err != nil {
+				t.Fatalf("readAddressFile: %v", err)
+			}
+			if fmt.Sprint(entries) != fmt.Sprint(tt.want) {
+				t.Errorf("entries = %v, want %v", entries, tt.want)
+			}
+			if len(invalid) != len(tt.invalid) {
+				t.Fatalf("invalid = %v, want lines %v", invalid, tt.invalid)
+			}
+			for i, line := range tt.invalid {
+				if !strings.Contains(invalid[i].Error(), fmt.Sprintf("line %d:", line)) || exitCode(invalid[i]) != ExitValidation {
+					t.Errorf("invalid[%d] = %v, want line %d with a validation exit code", i, invalid[i], line)
+				}
+			}
+		})
+	}
+
+	if _, _, err := readAddressFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Errorf("readAddressFile of a missing file succeeded")
+	}
+}
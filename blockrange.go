@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/source"
+)
+
+// dateLayouts перечисляет поддерживаемые форматы дат для -from и -to
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// rangeBound — граница диапазона: номер блока или момент времени
+type rangeBound struct {
+	Block int
+	Time  time.Time // Нулевое, если задан номер блока
+}
+
+// parseRangeBound разбирает номер блока, дату или latest. Даты без времени
+// для конца диапазона включают весь день. Время берется в локальной зоне,
+// как и даты в выходных файлах. latest допустим только для конца диапазона
+// и означает открытую границу.
+func parseRangeBound(value string, end bool) (rangeBound, error) {
+	value = strings.TrimSpace(value)
+	if strings.EqualFold(value, "latest") {
+		if !end {
+			return rangeBound{}, fmt.Errorf("latest is only valid as the end of the range")
+		}
+		return rangeBound{}, nil
+	}
+	if block, err := strconv.Atoi(value); err == nil {
+		if block < 0 {
+			return rangeBound{}, fmt.Errorf("invalid block number %d", block)
+		}
+		return rangeBound{Block: block}, nil
+	}
+
+	for _, layout := range dateLayouts {
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		if end && layout == "2006-01-02" {
+			t = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		return rangeBound{Time: t}, nil
+	}
+
+	return rangeBound{}, fmt.Errorf("invalid block number or date %q, use e.g. 18000000 or 2024-07-01", value)
+}
+
+// resolveBlockRange переводит значения -from и -to в номера блоков. Пустое
+// значение и дата в будущем означают открытую границу (0). Ошибки несут код
+// завершения.
+func resolveBlockRange(ctx context.Context, src source.TransferSource, from, to string) (int, int, error) {
+	var blocks [2]int
+	for i, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+
+		end := i == 1
+		bound, err := parseRangeBound(value, end)
+		if err != nil {
+			return 0, 0, withCode(ExitValidation, err)
+		}
+		if bound.Time.IsZero() {
+			blocks[i] = bound.Block
+			continue
+		}
+		if end && bound.Time.After(time.Now()) {
+			continue
+		}
+
+		finder, ok := src.(source.BlockFinder)
+		if !ok {
+			return 0, 0, withCode(ExitValidation, fmt.Errorf("the transfer source cannot map dates to blocks, pass block numbers instead"))
+		}
+		blocks[i], err = finder.BlockNumberByTime(ctx, bound.Time, !end)
+		if err != nil {
+			return 0, 0, withCode(ExitAPI, fmt.Errorf("error finding the block for %s: %v", value, err))
+		}
+		fmt.Fprintf(console, "Block range bound %s is block %d\n", value, blocks[i])
+	}
+
+	if blocks[1] > 0 && blocks[0] > blocks[1] {
+		return 0, 0, withCode(ExitValidation, fmt.Errorf("the range starts at block %d after it ends at block %d", blocks[0], blocks[1]))
+	}
+	return blocks[0], blocks[1], nil
+}
+
+// parseBlockRange разбирает -from и -to без обращения к источнику данных.
+// Номера блоков ограничивают блоки, а даты — время трансферов.
+func parseBlockRange(from, to string) (rangeBound, rangeBound, error) {
+	var bounds [2]rangeBound
+	for i, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		bound, err := parseRangeBound(value, i == 1)
+		if err != nil {
+			return rangeBound{}, rangeBound{}, withCode(ExitValidation, err)
+		}
+		bounds[i] = bound
+	}
+
+	start, end := bounds[0], bounds[1]
+	if start.Block > 0 && end.Block > 0 && start.Block > end.Block ||
+		!start.Time.IsZero() && !end.Time.IsZero() && start.Time.After(end.Time) {
+		return rangeBound{}, rangeBound{}, withCode(ExitValidation, fmt.Errorf("the range starts after it ends"))
+	}
+	return start, end, nil
+}
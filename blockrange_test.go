@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/source"
+)
+
+func TestParseRangeBound(t *testing.T) {
+	local := func(layout, value string) time.Time {
+		v, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		value   string
+		end     bool
+		want    rangeBound
+		wantErr bool
+	}{
+		{"18000000", false, rangeBound{Block: 18000000}, false},
+		{" 0 ", true, rangeBound{}, false},
+		{"-5", false, rangeBound{}, true},
+		{"2024-07-01", false, rangeBound{Time: local("2006-01-02", "2024-07-01")}, false},
+		{"2024-07-01", true, rangeBound{Time: local("2006-01-02 15:04:05", "2024-07-01 23:59:59")}, false},
+		{"2024-07-01 15:04", true, rangeBound{Time: local("2006-01-02 15:04", "2024-07-01 15:04")}, false},
+		{"2024-07-01 15:04:05", false, rangeBound{Time: local("2006-01-02 15:04:05", "2024-07-01 15:04:05")}, false},
+		{"2024-07-01T12:00:00Z", true, rangeBound{Time: time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)}, false},
+		{"latest", true, rangeBound{}, false},
+		{"LATEST", true, rangeBound{}, false},
+		{"latest", false, rangeBound{}, true},
+		{"2024-13-01", false, rangeBound{}, true},
+		{"yesterday", true, rangeBound{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRangeBound(tt.value, tt.end)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRangeBound(%q, end %v) error = %v, want error %v", tt.value, tt.end, err, tt.wantErr)
+			continue
+		}
+		if got.Block != tt.want.Block || !got.Time.Equal(tt.want.Time) {
+			t.Errorf("parseRangeBound(%q, end %v) = %+v, want %+v", tt.value, tt.end, got, tt.want)
+		}
+	}
+}
+
+// blockFinder adds date lookups to the fake source: block n is mined at
+// genesis + n hours
+type blockFinder struct {
+	*source.Fake
+	head int
+	err  error
+}
+
+var genesis = time.Date(2024, 7, 1, 0, 0, 0, 0, time.Local)
+
+func (f blockFinder) BlockNumberByTime(_ context.Context, t time.Time, after bool) (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	hours := t.Sub(genesis) / time.Hour
+	block := int(hours)
+	if after && genesis.Add(hours*time.Hour).Before(t) {
+		block++
+	}
+	return min(block, f.head), nil
+}
+
+func TestResolveBlockRange(t *testing.T) {
+	finder := blockFinder{Fake: source.NewFake(0), head: 1000}
+	tests := []struct {
+		name     string
+		src      source.TransferSource
+		from, to string
+		start    int
+		end      int
+		code     int
+	}{
+		{"open", finder, "", "", 0, 0, 0},
+		{"blocks", finder, "100", "200", 100, 200, 0},
+		{"latest", finder, "100", "latest", 100, 0, 0},
+		{"dates", finder, "2024-07-01 10:30", "2024-07-02", 11, 47, 0},
+		{"future end", finder, "2024-07-02", "2999-01-01", 24, 0, 0},
+		{"blocks without a finder", source.NewFake(0), "100", "200", 100, 200, 0},
+		{"dates without a finder", source.NewFake(0), "2024-07-01", "", 0, 0, ExitValidation},
+		{"bad value", finder, "soon", "", 0, 0, ExitValidation},
+		{"reversed", finder, "200", "100", 0, 0, ExitValidation},
+		{"reversed dates", finder, "2024-07-03", "2024-07-02", 0, 0, ExitValidation},
+		{"finder error", blockFinder{Fake: source.NewFake(0), err: errors.New("rate limit")}, "2024-07-01", "", 0, 0, ExitAPI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := resolveBlockRange(context.Background(), tt.src, tt.from, tt.to)
+			if tt.code != 0 {
+				if code := exitCode(err); err == nil || code != tt.code {
+					t.Errorf("resolveBlockRange error = %v with code %d, want code %d", err, code, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveBlockRange: %v", err)
+			}
+			if start != tt.start || end != tt.end {
+				t.Errorf("resolveBlockRange = %d to %d, want %d to %d", start, end, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestParseBlockRange(t *testing.T) {
+	tests := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{"", "", false},
+		{"100", "100", false},
+		{"100", "latest", false},
+		{"2024-07-01", "2024-07-01", false},
+		{"200", "100", true},
+		{"2024-07-02", "2024-07-01", true},
+		{"latest", "", true},
+		// Blocks and dates are not compared with each other
+		{"200", "2024-07-01", false},
+	}
+	for _, tt := range tests {
+		_, _, err := parseBlockRange(tt.from, tt.to)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBlockRange(%q, %q) = %v, want error %v", tt.from, tt.to, err, tt.wantErr)
+		}
+		if err != nil && exitCode(err) != ExitValidation {
+			t.Errorf("parseBlockRange(%q, %q) exit code %d, want %d", tt.from, tt.to, exitCode(err), ExitValidation)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/output"
+	"ethcrawler/pkg/source"
+	"ethcrawler/pkg/state"
+	"ethcrawler/pkg/tokens"
+)
+
+// command — подкоманда командной строки
+type command struct {
+	Name  string
+	Args  string // Аргументы для справки после флагов
+	Short string
+	Run   func(name string, args []string)
+}
+
+// defaultCommand выполняется, если подкоманда не указана, как до появления
+// подкоманд: ethcrawler -a 0x... -format excel
+const defaultCommand = "sync"
+
+// commands перечисляет подкоманды в порядке вывода в справке. Заполняется в
+// init, так как команды сами обращаются к списку.
+var commands []command
+
+func init() {
+	commands = []command{
+		{Name: "sync", Short: "Download new transfers and save the outputs (default)", Run: runSync},
+		{Name: "fetch", Short: "Download new transfers into the local store without writing outputs", Run: runFetch},
+		{Name: "export", Short: "Save the outputs from downloaded transfers without calling the API", Run: runExport},
+		{Name: "report", Short: "Print inflow, outflow and balances of downloaded transfers", Run: runReport},
+		{Name: "config", Args: "init|show|validate", Short: "Create, show or check the configuration file", Run: runConfig},
+		{Name: "help", Args: "[command]", Short: "Show help for a command", Run: runHelp},
+	}
+}
+
+// lookupCommand ищет подкоманду по имени
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// runCommand выбирает подкоманду по первому аргументу. Аргументы, начинающиеся
+// с флага, относятся к команде по умолчанию.
+func runCommand(args []string) {
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(ExitValidation)
+	}
+	cmd.Run(cmd.Name, args)
+}
+
+// printUsage печатает список подкоманд
+func printUsage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: ethcrawler [command] [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.Name, cmd.Short)
+	}
+	fmt.Fprintf(w, "\nRun `ethcrawler help <command>` for the flags of a command.\n")
+}
+
+// newFlagSet создает набор флагов подкоманды со справкой. Имя может включать
+// вложенную команду, например "config init".
+func newFlagSet(name string) *flag.FlagSet {
+	cmd, _ := lookupCommand(strings.Fields(name)[0])
+	usage := "ethcrawler " + name + " [flags]"
+	if name == cmd.Name && cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+		fmt.Fprintf(w, "Usage: %s\n\n%s\n\nFlags:\n", usage, cmd.Short)
+		fs.PrintDefaults()
+		if name == defaultCommand {
+			fmt.Fprintln(w)
+			printUsage()
+		}
+	}
+	return fs
+}
+
+// runHelp печатает справку по команде или список команд
+func runHelp(name string, args []string) {
+	if len(args) == 0 {
+		printUsage()
+		return
+	}
+	if _, ok := lookupCommand(args[0]); !ok || args[0] == name {
+		printUsage()
+		return
+	}
+	// Справку печатает набор флагов команды
+	runCommand([]string{args[0], "-h"})
+}
+
+// commandMode определяет, что команда делает с трансферами
+type commandMode int
+
+const (
+	modeSync   commandMode = iota // Загрузка и сохранение выходных файлов
+	modeFetch                     // Только загрузка
+	modeExport                    // Сохранение выходных файлов из хранилища
+	modeReport                    // Отчет из хранилища
+)
+
+// online сообщает, обращается ли режим к источнику данных
+func (m commandMode) online() bool {
+	return m == modeSync || m == modeFetch
+}
+
+// crawlFlags — флаги команд sync, fetch, export и report
+type crawlFlags struct {
+	Address        string
+	Addresses      string
+	Concurrency    int
+	Config         string
+	WorkDir        string
+	Chain          string
+	Token          string
+	From           string
+	To             string
+	NonInteractive bool
+
+	// Загрузка
+	Source     string
+	RPC        string
+	Blockscout string
+	Rate       float64
+	Full       bool
+	Resume     bool
+
+	// Вывод
+	Format         string
+	Columns        string
+	Output         string
+	Direction      string
+	OpeningBalance string
+	CheckBalance   bool
+}
+
+// register добавляет в fs флаги, нужные режиму
+func (f *crawlFlags) register(fs *flag.FlagSet, mode commandMode) {
+	fs.StringVar(&f.Address, "a", "", "Ethereum address")
+	fs.StringVar(&f.Addresses, "addresses", "", "File with addresses to crawl, one per line or CSV with a label column")
+	fs.IntVar(&f.Concurrency, "concurrency", DefaultConcurrency, "Addresses crawled at the same time with -addresses")
+	fs.StringVar(&f.Config, "config", "", "Path to config file (.env or .conf)")
+	fs.StringVar(&f.WorkDir, "workdir", DefaultWorkDir, "Directory for downloaded data and sync checkpoints")
+	fs.StringVar(&f.Chain, "chain", chains.Ethereum.Name, "Chain: "+strings.Join(chains.Names(), ", "))
+	fs.StringVar(&f.Token, "token", "", "Token: usdt, usdc, a contract address or all for every ERC20 token (default: USDT)")
+	fs.StringVar(&f.From, "from", "", "Start of the range: block number or date, e.g. 2024-07-01 (default: first block)")
+	fs.StringVar(&f.To, "to", "", "End of the range: block number, date or latest, inclusive (default: latest)")
+	fs.BoolVar(&f.NonInteractive, "non-interactive", false, "Never prompt or wait for Enter, for scripts and cron (default: on when stdin is not a terminal)")
+
+	if mode.online() {
+		fs.StringVar(&f.Source, "source", "", "Transfer source: "+strings.Join(source.Names(), ", ")+" (default: SOURCE from config or etherscan)")
+		fs.StringVar(&f.RPC, "rpc", "", "JSON-RPC node URL for -source rpc (default: RPC_URL from config)")
+		fs.StringVar(&f.Blockscout, "blockscout", "", "Blockscout API URL for -source blockscout (default: BLOCKSCOUT_URL from config or the chain preset)")
+		fs.Float64Var(&f.Rate, "rate", 0, "Maximum API calls per second (default: the limit of the source, 5 for etherscan and blockscout, 10 for rpc)")
+		fs.BoolVar(&f.Full, "full", false, "Ignore previously downloaded data and fetch the full history again")
+		fs.BoolVar(&f.Resume, "resume", false, "Continue an interrupted download from its journal")
+	}
+
+	if mode == modeSync || mode == modeExport {
+		fs.StringVar(&f.Format, "format", "both", "Output formats, comma separated: "+formatNames()+" or both for text and excel")
+		fs.StringVar(&f.Output, "o", "", "Output file of a single -format, - writes to stdout (default: named after the token, chain and address)")
+		fs.StringVar(&f.Columns, "columns", "", "CSV and TSV columns, comma separated: "+strings.Join(output.AllColumns, ", ")+" (default: all but contract and balance)")
+		fs.StringVar(&f.Direction, "direction", "all", "Transfers to save: in, out or all")
+	}
+	if mode != modeFetch {
+		fs.StringVar(&f.OpeningBalance, "opening-balance", "", "Token balance before the first transfer, in token units (default 0)")
+	}
+	if mode == modeSync {
+		fs.BoolVar(&f.CheckBalance, "check-balance", false, "Compare the reconstructed balance with the on-chain balanceOf")
+	}
+}
+
+// runSync загружает новые трансферы и сохраняет выходные файлы
+func runSync(name string, args []string) {
+	runCrawl(name, args, modeSync)
+}
+
+// runFetch только загружает новые трансферы в хранилище
+func runFetch(name string, args []string) {
+	runCrawl(name, args, modeFetch)
+}
+
+// runExport сохраняет выходные файлы из хранилища
+func runExport(name string, args []string) {
+	runCrawl(name, args, modeExport)
+}
+
+// runReport печатает отчет по трансферам из хранилища
+func runReport(name string, args []string) {
+	runCrawl(name, args, modeReport)
+}
+
+// runCrawl разбирает флаги команды, загружает или читает трансферы каждого
+// адреса и обрабатывает их в зависимости от режима
+func runCrawl(name string, args []string, mode commandMode) {
+	var f crawlFlags
+	fs := newFlagSet(name)
+	f.register(fs, mode)
+	fs.Parse(args)
+
+	// Без терминала запросы ввода зависли бы навсегда
+	nonInteractive = f.NonInteractive || !stdinIsTerminal()
+
+	// При -o - стандартный вывод отдается данным, а все сообщения, включая
+	// сообщения источника данных, уходят в stderr
+	if f.Output == stdoutOutput {
+		console = os.Stderr
+	}
+
+	// Приветствие
+	fmt.Fprintf(console, "%sEthCrawler - USDT Transaction Tool%s\n\n",
+		etherscan.ColorGreen, etherscan.ColorReset)
+
+	// В пакетном режиме адреса берутся из файла
+	var entries []batchEntry
+	var invalid []error
+	address := f.Address
+	if f.Addresses != "" {
+		var err error
+		entries, invalid, err = readAddressFile(f.Addresses)
+		if err != nil {
+			fmt.Fprintf(console, "%s%v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+			exit(ExitValidation)
+		}
+		if len(entries) == 0 {
+			fmt.Fprintf(console, "%sNo valid addresses found in %s%s\n",
+				etherscan.ColorRed, f.Addresses, etherscan.ColorReset)
+			exit(ExitValidation)
+		}
+		if f.OpeningBalance != "" {
+			fmt.Fprintf(console, "%s-opening-balance applies to a single address and cannot be used with -addresses%s\n",
+				etherscan.ColorRed, etherscan.ColorReset)
+			exit(ExitValidation)
+		}
+	} else {
+		// Интерактивный режим, если адрес не указан через аргументы
+		if address == "" {
+			if nonInteractive {
+				fmt.Fprintf(console, "%sNo address given, use -a or -addresses in non-interactive mode%s\n",
+					etherscan.ColorRed, etherscan.ColorReset)
+				exit(ExitValidation)
+			}
+			address = promptForEthereumAddress()
+		}
+
+		// Проверка валидности адреса
+		if len(address) != 42 || address[:2] != "0x" {
+			fmt.Fprintf(console, "%sAddress has to start from 0x and contain 40 hex-symbols%s\n",
+				etherscan.ColorRed, etherscan.ColorReset)
+			exit(ExitValidation)
+		}
+	}
+
+	if f.Direction != "" && f.Direction != "in" && f.Direction != "out" && f.Direction != "all" {
+		fmt.Fprintf(console, "%sInvalid -direction %q, use in, out or all%s\n",
+			etherscan.ColorRed, f.Direction, etherscan.ColorReset)
+		exit(ExitValidation)
+	}
+	if f.Direction == "" {
+		f.Direction = "all"
+	}
+
+	var outputs outputOptions
+	if mode == modeSync || mode == modeExport {
+		var err error
+		outputs, err = parseOutputOptions(f.Format, f.Columns, f.Output)
+		if err != nil {
+			fmt.Fprintf(console, "%s%v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+			exit(ExitValidation)
+		}
+		if f.Output != "" && f.Addresses != "" && !outputs.Shared() {
+			fmt.Fprintf(console, "%s-o writes a single address and cannot be used with -addresses%s\n",
+				etherscan.ColorRed, etherscan.ColorReset)
+			exit(ExitValidation)
+		}
+		outputs.Stdout = os.Stdout
+	}
+
+	// Load environment variables and handle first run setup. Commands working
+	// on downloaded data don't need an API key.
+	var cfg Config
+	if mode.online() {
+		cfg = setupConfiguration(f.Config, f.Source)
+	} else {
+		cfg = loadConfiguration(f.Config)
+		if cfg.Contract == "" {
+			cfg.Contract = DefaultUsdtContract
+		}
+	}
+	if f.RPC != "" {
+		cfg.RPCURL = f.RPC
+	}
+	if f.Blockscout != "" {
+		cfg.BlockscoutURL = f.Blockscout
+	}
+	sourceName := cfg.sourceName(f.Source)
+	outputs.PostgresDSN = cfg.PostgresDSN
+	if outputs.Has("postgres") && cfg.PostgresDSN == "" {
+		fmt.Fprintf(console, "%s-format postgres needs POSTGRES_DSN in the config%s\n",
+			etherscan.ColorRed, etherscan.ColorReset)
+		exit(ExitConfig)
+	}
+
+	// Сети без пресета доступны через собственный экземпляр Blockscout
+	chain, err := chains.Get(f.Chain)
+	if err != nil && (sourceName == "blockscout" && cfg.BlockscoutURL != "" || !mode.online()) {
+		chain, err = chains.Custom(f.Chain), nil
+	}
+	if err != nil {
+		fmt.Fprintf(console, "%s%v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+		exit(ExitValidation)
+	}
+
+	// Пустой контракт означает все токены адреса
+	allTokens := strings.EqualFold(f.Token, chains.AllTokens)
+	contract := selectContract(chain, cfg.Contract, f.Token)
+	if contract == "" && !allTokens {
+		fmt.Fprintf(console, "%sNo default token for %s, specify a contract with -token%s\n",
+			etherscan.ColorRed, chain.Title, etherscan.ColorReset)
+		exit(ExitValidation)
+	}
+	if f.Token != "" && contract != "" && !isValidEthereumAddress(contract) {
+		fmt.Fprintf(console, "%sInvalid -token %q, use usdt, usdc, all or a contract address%s\n",
+			etherscan.ColorRed, f.Token, etherscan.ColorReset)
+		exit(ExitValidation)
+	}
+
+	// Ctrl-C stops the download, transfers fetched so far are still saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	resolver, err := tokens.NewResolver(f.WorkDir, chain.Name)
+	if err != nil {
+		fmt.Fprintf(console, "%sError loading token metadata: %v%s\n",
+			etherscan.ColorRed, err, etherscan.ColorReset)
+		exit(ExitConfig)
+	}
+	resolver.Log = console
+
+	settings := crawlSettings{
+		Store:     state.NewStore(f.WorkDir),
+		Resolver:  resolver,
+		Chain:     chain,
+		Contract:  contract,
+		AllTokens: allTokens,
+		Sync: syncOptions{
+			Full:   f.Full,
+			Resume: f.Resume,
+		},
+		Direction:      f.Direction,
+		OpeningBalance: f.OpeningBalance,
+		CheckBalance:   f.CheckBalance,
+	}
+
+	if mode.online() {
+		// Create the transfer source selected by the flag or the configuration.
+		// In batch mode all addresses share it and its rate limit.
+		settings.Source, err = source.New(sourceName, source.Config{
+			Chain:            chain,
+			APIKey:           cfg.APIKey,
+			Contract:         contract,
+			RPCURL:           cfg.RPCURL,
+			RateLimit:        f.Rate,
+			BlockscoutURL:    cfg.BlockscoutURL,
+			BlockscoutAPIKey: cfg.BlockscoutAPIKey,
+			Log:              console,
+		})
+		if err != nil {
+			fmt.Fprintf(console, "%sError creating transfer source: %v%s\n",
+				etherscan.ColorRed, err, etherscan.ColorReset)
+			exit(ExitConfig)
+		}
+
+		// Даты -from/-to переводятся в номера блоков через источник
+		settings.Sync.StartBlock, settings.Sync.EndBlock, err = resolveBlockRange(ctx, settings.Source, f.From, f.To)
+		if err != nil {
+			fmt.Fprintf(console, "%s%v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+			exit(exitCode(err))
+		}
+	} else {
+		// Без источника даты ограничивают время трансферов
+		start, end, err := parseBlockRange(f.From, f.To)
+		if err != nil {
+			fmt.Fprintf(console, "%s%v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+			exit(exitCode(err))
+		}
+		settings.Sync.StartBlock, settings.Since = start.Block, start.Time
+		settings.Sync.EndBlock, settings.Until = end.Block, end.Time
+	}
+
+	// Обработка результата каждого адреса
+	handle := func(result crawlResult) error {
+		switch mode {
+		case modeSync, modeExport:
+			return saveOutputs(result, outputs)
+		case modeReport:
+			return printReport(result)
+		default:
+			fmt.Fprintf(console, "%sStored %d transactions of %s in %s%s\n",
+				etherscan.ColorGreen, result.Count, result.Address, f.WorkDir, etherscan.ColorReset)
+		}
+		return nil
+	}
+
+	if len(entries) > 0 {
+		fmt.Fprintf(console, "%sProcessing %s transactions for %d addresses, %d at a time%s\n",
+			etherscan.ColorGreen, chain.Title, len(entries), f.Concurrency, etherscan.ColorReset)
+
+		// Сводная книга со всеми адресами
+		workbook := (mode == modeSync || mode == modeExport) && outputs.Has("excel")
+		results := runBatch(ctx, settings, entries, f.Concurrency, workbook, handle)
+		stop()
+
+		workbookFailed := false
+		if workbook {
+			meta := output.Meta{Chain: chain, AllTokens: allTokens, Log: console}
+			if !allTokens {
+				meta.Token = tokenSymbol(resolver, contract)
+			}
+			filename, err := output.SaveBatchExcel(results, meta)
+			if err != nil {
+				fmt.Fprintf(console, "%sError saving combined Excel file: %v%s\n",
+					etherscan.ColorRed, err, etherscan.ColorReset)
+				workbookFailed = true
+			} else {
+				fmt.Fprintf(console, "%sCombined workbook saved to `%s`%s\n",
+					etherscan.ColorGreen, filename, etherscan.ColorReset)
+			}
+		}
+
+		code := reportBatch(results, invalid)
+		if workbookFailed && code == ExitOK {
+			code = ExitOutput
+		}
+		exit(code)
+	}
+
+	if mode.online() {
+		fmt.Fprintf(console, "%sFetching %s transactions for address: %s%s\n",
+			etherscan.ColorGreen, chain.Title, address, etherscan.ColorReset)
+	}
+
+	// Get new token transfers page by page on top of previously synced ones
+	result, err := crawlAddress(ctx, settings, address)
+	stop()
+	if err != nil {
+		fmt.Fprintf(console, "%s%v%s\n", etherscan.ColorRed, err, etherscan.ColorReset)
+		exit(exitCode(err))
+	}
+
+	// Save the transfers in the requested format(s)
+	if err := handle(result); err != nil {
+		exit(exitCode(err))
+	}
+
+	// Прерванная загрузка сохранена, но неполна
+	if result.Interrupted {
+		exit(ExitFailure)
+	}
+
+	// Финальное сообщение и пауза перед выходом
+	if mode == modeSync || mode == modeExport {
+		fmt.Fprintf(console, "\n%sOperation completed. Files saved in the same directory as the program.%s\n",
+			etherscan.ColorGreen, etherscan.ColorReset)
+	}
+
+	waitForEnter()
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/output"
+	"ethcrawler/pkg/source"
+	"ethcrawler/pkg/state"
+	"ethcrawler/pkg/tokens"
+)
+
+// crawlSettings — общие для всех адресов параметры загрузки
+type crawlSettings struct {
+	Source    source.TransferSource // nil — только ранее загруженные данные
+	Store     *state.Store
+	Resolver  *tokens.Resolver
+	Chain     chains.Chain
+	Contract  string // Пустой при AllTokens
+	AllTokens bool
+	Sync      syncOptions
+
+	// Диапазон по времени для данных без доступа к источнику, где даты
+	// нельзя перевести в блоки. Нулевое значение — без ограничения.
+	Since time.Time
+	Until time.Time
+
+	Direction      string // in, out или all
+	OpeningBalance string // В единицах токена, пустая строка — 0
+	CheckBalance   bool
+}
+
+// crawlResult — трансферы одного адреса, готовые к сохранению. Сами трансферы
+// не хранятся: Rows читает их из хранилища при каждом сохранении.
+type crawlResult struct {
+	Address     string
+	Label       string
+	Rows        output.Rows
+	Count       int // Число трансферов в Rows
+	Ledgers     []ledger.Ledger
+	Meta        output.Meta
+	Progress    models.Progress
+	Checkpoint  *state.Checkpoint // Состояние синхронизации, nil если адрес не загружался
+	Interrupted bool              // Загрузка прервана, трансферы неполные
+}
+
+// crawlAddress загружает, форматирует и сверяет трансферы одного адреса.
+// Прерывание через ctx не считается ошибкой: возвращаются уже загруженные
+// трансферы с флагом Interrupted. Ошибки несут код завершения.
+func crawlAddress(ctx context.Context, s crawlSettings, address string) (crawlResult, error) {
+	result := crawlResult{Address: address}
+
+	key := state.Key{Chain: s.Chain.Name, Contract: s.Contract, Address: address}
+	if s.AllTokens {
+		key.Contract = chains.AllTokens
+	}
+
+	// Полная история адреса покрывает любой диапазон, иначе загружается
+	// только история начиная с -from
+	if s.Sync.StartBlock > 0 {
+		_, found, err := s.Store.Checkpoint(key)
+		if err != nil {
+			return result, withCode(ExitOutput, fmt.Errorf("error reading sync state: %v", err))
+		}
+		if !found || s.Sync.Full {
+			key.StartBlock = s.Sync.StartBlock
+		}
+	}
+
+	h := history{
+		Store:     s.Store,
+		Key:       key,
+		Sync:      s.Sync,
+		Address:   address,
+		Resolver:  s.Resolver,
+		Contract:  s.Contract,
+		Since:     s.Since,
+		Until:     s.Until,
+		Direction: s.Direction,
+		Code:      ExitAPI,
+	}
+	if s.Source == nil {
+		// Неверные данные могли попасть только в сохраненную историю
+		h.Code = ExitOutput
+		if err := requireStored(s.Store, key); err != nil {
+			return result, err
+		}
+	} else {
+		pending, progress, err := syncTransfers(ctx, s.Source, s.Store, key, s.Sync)
+		result.Progress = progress
+		result.Interrupted = errors.Is(err, context.Canceled)
+		if result.Interrupted {
+			fmt.Fprintf(console, "\n%sInterrupted %s after %d pages (%d transactions, up to block %d). Saving partial results, run again with -resume to continue.%s\n",
+				etherscan.ColorYellow, address, progress.Pages, progress.Transfers, progress.LastBlock, etherscan.ColorReset)
+		} else if err != nil {
+			// Ошибки данных синхронизации уже несут свой код, остальные — ошибки источника
+			return result, withDefaultCode(ExitAPI, fmt.Errorf("error fetching transfers: %w", err))
+		}
+		h.Pending = pending
+	}
+	if cp, found, err := s.Store.Checkpoint(key); err == nil && found {
+		result.Checkpoint = &cp
+	}
+
+	// Метаданные токенов (символ, десятичные знаки) берутся из ответов API,
+	// встроенной таблицы или файла переопределений и кешируются на диске
+	err := h.eachBlock(func(block []models.ERC20Transfer) error {
+		s.Resolver.Learn(block)
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	if err := s.Resolver.Save(); err != nil {
+		fmt.Fprintf(console, "%sError saving token metadata: %v%s\n",
+			etherscan.ColorYellow, err, etherscan.ColorReset)
+	}
+
+	// Баланс считается по всем трансферам, до фильтрации по направлению
+	h.Openings, err = openingBalances(s.Resolver, s.Contract, s.OpeningBalance)
+	if err != nil {
+		return result, withCode(ExitValidation, err)
+	}
+	total := 0
+	ledgers, err := h.scan(func(tx models.FormattedTransfer) error {
+		total++
+		if tx.Direction.Matches(s.Direction) {
+			result.Count++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+	ledgers = completeLedgers(ledgers, s.Resolver, s.Contract, h.Openings)
+	if s.CheckBalance && s.Source != nil {
+		// Сверка с сетью имеет смысл только для полностью загруженной истории
+		// до последнего блока. История с -from начинается с нуля и сходится
+		// с сетью, только если задан баланс на начало диапазона.
+		if result.Interrupted {
+			fmt.Fprintf(console, "%sSkipping the on-chain balance check, the download is incomplete%s\n",
+				etherscan.ColorYellow, etherscan.ColorReset)
+		} else if s.Sync.StartBlock > 0 && s.OpeningBalance == "" {
+			fmt.Fprintf(console, "%sSkipping the on-chain balance check, the history starts at block %d, set -opening-balance to check it%s\n",
+				etherscan.ColorYellow, s.Sync.StartBlock, etherscan.ColorReset)
+		} else if s.Sync.EndBlock > 0 {
+			fmt.Fprintf(console, "%sSkipping the on-chain balance check, it needs the history up to the latest block (no -to)%s\n",
+				etherscan.ColorYellow, etherscan.ColorReset)
+		} else if err := checkBalances(ctx, s.Source, ledgers, address); err != nil {
+			fmt.Fprintf(console, "%sError checking balances: %v%s\n",
+				etherscan.ColorYellow, err, etherscan.ColorReset)
+		}
+	}
+	reportBalances(ledgers)
+
+	// Оставляем только входящие или исходящие трансферы, если задан -direction
+	if s.Direction != "all" {
+		fmt.Fprintf(console, "%sKeeping %d of %d transactions with direction %s%s\n",
+			etherscan.ColorGreen, result.Count, total, s.Direction, etherscan.ColorReset)
+	}
+
+	result.Rows = h.each
+	result.Ledgers = ledgers
+	result.Meta = output.Meta{
+		Address:   address,
+		Chain:     s.Chain,
+		Contract:  s.Contract,
+		AllTokens: s.AllTokens,
+		FromBlock: s.Sync.StartBlock,
+		ToBlock:   s.Sync.EndBlock,
+		Since:     s.Since,
+		Until:     s.Until,
+		Ledgers:   ledgers,
+		Log:       console,
+	}
+	if !s.AllTokens {
+		result.Meta.Token = tokenSymbol(s.Resolver, s.Contract)
+	}
+
+	return result, nil
+}
+
+// inTimeRange оставляет трансферы между since и until включительно, нулевая
+// граница не ограничивает
+func inTimeRange(transfers []models.FormattedTransfer, since, until time.Time) []models.FormattedTransfer {
+	if since.IsZero() && until.IsZero() {
+		return transfers
+	}
+
+	filtered := transfers[:0]
+	for _, tx := range transfers {
+		if !since.IsZero() && tx.TimeStamp < since.Unix() || !until.IsZero() && tx.TimeStamp > until.Unix() {
+			continue
+		}
+		filtered = append(filtered, tx)
+	}
+	return filtered
+}
+
+// tokenSymbol возвращает символ токена для имен файлов и листов
+func tokenSymbol(resolver *tokens.Resolver, contract string) string {
+	if md, ok := resolver.Resolve(contract); ok && md.Symbol != "" {
+		return md.Symbol
+	}
+	return "TOKEN"
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/output"
+	"ethcrawler/pkg/source"
+	"ethcrawler/pkg/state"
+	"ethcrawler/pkg/tokens"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// testSettings crawls USDT transfers of a fake source with -check-balance
+func testSettings(t *testing.T, fake *source.Fake) crawlSettings {
+	t.Helper()
+	dir := t.TempDir()
+	resolver, err := tokens.NewResolver(dir, chains.Ethereum.Name)
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	return crawlSettings{
+		Source:       fake,
+		Store:        state.NewStore(dir),
+		Resolver:     resolver,
+		Chain:        chains.Ethereum,
+		Contract:     chains.Ethereum.USDT,
+		Direction:    "all",
+		CheckBalance: true,
+	}
+}
+
+// transfersOf reads the transfers of a crawl result
+func transfersOf(t *testing.T, result crawlResult) []models.FormattedTransfer {
+	t.Helper()
+	transfers, err := result.Rows.Collect()
+	if err != nil {
+		t.Fatalf("reading transfers: %v", err)
+	}
+	if len(transfers) != result.Count {
+		t.Errorf("read %d transfers, the result counted %d", len(transfers), result.Count)
+	}
+	return transfers
+}
+
+func TestCrawlCheckBalance(t *testing.T) {
+	transfers := []models.ERC20Transfer{
+		usdtTransfer(100, 0, otherAddress, testAddress, "100000000"), // +100
+		usdtTransfer(150, 1, testAddress, otherAddress, "30000000"),  // -30
+		usdtTransfer(200, 0, testAddress, testAddress, "5000000"),    // self, no change
+		usdtTransfer(250, 4, otherAddress, testAddress, "250000"),    // +0.25
+	}
+
+	tests := []struct {
+		name     string
+		onChain  string // Base units
+		mismatch bool
+	}{
+		{"matching", "70250000", false},
+		{"missing transfers", "80250000", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := source.NewFake(2, transfers...)
+			balance, _ := new(big.Int).SetString(tt.onChain, 10)
+			fake.Balances = map[string]*big.Int{chains.Ethereum.USDT: balance}
+
+			result, err := crawlAddress(context.Background(), testSettings(t, fake), testAddress)
+			if err != nil {
+				t.Fatalf("crawlAddress: %v", err)
+			}
+
+			directions := make(map[models.Direction]int)
+			for _, tx := range transfersOf(t, result) {
+				directions[tx.Direction]++
+			}
+			if directions[models.DirectionIn] != 2 || directions[models.DirectionOut] != 1 || directions[models.DirectionSelf] != 1 {
+				t.Errorf("directions = %v, want 2 in, 1 out and 1 self", directions)
+			}
+
+			if len(result.Ledgers) != 1 {
+				t.Fatalf("got %d ledgers, want 1", len(result.Ledgers))
+			}
+			l := result.Ledgers[0]
+			closing, _ := decimal.ParseDecimal("70.25", 6)
+			if l.Closing.Cmp(closing) != 0 {
+				t.Errorf("closing balance = %s, want 70.25", l.Closing)
+			}
+			if l.OnChain == nil {
+				t.Fatal("the on-chain balance was not checked")
+			}
+			if l.Mismatch() != tt.mismatch {
+				t.Errorf("mismatch = %v with on-chain %s, want %v", l.Mismatch(), l.OnChain, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestCrawlDirectionFilter(t *testing.T) {
+	fake := source.NewFake(0,
+		usdtTransfer(100, 0, otherAddress, testAddress, "100000000"),
+		usdtTransfer(150, 1, testAddress, otherAddress, "30000000"),
+		usdtTransfer(200, 0, testAddress, testAddress, "5000000"),
+	)
+	fake.Balances = map[string]*big.Int{chains.Ethereum.USDT: big.NewInt(70000000)}
+
+	s := testSettings(t, fake)
+	s.Direction = "out"
+	result, err := crawlAddress(context.Background(), s, testAddress)
+	if err != nil {
+		t.Fatalf("crawlAddress: %v", err)
+	}
+
+	// Transfers to itself are both incoming and outgoing
+	transfers := transfersOf(t, result)
+	if len(transfers) != 2 {
+		t.Fatalf("kept %d transfers, want the outgoing one and the self transfer", len(transfers))
+	}
+	for _, tx := range transfers {
+		if tx.Direction == models.DirectionIn {
+			t.Errorf("kept incoming transfer %s", tx.Hash)
+		}
+	}
+	// The balance still covers every transfer
+	if l := result.Ledgers[0]; l.Mismatch() {
+		t.Errorf("balance %s mismatches on-chain %s after filtering", l.Closing, l.OnChain)
+	}
+}
+
+func TestCrawlUnindexedTransactionsInOneBlock(t *testing.T) {
+	// Without log indexes the transfers are numbered per transaction, so the
+	// block keeps the order of the source: in 100, then out 50 twice
+	in := usdtTransfer(100, -1, otherAddress, testAddress, "100000000")
+	in.Hash = "0xa"
+	out := usdtTransfer(100, -1, testAddress, otherAddress, "50000000")
+	out.Hash = "0xb"
+	fake := source.NewFake(0, in, out, out)
+	fake.Balances = map[string]*big.Int{chains.Ethereum.USDT: big.NewInt(0)}
+
+	result, err := crawlAddress(context.Background(), testSettings(t, fake), testAddress)
+	if err != nil {
+		t.Fatalf("crawlAddress: %v", err)
+	}
+
+	var balances []string
+	for _, tx := range transfersOf(t, result) {
+		balances = append(balances, tx.Balance.String())
+	}
+	if got := strings.Join(balances, ","); got != "100,50,0" {
+		t.Errorf("running balances %s, want 100,50,0", got)
+	}
+	if l := result.Ledgers[0]; l.Mismatch() {
+		t.Errorf("balance %s mismatches on-chain %s with %d negative", l.Closing, l.OnChain, l.Negative)
+	}
+}
+
+func TestCrawlCheckBalanceFromBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		opening string
+		checked bool
+	}{
+		// Without the balance at block 200 the history would not add up
+		{"no opening balance", "", false},
+		{"opening balance", "70", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := source.NewFake(0,
+				usdtTransfer(100, 0, otherAddress, testAddress, "100000000"),
+				usdtTransfer(150, 1, testAddress, otherAddress, "30000000"),
+				usdtTransfer(200, 0, testAddress, testAddress, "5000000"),
+				usdtTransfer(250, 4, otherAddress, testAddress, "250000"),
+			)
+			fake.Balances = map[string]*big.Int{chains.Ethereum.USDT: big.NewInt(70250000)}
+
+			s := testSettings(t, fake)
+			s.Sync.StartBlock = 200
+			s.OpeningBalance = tt.opening
+			result, err := crawlAddress(context.Background(), s, testAddress)
+			if err != nil {
+				t.Fatalf("crawlAddress: %v", err)
+			}
+			if transfers := transfersOf(t, result); len(transfers) != 2 {
+				t.Fatalf("got %d transfers from block 200, want 2", len(transfers))
+			}
+
+			l := result.Ledgers[0]
+			if checked := l.OnChain != nil; checked != tt.checked {
+				t.Errorf("on-chain balance checked = %v, want %v", checked, tt.checked)
+			}
+			if l.Mismatch() {
+				t.Errorf("balance %s flagged as mismatch (on-chain %v)", l.Closing, l.OnChain)
+			}
+		})
+	}
+}
+
+// keyDir creates and returns the state directory of testKey
+func keyDir(t *testing.T, store *state.Store) string {
+	t.Helper()
+	key := testKey()
+	dir := filepath.Join(store.Dir, key.Chain, strings.ToLower(key.Contract), key.Address)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCrawlExitCodes(t *testing.T) {
+	transfers := []models.ERC20Transfer{
+		usdtTransfer(100, 0, otherAddress, testAddress, "100000000"),
+		usdtTransfer(150, 1, testAddress, otherAddress, "30000000"),
+	}
+
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *crawlSettings, fake *source.Fake)
+		code  int
+	}{
+		{"source error", func(t *testing.T, s *crawlSettings, fake *source.Fake) {
+			fake.FailAfter = 1
+			fake.Err = errors.New("connection reset by peer")
+		}, ExitAPI},
+		{"unwritable work directory", func(t *testing.T, s *crawlSettings, fake *source.Fake) {
+			// A file where the store expects a directory
+			path := filepath.Join(t.TempDir(), "data")
+			if err := os.WriteFile(path, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			s.Store = state.NewStore(path)
+		}, ExitOutput},
+		{"corrupted checkpoint", func(t *testing.T, s *crawlSettings, fake *source.Fake) {
+			if err := os.WriteFile(filepath.Join(keyDir(t, s.Store), "checkpoint.json"), []byte("{"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}, ExitOutput},
+		{"journal cannot be created", func(t *testing.T, s *crawlSettings, fake *source.Fake) {
+			if err := os.Mkdir(filepath.Join(keyDir(t, s.Store), "journal.ndjson"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}, ExitOutput},
+		{"nothing downloaded", func(t *testing.T, s *crawlSettings, fake *source.Fake) {
+			s.Source = nil
+		}, ExitValidation},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := source.NewFake(1, transfers...)
+			s := testSettings(t, fake)
+			tt.setup(t, &s, fake)
+
+			_, err := crawlAddress(context.Background(), s, testAddress)
+			if code := exitCode(err); code != tt.code {
+				t.Errorf("exit code = %d (%v), want %d", code, err, tt.code)
+			}
+		})
+	}
+}
+
+func TestCrawlAllTokens(t *testing.T) {
+	const dai = "0x6b175474e89094c44da98b954eedeac495271d0f"
+	daiTransfer := func(block int, from, to, value string) models.ERC20Transfer {
+		tx := usdtTransfer(block, 0, from, to, value)
+		tx.Hash += "da"
+		tx.LogIndex = "1"
+		tx.ContractAddress = dai
+		tx.TokenName, tx.TokenSymbol, tx.TokenDecimal = "Dai Stablecoin", "DAI", "18"
+		return tx
+	}
+	fake := source.NewFake(2,
+		usdtTransfer(100, 0, otherAddress, testAddress, "100000000"),       // +100 USDT
+		daiTransfer(100, otherAddress, testAddress, "5000000000000000000"), // +5 DAI
+		daiTransfer(150, testAddress, otherAddress, "1500000000000000000"), // -1.5 DAI
+		usdtTransfer(200, 0, testAddress, otherAddress, "40000000"),        // -40 USDT
+		usdtTransfer(250, 0, otherAddress, testAddress, "2500000"),         // +2.5 USDT
+	)
+	s := testSettings(t, fake)
+	s.Contract = ""
+	s.AllTokens = true
+	s.CheckBalance = false
+
+	result, err := crawlAddress(context.Background(), s, testAddress)
+	if err != nil {
+		t.Fatalf("crawlAddress: %v", err)
+	}
+	if q := fake.Queries()[0]; q.Contract != "" {
+		t.Errorf("queried contract %q, want every token", q.Contract)
+	}
+
+	// Ledgers are ordered by contract
+	want := []struct{ contract, symbol, closing string }{
+		{dai, "DAI", "3.5"},
+		{strings.ToLower(chains.Ethereum.USDT), "USDT", "62.5"},
+	}
+	if len(result.Ledgers) != len(want) {
+		t.Fatalf("got %d ledgers, want %d", len(result.Ledgers), len(want))
+	}
+	for i, w := range want {
+		l := result.Ledgers[i]
+		if l.Contract != w.contract || l.Symbol != w.symbol || l.Closing.String() != w.closing {
+			t.Errorf("ledger %d = %s %s closing %s, want %s %s closing %s",
+				i, l.Contract, l.Symbol, l.Closing, w.contract, w.symbol, w.closing)
+		}
+	}
+
+	// Transfers are grouped by contract, each with its own running balance
+	groups := output.GroupByToken(transfersOf(t, result))
+	if len(groups) != 2 || groups[0].Symbol != "DAI" || groups[1].Symbol != "USDT" {
+		t.Fatalf("groups %+v, want DAI and USDT", groups)
+	}
+	if n := len(groups[0].Transfers); n != 2 {
+		t.Errorf("DAI group has %d transfers, want 2", n)
+	}
+	if n := len(groups[1].Transfers); n != 3 {
+		t.Errorf("USDT group has %d transfers, want 3", n)
+	}
+	if last := groups[1].Transfers[2]; last.Balance.String() != "62.5" || last.Decimals != 6 {
+		t.Errorf("last USDT transfer has balance %s with %d decimals, want 62.5 with 6", last.Balance, last.Decimals)
+	}
+
+	// The workbook has a sheet per token after the summary
+	filename := filepath.Join(t.TempDir(), "tokens.xlsx")
+	if err := output.SaveExcelRows(result.Rows, result.Meta, filename); err != nil {
+		t.Fatalf("SaveExcelRows: %v", err)
+	}
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+	if sheets := f.GetSheetList(); len(sheets) < 3 || sheets[0] != "Summary" || sheets[1] != "DAI" || sheets[2] != "USDT" {
+		t.Errorf("sheets %v, want Summary, DAI and USDT first", sheets)
+	}
+}
+
+func TestInvalidToken(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, EnvFileName)
+	if err := os.WriteFile(conf, []byte("ETHERSCAN_API_KEY=key\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A mistyped token name is not taken as a contract address
+	for _, token := range []string{"usdtt", "0x12"} {
+		out, code := runCommandProcess(t, dir, "-a", testAddress, "-token", token, "-config", conf, "-non-interactive")
+		if code != ExitValidation || !strings.Contains(out, `Invalid -token "`+token+`"`) {
+			t.Errorf("-token %s: exit code %d with output\n%s\nwant code %d", token, code, out, ExitValidation)
+		}
+	}
+}
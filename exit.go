@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"ethcrawler/pkg/etherscan"
+)
+
+// Коды завершения программы. Код 2 совпадает с кодом пакета flag для
+// неверных аргументов командной строки.
+const (
+	ExitOK         = 0
+	ExitFailure    = 1 // Прочие ошибки
+	ExitValidation = 2 // Неверные аргументы или входные данные
+	ExitConfig     = 3 // Нет или неверна конфигурация, например API ключ
+	ExitAPI        = 4 // Ошибка источника данных
+	ExitOutput     = 5 // Ошибка записи выходных файлов или данных синхронизации
+)
+
+// nonInteractive отключает запросы ввода и паузу перед выходом
+var nonInteractive bool
+
+// console принимает сообщения и запросы ввода. При -o - это stderr, так как
+// стандартный вывод занят данными.
+var console io.Writer = os.Stdout
+
+// stdinIsTerminal сообщает, подключен ли стандартный ввод к терминалу
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null тоже символьное устройство, но не терминал
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// codedError — ошибка с кодом завершения программы
+type codedError struct {
+	Code int
+	Err  error
+}
+
+func (e *codedError) Error() string { return e.Err.Error() }
+
+func (e *codedError) Unwrap() error { return e.Err }
+
+// withCode связывает ошибку с кодом завершения, nil остается nil
+func withCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{Code: code, Err: err}
+}
+
+// withDefaultCode связывает ошибку с кодом завершения, если у нее еще нет
+// своего кода
+func withDefaultCode(code int, err error) error {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	return withCode(code, err)
+}
+
+// exitCode возвращает код завершения для ошибки
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.Code
+	}
+	return ExitFailure
+}
+
+// exit завершает программу, в интерактивном режиме после нажатия Enter
+func exit(code int) {
+	waitForEnter()
+	os.Exit(code)
+}
+
+// waitForEnter ожидает нажатия Enter, в неинтерактивном режиме сразу возвращается
+func waitForEnter() {
+	if nonInteractive {
+		return
+	}
+	fmt.Fprintf(console, "\n%sPress Enter to exit...%s",
+		etherscan.ColorYellow, etherscan.ColorReset)
+	bufio.NewReader(os.Stdin).ReadBytes('\n')
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/output"
+	"ethcrawler/pkg/store"
+)
+
+// outputFormat — формат выходного файла. Форматы с Write можно выводить в
+// stdout, остальные сохраняются только через Save.
+type outputFormat struct {
+	Name  string
+	Title string // Название файла в сообщениях, например "Excel file"
+	Ext   string
+	Write func(w io.Writer, r crawlResult, opts outputOptions) error
+	Save  func(r crawlResult, opts outputOptions, filename string) error
+
+	// Target возвращает общий для всех адресов файл или базу данных, без него
+	// файл называется по адресу
+	Target func(opts outputOptions) string
+}
+
+// outputFormats перечисляет форматы в порядке сохранения. Excel, CSV, TSV,
+// NDJSON и Parquet читают трансферы из хранилища построчно, остальные
+// форматы — целиком в память.
+var outputFormats = []outputFormat{
+	{Name: "text", Title: "text file", Ext: "txt", Write: inMemory(func(w io.Writer, transfers []models.FormattedTransfer, r crawlResult, _ outputOptions) error {
+		return output.WriteText(w, transfers, r.Meta)
+	})},
+	{Name: "excel", Title: "Excel file", Ext: "xlsx", Save: func(r crawlResult, _ outputOptions, filename string) error {
+		return output.SaveExcelRows(r.Rows, r.Meta, filename)
+	}},
+	{Name: "csv", Title: "CSV file", Ext: "csv", Write: func(w io.Writer, r crawlResult, opts outputOptions) error {
+		return output.WriteCSV(w, r.Rows, opts.Columns)
+	}},
+	{Name: "tsv", Title: "TSV file", Ext: "tsv", Write: func(w io.Writer, r crawlResult, opts outputOptions) error {
+		return output.WriteTSV(w, r.Rows, opts.Columns)
+	}},
+	{Name: "json", Title: "JSON file", Ext: "json", Write: inMemory(func(w io.Writer, transfers []models.FormattedTransfer, r crawlResult, _ outputOptions) error {
+		return output.WriteJSON(w, transfers, r.Meta)
+	})},
+	{Name: "ndjson", Title: "NDJSON file", Ext: "ndjson", Write: func(w io.Writer, r crawlResult, _ outputOptions) error {
+		return output.WriteNDJSON(w, r.Rows, r.Meta)
+	}},
+	{Name: "parquet", Title: "Parquet file", Ext: "parquet", Write: func(w io.Writer, r crawlResult, _ outputOptions) error {
+		return output.WriteParquet(w, r.Rows, r.Meta)
+	}},
+	{Name: "sqlite", Title: "SQLite database", Save: saveSQLite, Target: func(outputOptions) string {
+		return store.DefaultFileName
+	}},
+	{Name: "postgres", Title: "PostgreSQL database", Save: savePostgres, Target: func(opts outputOptions) string {
+		return maskDSN(opts.PostgresDSN)
+	}},
+}
+
+// inMemory читает трансферы результата в память для форматов, которым нужен
+// весь список сразу
+func inMemory(write func(w io.Writer, transfers []models.FormattedTransfer, r crawlResult, opts outputOptions) error) func(io.Writer, crawlResult, outputOptions) error {
+	return func(w io.Writer, r crawlResult, opts outputOptions) error {
+		transfers, err := r.Rows.Collect()
+		if err != nil {
+			return err
+		}
+		return write(w, transfers, r, opts)
+	}
+}
+
+// save сохраняет трансферы в filename
+func (f outputFormat) save(r crawlResult, opts outputOptions, filename string) error {
+	if f.Save != nil {
+		return f.Save(r, opts, filename)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	if err := f.Write(file, r, opts); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// saveSQLite добавляет адрес, его токены, трансферы и состояние синхронизации
+// в базу SQLite. Повторное сохранение обновляет существующие строки.
+func saveSQLite(r crawlResult, _ outputOptions, filename string) error {
+	db, err := store.OpenSQLite(filename)
+	if err != nil {
+		return err
+	}
+	snapshot, err := snapshotOf(r)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if _, err := db.Save(context.Background(), snapshot); err != nil {
+		db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// savePostgres добавляет адрес, его токены, трансферы и состояние
+// синхронизации в базу PostgreSQL из POSTGRES_DSN. Повторное сохранение
+// обновляет существующие строки.
+func savePostgres(r crawlResult, opts outputOptions, _ string) error {
+	ctx := context.Background()
+	db, err := store.OpenPostgres(ctx, opts.PostgresDSN)
+	if err != nil {
+		return err
+	}
+	snapshot, err := snapshotOf(r)
+	if err != nil {
+		db.Close(ctx)
+		return err
+	}
+	if _, err := db.Save(ctx, snapshot); err != nil {
+		db.Close(ctx)
+		return err
+	}
+	return db.Close(ctx)
+}
+
+// snapshotOf собирает данные адреса для записи в базу
+func snapshotOf(r crawlResult) (store.Snapshot, error) {
+	transfers, err := r.Rows.Collect()
+	if err != nil {
+		return store.Snapshot{}, err
+	}
+	snapshot := store.Snapshot{
+		Chain:     r.Meta.Chain.Name,
+		Address:   r.Address,
+		Label:     r.Label,
+		Transfers: transfers,
+	}
+	if cp := r.Checkpoint; cp != nil {
+		snapshot.Checkpoint = &store.Checkpoint{
+			Contract:  cp.Contract,
+			FromBlock: cp.From,
+			SyncedTo:  cp.SyncedTo,
+			UpdatedAt: cp.UpdatedAt,
+		}
+	}
+	return snapshot, nil
+}
+
+// formatAliases раскрывает сокращения -format
+var formatAliases = map[string][]string{
+	"both": {"text", "excel"},
+}
+
+// formatNames возвращает имена форматов для справки
+func formatNames() string {
+	names := make([]string, len(outputFormats))
+	for i, format := range outputFormats {
+		names[i] = format.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// stdoutOutput — значение -o для вывода в стандартный вывод
+const stdoutOutput = "-"
+
+// outputOptions — выбранные форматы и их настройки
+type outputOptions struct {
+	Formats map[string]bool
+	Columns []string // Колонки CSV и TSV
+	Output  string   // Файл единственного формата или "-" для stdout, пустой — имя по умолчанию
+	Stdout  io.Writer
+
+	PostgresDSN string // POSTGRES_DSN из конфигурации
+}
+
+// Has сообщает, выбран ли формат
+func (o outputOptions) Has(name string) bool {
+	return o.Formats[name]
+}
+
+// Shared сообщает, пишут ли все выбранные форматы в общий для всех адресов
+// файл или базу данных
+func (o outputOptions) Shared() bool {
+	for _, format := range outputFormats {
+		if o.Has(format.Name) && format.Target == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOutputOptions разбирает -format (один формат или список через запятую),
+// -columns и -o. Вывод в файл с заданным именем или в stdout возможен только
+// для одного формата.
+func parseOutputOptions(format, columns, out string) (outputOptions, error) {
+	opts := outputOptions{Formats: make(map[string]bool), Output: out}
+	for _, name := range strings.Split(format, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		names, ok := formatAliases[name]
+		if !ok {
+			names = []string{name}
+		}
+		for _, name := range names {
+			if !isOutputFormat(name) {
+				return opts, fmt.Errorf("unknown output format %q, use %s or both", name, formatNames())
+			}
+			opts.Formats[name] = true
+		}
+	}
+
+	if out != "" {
+		if len(opts.Formats) != 1 {
+			return opts, fmt.Errorf("-o needs a single -format, not %s", format)
+		}
+		if opts.Has("postgres") {
+			return opts, fmt.Errorf("postgres writes to POSTGRES_DSN from the config, not to -o")
+		}
+		if out == stdoutOutput && (opts.Has("excel") || opts.Has("sqlite")) {
+			return opts, fmt.Errorf("%s cannot be written to stdout, use text, csv, tsv, json, ndjson or parquet", format)
+		}
+	}
+
+	var err error
+	opts.Columns, err = output.ParseColumns(columns)
+	return opts, err
+}
+
+// isOutputFormat сообщает, есть ли формат с таким именем
+func isOutputFormat(name string) bool {
+	for _, format := range outputFormats {
+		if format.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// saveOutputs сохраняет трансферы в запрошенных форматах и возвращает
+// первую ошибку с кодом ExitOutput, продолжая сохранять остальные форматы
+func saveOutputs(result crawlResult, opts outputOptions) error {
+	var firstErr error
+
+	for _, format := range outputFormats {
+		if !opts.Has(format.Name) {
+			continue
+		}
+
+		// Стандартный вывод занят данными, сообщения идут в stderr
+		if opts.Output == stdoutOutput {
+			if err := format.Write(opts.Stdout, result, opts); err != nil {
+				fmt.Fprintf(console, "%sError writing %s to stdout: %v%s\n",
+					etherscan.ColorRed, format.Name, err, etherscan.ColorReset)
+				return withCode(ExitOutput, fmt.Errorf("error writing %s to stdout: %v", format.Name, err))
+			}
+			continue
+		}
+
+		filename := opts.Output
+		if filename == "" && format.Target != nil {
+			filename = format.Target(opts)
+		}
+		if filename == "" {
+			filename = output.GenerateFileName(result.Meta, format.Ext)
+		}
+		if err := format.save(result, opts, filename); err != nil {
+			fmt.Fprintf(console, "%sError saving %s: %v%s\n",
+				etherscan.ColorRed, format.Title, err, etherscan.ColorReset)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error saving %s: %v", format.Title, err)
+			}
+			continue
+		}
+		fmt.Fprintf(console, "%sTransactions saved to `%s`%s\n",
+			etherscan.ColorGreen, filename, etherscan.ColorReset)
+	}
+
+	return withCode(ExitOutput, firstErr)
+}
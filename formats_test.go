@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/output"
+	"ethcrawler/pkg/source"
+)
+
+func TestSaveOutputsToStdout(t *testing.T) {
+	fake := source.NewFake(0,
+		usdtTransfer(100, 0, otherAddress, testAddress, "100000000"),
+		usdtTransfer(150, 1, testAddress, otherAddress, "30000000"),
+	)
+
+	// Messages go to the console, only the data goes to stdout
+	var messages bytes.Buffer
+	defer func(w io.Writer) { console = w }(console)
+	console = &messages
+
+	s := testSettings(t, fake)
+	s.CheckBalance = false
+	result, err := crawlAddress(context.Background(), s, testAddress)
+	if err != nil {
+		t.Fatalf("crawlAddress: %v", err)
+	}
+	if messages.Len() == 0 {
+		t.Errorf("the crawl printed no progress to the console")
+	}
+
+	tests := []struct {
+		format string
+		check  func(t *testing.T, data []byte)
+	}{
+		{"csv", func(t *testing.T, data []byte) {
+			records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			if err != nil || len(records) != 3 || records[0][0] != output.ColumnBlock {
+				t.Errorf("records %v (%v), want a header and 2 rows", records, err)
+			}
+		}},
+		{"tsv", func(t *testing.T, data []byte) {
+			if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 3 || !strings.Contains(lines[1], "\t") {
+				t.Errorf("lines %q, want a header and 2 tab separated rows", lines)
+			}
+		}},
+		{"json", func(t *testing.T, data []byte) {
+			var doc output.JSONDocument
+			if err := json.Unmarshal(data, &doc); err != nil || doc.Count != 2 || len(doc.Transfers) != 2 {
+				t.Errorf("document with %d transfers (%v), want 2", len(doc.Transfers), err)
+			}
+		}},
+		{"ndjson", func(t *testing.T, data []byte) {
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(lines) != 2 {
+				t.Fatalf("got %d lines, want 2", len(lines))
+			}
+			for _, line := range lines {
+				var record output.NDJSONRecord
+				if err := json.Unmarshal([]byte(line), &record); err != nil || record.Address != testAddress {
+					t.Errorf("line %s (%v), want a record of %s", line, err, testAddress)
+				}
+			}
+		}},
+		{"text", func(t *testing.T, data []byte) {
+			if !strings.Contains(string(data), testAddress) {
+				t.Errorf("text output %q, want the address", data)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			opts, err := parseOutputOptions(tt.format, "", stdoutOutput)
+			if err != nil {
+				t.Fatalf("parseOutputOptions: %v", err)
+			}
+			var stdout bytes.Buffer
+			opts.Stdout = &stdout
+			messages.Reset()
+
+			if err := saveOutputs(result, opts); err != nil {
+				t.Fatalf("saveOutputs: %v", err)
+			}
+			tt.check(t, stdout.Bytes())
+			if strings.Contains(stdout.String(), "\x1b[") {
+				t.Errorf("colored messages in stdout:\n%s", stdout.String())
+			}
+			if messages.Len() != 0 {
+				t.Errorf("console output while writing to stdout:\n%s", messages.String())
+			}
+		})
+	}
+}
+
+func TestParseOutputOptionsStdout(t *testing.T) {
+	tests := []struct {
+		format  string
+		wantErr string
+	}{
+		{"ndjson", ""},
+		{"parquet", ""},
+		{"excel", "cannot be written to stdout"},
+		{"sqlite", "cannot be written to stdout"},
+		{"postgres", "not to -o"},
+		{"csv,json", "-o needs a single -format"},
+		{"both", "-o needs a single -format"},
+	}
+	for _, tt := range tests {
+		_, err := parseOutputOptions(tt.format, "", stdoutOutput)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("parseOutputOptions(%q, -o -) = %v, want %q", tt.format, err, tt.wantErr)
+		}
+	}
+}
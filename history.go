@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/state"
+	"ethcrawler/pkg/tokens"
+)
+
+// history — трансферы адреса в хранилище и еще не сохраненные трансферы
+// прерванной загрузки. История читается с диска при каждом проходе, сама она
+// держит в памяти только один блок.
+type history struct {
+	Store   *state.Store
+	Key     state.Key
+	Pending []models.ERC20Transfer // Загружены после сохраненных, но не перенесены в хранилище
+	Sync    syncOptions            // Диапазон блоков результата
+
+	Address  string
+	Resolver *tokens.Resolver
+	Contract string // Токен трансферов без адреса контракта
+
+	// Диапазон по времени, нулевое значение — без ограничения
+	Since time.Time
+	Until time.Time
+
+	Direction string                     // in, out или all
+	Openings  map[string]decimal.Decimal // Балансы токенов на начало истории
+	Code      int                        // Код ошибок неверных данных
+}
+
+// eachBlock передает в fn трансферы из диапазона блоков по одному блоку за
+// раз. Источники отдают трансферы по возрастанию блоков, в этом же порядке
+// они и хранятся. Срез блока используется повторно после возврата из fn.
+func (h history) eachBlock(fn func([]models.ERC20Transfer) error) error {
+	var block []models.ERC20Transfer
+	add := func(tx models.ERC20Transfer) error {
+		if !inBlockRange(tx, h.Sync) {
+			return nil
+		}
+		if len(block) > 0 && block[0].BlockNumber != tx.BlockNumber {
+			if err := fn(block); err != nil {
+				return err
+			}
+			block = block[:0]
+		}
+		block = append(block, tx)
+		return nil
+	}
+
+	if err := h.Store.EachTransfer(h.Key, add); err != nil {
+		return withDefaultCode(ExitOutput, err)
+	}
+	for _, tx := range h.Pending {
+		if err := add(tx); err != nil {
+			return err
+		}
+	}
+	if len(block) > 0 {
+		return fn(block)
+	}
+	return nil
+}
+
+// scan передает в fn все отформатированные трансферы истории с балансом после
+// каждого, до фильтрации по направлению, и возвращает итоговые балансы.
+// Внутри блока трансферы упорядочены по номеру лога, как в ledger.Build;
+// трансферы без номера лога остаются в порядке источника.
+func (h history) scan(fn func(models.FormattedTransfer) error) ([]ledger.Ledger, error) {
+	running := ledger.NewRunning(h.Openings)
+	err := h.eachBlock(func(block []models.ERC20Transfer) error {
+		formatted, err := etherscan.FormatTransfers(block, h.Address)
+		if err != nil {
+			return withCode(h.Code, fmt.Errorf("error formatting transfers: %v", err))
+		}
+		h.Resolver.Annotate(formatted, h.Contract)
+		formatted = inTimeRange(formatted, h.Since, h.Until)
+		sort.SliceStable(formatted, func(i, j int) bool {
+			return ledger.Before(formatted[i], formatted[j])
+		})
+
+		for i := range formatted {
+			running.Add(&formatted[i])
+			if err := fn(formatted[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return running.Ledgers(), nil
+}
+
+// each передает в fn трансферы с направлением -direction. Подходит как
+// output.Rows: каждый вызов заново читает историю из хранилища.
+func (h history) each(fn func(models.FormattedTransfer) error) error {
+	_, err := h.scan(func(tx models.FormattedTransfer) error {
+		if !tx.Direction.Matches(h.Direction) {
+			return nil
+		}
+		return fn(tx)
+	})
+	return err
+}
@@ -5,15 +5,15 @@ package main
 
 import (
 	"bufio"
-	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 
+	"ethcrawler/pkg/chains"
 	"ethcrawler/pkg/etherscan"
-	"ethcrawler/pkg/output"
+	"ethcrawler/pkg/source"
 
 	"github.com/joho/godotenv"
 )
@@ -23,88 +23,23 @@ const (
 	DefaultUsdtContract = "0xdac17f958d2ee523a2206206994597c13d831ec7"
 	EnvFileName         = ".env"
 	ConfFileName        = "ethcrawler.conf"
+	DefaultWorkDir      = "ethcrawler_data"
 )
 
 func main() {
-	// Parse command line arguments
-	addressFlag := flag.String("a", "", "Ethereum address")
-	outputFormat := flag.String("format", "both", "Output format: text, excel, or both")
-	configFile := flag.String("config", "", "Path to config file (.env or .conf)")
-	flag.Parse()
-
-	// Приветствие
-	fmt.Printf("%sEthCrawler - USDT Transaction Tool%s\n\n",
-		etherscan.ColorGreen, etherscan.ColorReset)
-
-	// Интерактивный режим, если адрес не указан через аргументы
-	address := *addressFlag
-	if address == "" {
-		address = promptForEthereumAddress()
-	}
-
-	// Проверка валидности адреса
-	if len(address) != 42 || address[:2] != "0x" {
-		fmt.Printf("%sAddress has to start from 0x and contain 40 hex-symbols%s\n",
-			etherscan.ColorRed, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
-	}
-
-	fmt.Printf("%sFetching transactions for address: %s%s\n",
-		etherscan.ColorGreen, address, etherscan.ColorReset)
-
-	// Load environment variables and handle first run setup
-	apiKey, contract := setupConfiguration(*configFile)
-
-	// Create a new Etherscan client
-	client := etherscan.NewClient(apiKey, contract)
-
-	// Get the token transfers
-	transfers, err := client.GetTokenTransfers(address)
-	if err != nil {
-		fmt.Printf("%sError fetching transfers: %v%s\n",
-			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
-	}
-
-	// Format the transfers
-	formattedTransfers, err := etherscan.FormatTransfers(transfers)
-	if err != nil {
-		fmt.Printf("%sError formatting transfers: %v%s\n",
-			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
-	}
+	runCommand(os.Args[1:])
+}
 
-	// Save the transfers in the requested format(s)
-	if *outputFormat == "text" || *outputFormat == "both" {
-		filename, err := output.SaveToTextFile(formattedTransfers, address)
-		if err != nil {
-			fmt.Printf("%sError saving text file: %v%s\n",
-				etherscan.ColorRed, err, etherscan.ColorReset)
-		} else {
-			fmt.Printf("%sTransactions saved to `%s`%s\n",
-				etherscan.ColorGreen, filename, etherscan.ColorReset)
-		}
+// selectContract выбирает контракт токена для сети: флаг -token имеет приоритет,
+// контракт из конфигурации используется только для Ethereum
+func selectContract(chain chains.Chain, configContract, token string) string {
+	if token != "" {
+		return chain.Token(token)
 	}
-
-	if *outputFormat == "excel" || *outputFormat == "both" {
-		filename, err := output.SaveToExcel(formattedTransfers, address)
-		if err != nil {
-			fmt.Printf("%sError saving Excel file: %v%s\n",
-				etherscan.ColorRed, err, etherscan.ColorReset)
-		} else {
-			fmt.Printf("%sTransactions saved to `%s`%s\n",
-				etherscan.ColorGreen, filename, etherscan.ColorReset)
-		}
+	if chain.Name == chains.Ethereum.Name {
+		return configContract
 	}
-
-	// Финальное сообщение и пауза перед выходом
-	fmt.Printf("\n%sOperation completed. Files saved in the same directory as the program.%s\n",
-		etherscan.ColorGreen, etherscan.ColorReset)
-
-	waitForEnter()
+	return chain.USDT
 }
 
 // promptForEthereumAddress запрашивает Ethereum адрес у пользователя
@@ -112,14 +47,15 @@ func promptForEthereumAddress() string {
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
-		fmt.Printf("%sPlease enter an Ethereum address (starting with 0x): %s",
+		fmt.Fprintf(console, "%sPlease enter an Ethereum address (starting with 0x): %s",
 			etherscan.ColorGreen, etherscan.ColorReset)
 
+		// Закрытый ввод не даст адреса, повторять запрос бессмысленно
 		input, err := reader.ReadString('\n')
 		if err != nil {
-			fmt.Printf("%sError reading input: %v%s\n",
+			fmt.Fprintf(console, "%sError reading input: %v%s\n",
 				etherscan.ColorRed, err, etherscan.ColorReset)
-			continue
+			exit(ExitValidation)
 		}
 
 		// Убираем переводы строк и пробелы
@@ -130,7 +66,7 @@ func promptForEthereumAddress() string {
 			return address
 		}
 
-		fmt.Printf("%sInvalid Ethereum address format. Address should start with 0x followed by 40 hex characters.%s\n\n",
+		fmt.Fprintf(console, "%sInvalid Ethereum address format. Address should start with 0x followed by 40 hex characters.%s\n\n",
 			etherscan.ColorRed, etherscan.ColorReset)
 	}
 }
@@ -141,49 +77,105 @@ func isValidEthereumAddress(address string) bool {
 	return re.MatchString(address)
 }
 
-// waitForEnter ожидает нажатия Enter
-func waitForEnter() {
-	fmt.Printf("\n%sPress Enter to exit...%s",
-		etherscan.ColorYellow, etherscan.ColorReset)
-	bufio.NewReader(os.Stdin).ReadBytes('\n')
+// Config содержит настройки из конфигурационного файла
+type Config struct {
+	Path     string // Файл, из которого загружены настройки
+	APIKey   string
+	Contract string
+	RPCURL   string
+	Source   string // Источник данных по умолчанию
+
+	BlockscoutURL    string
+	BlockscoutAPIKey string
+
+	PostgresDSN string // База для -format postgres
 }
 
-// setupConfiguration загружает или создает конфигурационный файл и возвращает настройки
-func setupConfiguration(customConfigPath string) (string, string) {
-	var configPath string
+// sourceName возвращает источник данных: флаг -source, SOURCE из конфигурации или Etherscan
+func (c Config) sourceName(override string) string {
+	if override != "" {
+		return override
+	}
+	if c.Source != "" {
+		return c.Source
+	}
+	return source.DefaultName
+}
 
+// loadConfiguration загружает конфигурационный файл, ничего не запрашивая и не
+// создавая. Если файл не найден, Path остается пустым.
+func loadConfiguration(customConfigPath string) Config {
 	// Если указан пользовательский путь к конфигу, используем его
 	if customConfigPath != "" {
-		configPath = customConfigPath
-		if !fileExists(configPath) {
-			fmt.Printf("%sSpecified config file not found: %s%s\n",
-				etherscan.ColorRed, configPath, etherscan.ColorReset)
-			waitForEnter()
-			os.Exit(1)
+		if !fileExists(customConfigPath) {
+			fmt.Fprintf(console, "%sSpecified config file not found: %s%s\n",
+				etherscan.ColorRed, customConfigPath, etherscan.ColorReset)
+			exit(ExitConfig)
 		}
-	} else {
-		// Иначе ищем конфигурационные файлы в стандартных местах
-		configPath = findConfigFile()
+		return loadConfigFile(customConfigPath)
+	}
+
+	// Иначе ищем конфигурационные файлы в стандартных местах
+	if configPath := findConfigFile(); configPath != "" {
+		return loadConfigFile(configPath)
 	}
+	return Config{}
+}
 
-	// Если файл существует, загружаем из него данные
-	if configPath != "" {
-		apiKey, contract := loadConfigFile(configPath)
-		return apiKey, contract
+// setupConfiguration загружает или создает конфигурационный файл и возвращает настройки.
+// API ключ запрашивается, только если он нужен выбранному источнику данных.
+// Без терминала файлы не создаются и не меняются.
+func setupConfiguration(customConfigPath, sourceOverride string) Config {
+	cfg := loadConfiguration(customConfigPath)
+	configPath := cfg.Path
+	changed := false
+
+	if configPath == "" {
+		if nonInteractive {
+			fmt.Fprintf(console, "%sConfiguration file not found, create one with `ethcrawler config init` or pass -config%s\n",
+				etherscan.ColorRed, etherscan.ColorReset)
+			exit(ExitConfig)
+		}
+
+		// Если не нашли подходящий файл, создаем новый .conf по умолчанию
+		fmt.Fprintf(console, "%sConfiguration file not found. Setting up for first use.%s\n",
+			etherscan.ColorYellow, etherscan.ColorReset)
+		cfg.Path = getDefaultConfigPath()
+		changed = true
+	}
+
+	// Проверка наличия API ключа
+	if cfg.APIKey == "" && requiresAPIKey(cfg.sourceName(sourceOverride)) {
+		if configPath != "" {
+			fmt.Fprintf(console, "%sAPI key not found in %s%s\n",
+				etherscan.ColorYellow, cfg.Path, etherscan.ColorReset)
+		}
+		if nonInteractive {
+			fmt.Fprintf(console, "%sNo API key configured, set ETHERSCAN_API_KEY in the config file or use -config%s\n",
+				etherscan.ColorRed, etherscan.ColorReset)
+			exit(ExitConfig)
+		}
+		cfg.APIKey = promptForAPIKey()
+		changed = true
 	}
 
-	// Если не нашли подходящий файл, создаем новый .conf по умолчанию
-	fmt.Printf("%sConfiguration file not found. Setting up for first use.%s\n",
-		etherscan.ColorYellow, etherscan.ColorReset)
+	// Если контракт не указан, используем значение по умолчанию
+	if cfg.Contract == "" {
+		cfg.Contract = DefaultUsdtContract
+		changed = true
+	}
 
-	configPath = getDefaultConfigPath()
-	apiKey := promptForAPIKey()
-	contract := DefaultUsdtContract
+	if changed && !nonInteractive {
+		saveToConfigFile(cfg)
+	}
 
-	// Создаем конфигурационный файл
-	saveToConfigFile(configPath, apiKey, contract)
+	return cfg
+}
 
-	return apiKey, contract
+// requiresAPIKey сообщает, нужен ли источнику данных API ключ Etherscan
+func requiresAPIKey(sourceName string) bool {
+	provider, err := source.Lookup(sourceName)
+	return err == nil && provider.NeedsAPIKey
 }
 
 // findConfigFile ищет конфигурационные файлы в стандартных местах
@@ -198,7 +190,7 @@ func findConfigFile() string {
 
 	for _, path := range searchPaths {
 		if fileExists(path) {
-			fmt.Printf("%sFound configuration file: %s%s\n",
+			fmt.Fprintf(console, "%sFound configuration file: %s%s\n",
 				etherscan.ColorGreen, path, etherscan.ColorReset)
 			return path
 		}
@@ -227,7 +219,7 @@ func getDefaultConfigPath() string {
 }
 
 // loadConfigFile загружает данные из конфигурационного файла
-func loadConfigFile(path string) (string, string) {
+func loadConfigFile(path string) Config {
 	// Проверяем расширение файла
 	ext := strings.ToLower(filepath.Ext(path))
 
@@ -239,49 +231,40 @@ func loadConfigFile(path string) (string, string) {
 }
 
 // loadEnvFile загружает данные из .env файла
-func loadEnvFile(path string) (string, string) {
+func loadEnvFile(path string) Config {
 	err := godotenv.Load(path)
 	if err != nil {
-		fmt.Printf("%sError loading .env file: %v%s\n",
+		fmt.Fprintf(console, "%sError loading .env file: %v%s\n",
 			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
+		exit(ExitConfig)
 	}
 
-	apiKey := os.Getenv("ETHERSCAN_API_KEY")
-	contract := os.Getenv("USDT_CONTRACT")
+	return Config{
+		Path:     path,
+		APIKey:   os.Getenv("ETHERSCAN_API_KEY"),
+		Contract: os.Getenv("USDT_CONTRACT"),
+		RPCURL:   os.Getenv("RPC_URL"),
+		Source:   os.Getenv("SOURCE"),
 
-	// Проверка наличия API ключа
-	if apiKey == "" {
-		fmt.Printf("%sAPI key not found in %s%s\n",
-			etherscan.ColorYellow, path, etherscan.ColorReset)
-		apiKey = promptForAPIKey()
-		saveToEnvFile(path, apiKey, contract)
-	}
+		BlockscoutURL:    os.Getenv("BLOCKSCOUT_URL"),
+		BlockscoutAPIKey: os.Getenv("BLOCKSCOUT_API_KEY"),
 
-	// Если контракт не указан, используем значение по умолчанию
-	if contract == "" {
-		contract = DefaultUsdtContract
-		saveToEnvFile(path, apiKey, contract)
+		PostgresDSN: os.Getenv("POSTGRES_DSN"),
 	}
-
-	return apiKey, contract
 }
 
 // loadConfFile загружает данные из .conf файла
-func loadConfFile(path string) (string, string) {
+func loadConfFile(path string) Config {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("%sError reading conf file: %v%s\n",
+		fmt.Fprintf(console, "%sError reading conf file: %v%s\n",
 			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
+		exit(ExitConfig)
 	}
 
 	lines := strings.Split(string(data), "\n")
 
-	apiKey := ""
-	contract := ""
+	cfg := Config{Path: path}
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
@@ -299,50 +282,46 @@ func loadConfFile(path string) (string, string) {
 		key := strings.TrimSpace(parts[0])
 		value := strings.TrimSpace(parts[1])
 
-		if key == "ETHERSCAN_API_KEY" {
-			apiKey = value
-		} else if key == "USDT_CONTRACT" {
-			contract = value
+		switch key {
+		case "ETHERSCAN_API_KEY":
+			cfg.APIKey = value
+		case "USDT_CONTRACT":
+			cfg.Contract = value
+		case "RPC_URL":
+			cfg.RPCURL = value
+		case "SOURCE":
+			cfg.Source = value
+		case "BLOCKSCOUT_URL":
+			cfg.BlockscoutURL = value
+		case "BLOCKSCOUT_API_KEY":
+			cfg.BlockscoutAPIKey = value
+		case "POSTGRES_DSN":
+			cfg.PostgresDSN = value
 		}
 	}
 
-	// Проверка наличия API ключа
-	if apiKey == "" {
-		fmt.Printf("%sAPI key not found in %s%s\n",
-			etherscan.ColorYellow, path, etherscan.ColorReset)
-		apiKey = promptForAPIKey()
-		saveToConfFile(path, apiKey, contract)
-	}
-
-	// Если контракт не указан, используем значение по умолчанию
-	if contract == "" {
-		contract = DefaultUsdtContract
-		saveToConfFile(path, apiKey, contract)
-	}
-
-	return apiKey, contract
+	return cfg
 }
 
 // promptForAPIKey запрашивает API ключ у пользователя
 func promptForAPIKey() string {
 	reader := bufio.NewReader(os.Stdin)
 
-	fmt.Printf("%sPlease enter your Etherscan API key: %s",
+	fmt.Fprintf(console, "%sPlease enter your Etherscan API key: %s",
 		etherscan.ColorGreen, etherscan.ColorReset)
 
 	input, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Printf("%sError reading input: %v%s\n",
+		fmt.Fprintf(console, "%sError reading input: %v%s\n",
 			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
+		exit(ExitConfig)
 	}
 
 	// Убираем переводы строк и пробелы
 	apiKey := strings.TrimSpace(input)
 
 	if apiKey == "" {
-		fmt.Printf("%sAPI key cannot be empty. Please try again.%s\n",
+		fmt.Fprintf(console, "%sAPI key cannot be empty. Please try again.%s\n",
 			etherscan.ColorRed, etherscan.ColorReset)
 		return promptForAPIKey()
 	}
@@ -351,49 +330,77 @@ func promptForAPIKey() string {
 }
 
 // saveToConfigFile сохраняет настройки в конфигурационный файл
-func saveToConfigFile(path, apiKey, contract string) {
+func saveToConfigFile(cfg Config) {
 	// Проверяем расширение файла
-	ext := strings.ToLower(filepath.Ext(path))
+	ext := strings.ToLower(filepath.Ext(cfg.Path))
 
 	if ext == ".env" {
-		saveToEnvFile(path, apiKey, contract)
+		saveToEnvFile(cfg)
 	} else {
-		saveToConfFile(path, apiKey, contract)
+		saveToConfFile(cfg)
 	}
 }
 
 // saveToEnvFile сохраняет настройки в .env файл
-func saveToEnvFile(path, apiKey, contract string) {
+func saveToEnvFile(cfg Config) {
 	content := fmt.Sprintf("ETHERSCAN_API_KEY=%s\nUSDT_CONTRACT=%s\n",
-		apiKey, contract)
+		cfg.APIKey, cfg.Contract)
+	if cfg.RPCURL != "" {
+		content += fmt.Sprintf("RPC_URL=%s\n", cfg.RPCURL)
+	}
+	if cfg.Source != "" {
+		content += fmt.Sprintf("SOURCE=%s\n", cfg.Source)
+	}
+	if cfg.BlockscoutURL != "" {
+		content += fmt.Sprintf("BLOCKSCOUT_URL=%s\n", cfg.BlockscoutURL)
+	}
+	if cfg.BlockscoutAPIKey != "" {
+		content += fmt.Sprintf("BLOCKSCOUT_API_KEY=%s\n", cfg.BlockscoutAPIKey)
+	}
+	if cfg.PostgresDSN != "" {
+		content += fmt.Sprintf("POSTGRES_DSN=%s\n", cfg.PostgresDSN)
+	}
 
-	err := os.WriteFile(path, []byte(content), 0644)
+	err := os.WriteFile(cfg.Path, []byte(content), 0644)
 	if err != nil {
-		fmt.Printf("%sError saving configuration: %v%s\n",
+		fmt.Fprintf(console, "%sError saving configuration: %v%s\n",
 			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
+		exit(ExitConfig)
 	}
 
-	fmt.Printf("%sConfiguration saved to %s%s\n",
-		etherscan.ColorGreen, path, etherscan.ColorReset)
+	fmt.Fprintf(console, "%sConfiguration saved to %s%s\n",
+		etherscan.ColorGreen, cfg.Path, etherscan.ColorReset)
 }
 
 // saveToConfFile сохраняет настройки в .conf файл
-func saveToConfFile(path, apiKey, contract string) {
+func saveToConfFile(cfg Config) {
 	content := fmt.Sprintf("# EthCrawler configuration file\n\n# Etherscan API key\nETHERSCAN_API_KEY=%s\n\n# USDT contract address\nUSDT_CONTRACT=%s\n",
-		apiKey, contract)
+		cfg.APIKey, cfg.Contract)
+	if cfg.RPCURL != "" {
+		content += fmt.Sprintf("\n# JSON-RPC node for -source rpc\nRPC_URL=%s\n", cfg.RPCURL)
+	}
+	if cfg.Source != "" {
+		content += fmt.Sprintf("\n# Transfer source: %s\nSOURCE=%s\n", strings.Join(source.Names(), ", "), cfg.Source)
+	}
+	if cfg.BlockscoutURL != "" {
+		content += fmt.Sprintf("\n# Blockscout API for -source blockscout\nBLOCKSCOUT_URL=%s\n", cfg.BlockscoutURL)
+	}
+	if cfg.BlockscoutAPIKey != "" {
+		content += fmt.Sprintf("BLOCKSCOUT_API_KEY=%s\n", cfg.BlockscoutAPIKey)
+	}
+	if cfg.PostgresDSN != "" {
+		content += fmt.Sprintf("\n# PostgreSQL database for -format postgres\nPOSTGRES_DSN=%s\n", cfg.PostgresDSN)
+	}
 
-	err := os.WriteFile(path, []byte(content), 0644)
+	err := os.WriteFile(cfg.Path, []byte(content), 0644)
 	if err != nil {
-		fmt.Printf("%sError saving configuration: %v%s\n",
+		fmt.Fprintf(console, "%sError saving configuration: %v%s\n",
 			etherscan.ColorRed, err, etherscan.ColorReset)
-		waitForEnter()
-		os.Exit(1)
+		exit(ExitConfig)
 	}
 
-	fmt.Printf("%sConfiguration saved to %s%s\n",
-		etherscan.ColorGreen, path, etherscan.ColorReset)
+	fmt.Fprintf(console, "%sConfiguration saved to %s%s\n",
+		etherscan.ColorGreen, cfg.Path, etherscan.ColorReset)
 }
 
 // fileExists проверяет существование файла
@@ -0,0 +1,416 @@
+package blockscout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+)
+
+const (
+	// DefaultPageSize keeps single Blockscout queries cheap; large offsets
+	// time out on busy instances
+	DefaultPageSize = 1000
+
+	// DefaultWindowPages is how many pages are read before the crawl restarts
+	// from the last seen block, so that page numbers never grow large
+	DefaultWindowPages = 10
+
+	// DefaultCallsPerSecond stays below the anonymous limit of public instances
+	DefaultCallsPerSecond = 5
+)
+
+// Client reads token transfers from the Etherscan-compatible API of a
+// Blockscout explorer
+type Client struct {
+	BaseURL  string // API endpoint, e.g. https://eth.blockscout.com/api
+	ApiKey   string // Optional, raises the rate limit on some instances
+	Contract string
+
+	PageSize    int
+	WindowPages int
+
+	HTTPClient *http.Client
+	Retry      etherscan.RetryPolicy
+	Limiter    *etherscan.RateLimiter
+	Log        io.Writer // Progress and retry messages, nil discards them
+}
+
+// NewClient creates a new Blockscout API client
+func NewClient(baseURL, contract string) *Client {
+	return &Client{
+		BaseURL:     strings.TrimRight(baseURL, "/"),
+		Contract:    contract,
+		PageSize:    DefaultPageSize,
+		WindowPages: DefaultWindowPages,
+		HTTPClient:  &http.Client{Timeout: 120 * time.Second},
+		Retry:       etherscan.DefaultRetryPolicy,
+		Limiter:     etherscan.NewRateLimiter(DefaultCallsPerSecond),
+		Log:         os.Stdout,
+	}
+}
+
+// Error is an error reported by Blockscout, either in the Etherscan-style
+// envelope or as an {"error": ...} object
+type Error struct {
+	StatusCode int // HTTP status, 200 for envelope errors
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode != http.StatusOK {
+		return fmt.Sprintf("Blockscout API error (HTTP %d): %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("Blockscout API error: %s", e.Message)
+}
+
+// Temporary reports whether the same request may succeed later, used by
+// etherscan.IsTemporary
+func (e *Error) Temporary() bool {
+	if e.StatusCode >= 500 || e.RateLimited() {
+		return true
+	}
+	text := strings.ToLower(e.Message)
+	return strings.Contains(text, "timeout") ||
+		strings.Contains(text, "timed out")
+}
+
+// RateLimited reports whether Blockscout rejected the call because of rate limits
+func (e *Error) RateLimited() bool {
+	text := strings.ToLower(e.Message)
+	return e.StatusCode == http.StatusTooManyRequests ||
+		strings.Contains(text, "too many requests") ||
+		strings.Contains(text, "rate limit")
+}
+
+// envelope is the Etherscan-style response. Blockscout sends null or an
+// error string as result on failures.
+type envelope struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+	Error   string          `json:"error"`
+}
+
+// transfer is a tokentx entry as returned by Blockscout. Older instances
+// omit logIndex and transactionIndex, NFT transfers carry tokenID.
+type transfer struct {
+	TimeStamp        string `json:"timeStamp"`
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Value            string `json:"value"`
+	Hash             string `json:"hash"`
+	BlockNumber      string `json:"blockNumber"`
+	LogIndex         string `json:"logIndex"`
+	TransactionIndex string `json:"transactionIndex"`
+	ContractAddress  string `json:"contractAddress"`
+	TokenName        string `json:"tokenName"`
+	TokenSymbol      string `json:"tokenSymbol"`
+	TokenDecimal     string `json:"tokenDecimal"`
+	TokenID          string `json:"tokenID"`
+}
+
+// StreamTokenTransfers fetches ERC20 token transfers matching q and passes
+// every page to fn as soon as it arrives. Every WindowPages pages the crawl
+// restarts from the last seen block and skips transfers it already delivered.
+func (c *Client) StreamTokenTransfers(ctx context.Context, q models.Query, fn func([]models.ERC20Transfer) error) (models.Progress, error) {
+	pageSize := c.PageSize
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	windowPages := c.WindowPages
+	if windowPages < 1 {
+		windowPages = DefaultWindowPages
+	}
+
+	paging := etherscan.Paging{
+		PageSize:    pageSize,
+		WindowPages: windowPages,
+		Fetch: func(ctx context.Context, page, startBlock int) ([]models.ERC20Transfer, int, error) {
+			return c.fetchPage(ctx, c.tokentxURL(q, page, pageSize, startBlock))
+		},
+		Requesting: func(request, startBlock int) {
+			fmt.Fprintf(etherscan.LogWriter(c.Log), "Downloading Blockscout page %d from block %d\n", request, startBlock)
+		},
+	}
+
+	progress, err := paging.Stream(ctx, q.StartBlock, fn)
+	if err != nil {
+		return progress, err
+	}
+
+	fmt.Fprintf(etherscan.LogWriter(c.Log), "Downloaded %d transactions total\n", progress.Transfers)
+
+	return progress, nil
+}
+
+// tokentxURL builds the request URL for one page of token transfers
+func (c *Client) tokentxURL(q models.Query, page, pageSize, startBlock int) string {
+	params := url.Values{}
+	params.Set("module", "account")
+	params.Set("action", "tokentx")
+	if q.Contract != "" {
+		params.Set("contractaddress", q.Contract)
+	}
+	params.Set("address", q.Address)
+	params.Set("page", strconv.Itoa(page))
+	params.Set("offset", strconv.Itoa(pageSize))
+	params.Set("sort", "asc")
+	if startBlock > 0 {
+		params.Set("startblock", strconv.Itoa(startBlock))
+	}
+	if q.EndBlock > 0 {
+		params.Set("endblock", strconv.Itoa(q.EndBlock))
+	}
+	if c.ApiKey != "" {
+		params.Set("apikey", c.ApiKey)
+	}
+
+	return c.BaseURL + "?" + params.Encode()
+}
+
+// TokenBalance returns the current token balance of address in base units
+func (c *Client) TokenBalance(ctx context.Context, contract, address string) (*big.Int, error) {
+	params := url.Values{}
+	params.Set("module", "account")
+	params.Set("action", "tokenbalance")
+	params.Set("contractaddress", contract)
+	params.Set("address", address)
+	if c.ApiKey != "" {
+		params.Set("apikey", c.ApiKey)
+	}
+	requestURL := c.BaseURL + "?" + params.Encode()
+
+	var balance *big.Int
+	err := c.retry(ctx, func() error {
+		env, err := c.request(ctx, requestURL)
+		if err != nil {
+			return err
+		}
+		if env.Status != "1" {
+			return env.err()
+		}
+
+		var text string
+		if err := json.Unmarshal(env.Result, &text); err != nil {
+			return fmt.Errorf("error parsing balance: %v", err)
+		}
+		value, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return fmt.Errorf("error parsing balance %q", text)
+		}
+		balance = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// BlockNumberByTime returns the last block mined at or before t, or the first
+// block mined at or after t when after is set
+func (c *Client) BlockNumberByTime(ctx context.Context, t time.Time, after bool) (int, error) {
+	closest := "before"
+	if after {
+		closest = "after"
+	}
+
+	params := url.Values{}
+	params.Set("module", "block")
+	params.Set("action", "getblocknobytime")
+	params.Set("timestamp", strconv.FormatInt(t.Unix(), 10))
+	params.Set("closest", closest)
+	if c.ApiKey != "" {
+		params.Set("apikey", c.ApiKey)
+	}
+	requestURL := c.BaseURL + "?" + params.Encode()
+
+	var block int
+	err := c.retry(ctx, func() error {
+		env, err := c.request(ctx, requestURL)
+		if err != nil {
+			return err
+		}
+		if env.Status != "1" {
+			return env.err()
+		}
+
+		// Blockscout wraps the number in an object, Etherscan sends it bare
+		var result struct {
+			BlockNumber string `json:"blockNumber"`
+		}
+		text := ""
+		if json.Unmarshal(env.Result, &result) == nil {
+			text = result.BlockNumber
+		} else if err := json.Unmarshal(env.Result, &text); err != nil {
+			return fmt.Errorf("error parsing block number: %v", err)
+		}
+		block, err = models.StringToInt(text)
+		if err != nil {
+			return fmt.Errorf("error parsing block number: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return block, nil
+}
+
+// fetchPage requests a single page, retrying transient failures. It returns
+// the token transfers and the number of rows on the page, NFT rows included.
+func (c *Client) fetchPage(ctx context.Context, pageURL string) ([]models.ERC20Transfer, int, error) {
+	var transfers []models.ERC20Transfer
+	var rows int
+	err := c.retry(ctx, func() error {
+		var err error
+		transfers, rows, err = c.get(ctx, pageURL)
+		return err
+	})
+	return transfers, rows, err
+}
+
+// retry calls fn with the retry policy and rate limiter of the client
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	return etherscan.Retry(ctx, c.Retry, c.Limiter, c.Log, fn)
+}
+
+// get performs a single tokentx call and normalises the transfers. NFT rows
+// are dropped but counted in the returned number of rows, so that a full page
+// with NFT rows does not end the paging.
+func (c *Client) get(ctx context.Context, pageURL string) ([]models.ERC20Transfer, int, error) {
+	env, err := c.request(ctx, pageURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if env.Status != "1" {
+		// "No token transfers found" comes with an empty list
+		var list []json.RawMessage
+		if json.Unmarshal(env.Result, &list) == nil && list != nil && len(list) == 0 {
+			return nil, 0, nil
+		}
+		return nil, 0, env.err()
+	}
+
+	var raw []transfer
+	if err := json.Unmarshal(env.Result, &raw); err != nil {
+		return nil, 0, fmt.Errorf("error parsing list of transactions: %v", err)
+	}
+
+	transfers := make([]models.ERC20Transfer, 0, len(raw))
+	for _, tx := range raw {
+		// Skip NFT transfers some instances mix into tokentx
+		if tx.TokenID != "" && tx.Value == "" {
+			continue
+		}
+		if tx.Value == "" {
+			tx.Value = "0"
+		}
+
+		transfers = append(transfers, models.ERC20Transfer{
+			TimeStamp:        tx.TimeStamp,
+			From:             strings.ToLower(tx.From),
+			To:               strings.ToLower(tx.To),
+			Value:            tx.Value,
+			Hash:             strings.ToLower(tx.Hash),
+			BlockNumber:      tx.BlockNumber,
+			LogIndex:         tx.LogIndex,
+			TransactionIndex: tx.TransactionIndex,
+			ContractAddress:  strings.ToLower(tx.ContractAddress),
+			TokenName:        tx.TokenName,
+			TokenSymbol:      tx.TokenSymbol,
+			TokenDecimal:     tx.TokenDecimal,
+		})
+	}
+
+	return transfers, len(raw), nil
+}
+
+// request performs a single API call and decodes the response envelope.
+// HTTP failures and {"error": ...} answers are returned as errors.
+func (c *Client) request(ctx context.Context, requestURL string) (*envelope, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("error making request: %w", errors.Join(etherscan.ErrTransport, err))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("error reading response: %w", errors.Join(etherscan.ErrTransport, err))
+	}
+
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		// Proxies in front of Blockscout answer errors with HTML pages
+		if resp.StatusCode != http.StatusOK {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: resp.Status}
+		}
+		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	if env.Error != "" {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: env.Error}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: firstNonEmpty(env.Message, resp.Status)}
+	}
+
+	return &env, nil
+}
+
+// err builds the error of a response with a non-OK status
+func (env *envelope) err() *Error {
+	message := env.Message
+	var text string
+	if json.Unmarshal(env.Result, &text) == nil && text != "" && text != message {
+		if message == "" {
+			message = text
+		} else {
+			message += ": " + text
+		}
+	}
+	return &Error{StatusCode: http.StatusOK, Message: message}
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,142 @@
+package blockscout
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+)
+
+// answer is a canned HTTP response
+type answer struct {
+	code int
+	body string
+}
+
+func TestSharedRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     []answer // Answers to the first requests
+		requests int
+		wantErr  bool
+	}{
+		{"rate limit", []answer{{429, `{"message":"Too Many Requests"}`}}, 2, false},
+		{"error object", []answer{{200, `{"error":"Request timeout"}`}, {502, `<html>Bad Gateway</html>`}}, 3, false},
+		{"invalid address", []answer{{200, `{"status":"0","message":"Invalid address hash","result":null}`}}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1))
+				if n <= len(tt.fail) {
+					w.WriteHeader(tt.fail[n-1].code)
+					fmt.Fprint(w, tt.fail[n-1].body)
+					return
+				}
+				fmt.Fprint(w, `{"status":"1","message":"OK","result":"42"}`)
+			}))
+			defer ts.Close()
+
+			client := NewClient(ts.URL, "0xcontract")
+			client.Retry = etherscan.RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+			client.Limiter = nil
+
+			balance, err := client.TokenBalance(context.Background(), "0xcontract", "0xaddress")
+			if got := int(requests.Load()); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("TokenBalance succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TokenBalance: %v", err)
+			}
+			if balance.String() != "42" {
+				t.Errorf("balance = %s, want 42", balance)
+			}
+		})
+	}
+}
+
+func TestRetriesClientTimeout(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first request hangs past the client timeout
+		if requests.Add(1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		fmt.Fprint(w, `{"status":"1","message":"OK","result":"42"}`)
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	client := NewClient(ts.URL, "0xcontract")
+	client.HTTPClient = &http.Client{Timeout: 50 * time.Millisecond}
+	client.Retry = etherscan.RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	client.Limiter = nil
+
+	balance, err := client.TokenBalance(context.Background(), "0xcontract", "0xaddress")
+	if err != nil {
+		t.Fatalf("TokenBalance: %v", err)
+	}
+	if balance.String() != "42" {
+		t.Errorf("balance = %s, want 42", balance)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestNFTRowsKeepPaging(t *testing.T) {
+	// The first page is full although one of its rows is an NFT transfer
+	pages := map[string]string{
+		"1": `[{"blockNumber":"100","hash":"0xA","logIndex":"0","value":"5","contractAddress":"0xC"},
+			{"blockNumber":"101","hash":"0xB","logIndex":"0","tokenID":"7","contractAddress":"0xD"}]`,
+		"2": `[{"blockNumber":"102","hash":"0xC","logIndex":"0","value":"9","contractAddress":"0xC"}]`,
+	}
+	var requested []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		requested = append(requested, page)
+		fmt.Fprintf(w, `{"status":"1","message":"OK","result":%s}`, pages[page])
+	}))
+	defer ts.Close()
+
+	client := NewClient(ts.URL, "")
+	client.PageSize = 2
+	client.Limiter = nil
+
+	var hashes []string
+	progress, err := client.StreamTokenTransfers(context.Background(), models.Query{Address: "0xaddress"}, func(page []models.ERC20Transfer) error {
+		for _, tx := range page {
+			hashes = append(hashes, tx.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamTokenTransfers: %v", err)
+	}
+	if got := strings.Join(hashes, ","); got != "0xa,0xc" {
+		t.Errorf("delivered %s, want 0xa,0xc", got)
+	}
+	if progress.LastBlock != 102 || len(requested) != 2 {
+		t.Errorf("stopped at block %d after pages %v, want block 102 after pages 1 and 2", progress.LastBlock, requested)
+	}
+}
@@ -0,0 +1,149 @@
+package chains
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EtherscanV2URL is the single Etherscan V2 endpoint serving every chain by chainid
+const EtherscanV2URL = "https://api.etherscan.io/v2/api"
+
+// AllTokens is the token argument selecting transfers of every ERC20 token
+const AllTokens = "all"
+
+// Chain describes a network preset
+type Chain struct {
+	Name        string // Key used with the -chain flag and in file names
+	Title       string // Human readable name for outputs
+	ChainID     int    // Etherscan V2 chainid
+	APIURL      string // Etherscan-compatible API endpoint
+	Currency    string // Native currency symbol
+	USDT        string // Default USDT contract
+	USDC        string // Default USDC contract
+	ExplorerURL string
+
+	BlockscoutURL string // Blockscout API endpoint, empty if there is no public instance
+}
+
+// Ethereum is the default chain
+var Ethereum = Chain{
+	Name:        "ethereum",
+	Title:       "Ethereum",
+	ChainID:     1,
+	APIURL:      EtherscanV2URL,
+	Currency:    "ETH",
+	USDT:        "0xdac17f958d2ee523a2206206994597c13d831ec7",
+	USDC:        "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48",
+	ExplorerURL: "https://etherscan.io",
+
+	BlockscoutURL: "https://eth.blockscout.com/api",
+}
+
+// presets lists all supported chains by name
+var presets = map[string]Chain{
+	Ethereum.Name: Ethereum,
+	"bsc": {
+		Name:        "bsc",
+		Title:       "BNB Chain",
+		ChainID:     56,
+		APIURL:      EtherscanV2URL,
+		Currency:    "BNB",
+		USDT:        "0x55d398326f99059ff775485246999027b3197955",
+		USDC:        "0x8ac76a51cc950d9822d68b83fe1ad97b32cd580d",
+		ExplorerURL: "https://bscscan.com",
+	},
+	"polygon": {
+		Name:        "polygon",
+		Title:       "Polygon",
+		ChainID:     137,
+		APIURL:      EtherscanV2URL,
+		Currency:    "POL",
+		USDT:        "0xc2132d05d31c914a87c6611c10748aeb04b58e8f",
+		USDC:        "0x3c499c542cef5e3811e1192ce70d8cc03d5c3359",
+		ExplorerURL: "https://polygonscan.com",
+
+		BlockscoutURL: "https://polygon.blockscout.com/api",
+	},
+	"arbitrum": {
+		Name:        "arbitrum",
+		Title:       "Arbitrum",
+		ChainID:     42161,
+		APIURL:      EtherscanV2URL,
+		Currency:    "ETH",
+		USDT:        "0xfd086bc7cd5c481dcc9c85ebe478a1c0b69fcbb9",
+		USDC:        "0xaf88d065e77c8cc2239327c5edb3a432268e5831",
+		ExplorerURL: "https://arbiscan.io",
+
+		BlockscoutURL: "https://arbitrum.blockscout.com/api",
+	},
+	"optimism": {
+		Name:        "optimism",
+		Title:       "Optimism",
+		ChainID:     10,
+		APIURL:      EtherscanV2URL,
+		Currency:    "ETH",
+		USDT:        "0x94b008aa00579c1307b0ef2c499ad98a8ce58e58",
+		USDC:        "0x0b2c639c533813f4aa9d7837caf62653d097ff85",
+		ExplorerURL: "https://optimistic.etherscan.io",
+
+		BlockscoutURL: "https://optimism.blockscout.com/api",
+	},
+	"avalanche": {
+		Name:        "avalanche",
+		Title:       "Avalanche",
+		ChainID:     43114,
+		APIURL:      EtherscanV2URL,
+		Currency:    "AVAX",
+		USDT:        "0x9702230a8ea53601f5cd2dc00fdbc13d4df4a8c7",
+		USDC:        "0xb97ef9ef8734c71904d8002f8b6bc66dd9c48a6e",
+		ExplorerURL: "https://snowtrace.io",
+	},
+}
+
+// Get returns the preset with the given name
+func Get(name string) (Chain, error) {
+	chain, ok := presets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Chain{}, fmt.Errorf("unknown chain %q, supported chains: %s",
+			name, strings.Join(Names(), ", "))
+	}
+	return chain, nil
+}
+
+// Custom returns a chain without a preset, e.g. one only served by a
+// Blockscout instance. It is labelled by name and has no default tokens.
+func Custom(name string) Chain {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return Chain{Name: name, Title: name}
+}
+
+// Names returns the names of all presets in alphabetical order
+func Names() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Token resolves a token argument to a contract address: "usdt" and "usdc"
+// select the preset contracts, AllTokens gives an empty contract, anything
+// else is taken as an address
+func (c Chain) Token(token string) string {
+	switch strings.ToLower(token) {
+	case "usdt":
+		return c.USDT
+	case "usdc":
+		return c.USDC
+	case AllTokens:
+		return ""
+	}
+	return token
+}
+
+// TxURL returns the explorer page of a transaction
+func (c Chain) TxURL(hash string) string {
+	return c.ExplorerURL + "/tx/" + hash
+}
@@ -0,0 +1,88 @@
+package chains
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		chainID int
+	}{
+		{"ethereum", "ethereum", 1},
+		{"BSC", "bsc", 56},
+		{"  Polygon\n", "polygon", 137},
+		{"arbitrum", "arbitrum", 42161},
+		{"optimism", "optimism", 10},
+		{"avalanche", "avalanche", 43114},
+	}
+	for _, tt := range tests {
+		chain, err := Get(tt.name)
+		if err != nil {
+			t.Errorf("Get(%q): %v", tt.name, err)
+			continue
+		}
+		if chain.Name != tt.want || chain.ChainID != tt.chainID {
+			t.Errorf("Get(%q) = %s with chainid %d, want %s with %d", tt.name, chain.Name, chain.ChainID, tt.want, tt.chainID)
+		}
+		if chain.APIURL != EtherscanV2URL || chain.USDT == "" || chain.USDC == "" {
+			t.Errorf("Get(%q) = %+v, want the V2 endpoint and both stablecoins", tt.name, chain)
+		}
+	}
+
+	// The error lists the supported chains
+	_, err := Get("solana")
+	if err == nil {
+		t.Fatalf("Get(solana) found a chain")
+	}
+	if !strings.Contains(err.Error(), `"solana"`) || !strings.Contains(err.Error(), strings.Join(Names(), ", ")) {
+		t.Errorf("Get(solana) error = %q, want the name and the supported chains", err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	const contract = "0x6B175474E89094C44Da98b954EedeAC495271d0F"
+	tests := []struct {
+		token string
+		want  string
+	}{
+		{"usdt", Ethereum.USDT},
+		{"USDT", Ethereum.USDT},
+		{"usdc", Ethereum.USDC},
+		{"Usdc", Ethereum.USDC},
+		{"all", ""},
+		{"ALL", ""},
+		{contract, contract},
+	}
+	for _, tt := range tests {
+		if got := Ethereum.Token(tt.token); got != tt.want {
+			t.Errorf("Token(%q) = %q, want %q", tt.token, got, tt.want)
+		}
+	}
+
+	// Presets of other chains have their own contracts
+	bsc, _ := Get("bsc")
+	if got := bsc.Token("usdt"); got != bsc.USDT || got == Ethereum.USDT {
+		t.Errorf("BSC Token(usdt) = %q, want %q", got, bsc.USDT)
+	}
+}
+
+func TestCustom(t *testing.T) {
+	chain := Custom("  Gnosis ")
+	if chain.Name != "gnosis" || chain.Title != "gnosis" {
+		t.Errorf("Custom = %s titled %s, want gnosis", chain.Name, chain.Title)
+	}
+	if chain.ChainID != 0 || chain.APIURL != "" || chain.ExplorerURL != "" {
+		t.Errorf("Custom = %+v, want no preset settings", chain)
+	}
+
+	// Without preset tokens only addresses and all select a token
+	if got := chain.Token("usdt"); got != "" {
+		t.Errorf("Custom Token(usdt) = %q, want none", got)
+	}
+	if got := chain.Token("0xabc"); got != "0xabc" {
+		t.Errorf("Custom Token(0xabc) = %q", got)
+	}
+}
@@ -0,0 +1,168 @@
+package decimal
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Decimal is an exact fixed-point number: an integer amount of base units
+// scaled by 10^-Scale. The zero value is 0.
+type Decimal struct {
+	units *big.Int
+	scale int
+}
+
+// New returns units * 10^-scale. Negative scales are treated as 0.
+func New(units *big.Int, scale int) Decimal {
+	if scale < 0 {
+		scale = 0
+	}
+	return Decimal{units: new(big.Int).Set(units), scale: scale}
+}
+
+// Parse reads an integer amount of base units, such as a transfer value,
+// with the given scale
+func Parse(units string, scale int) (Decimal, error) {
+	i, ok := new(big.Int).SetString(strings.TrimSpace(units), 10)
+	if !ok {
+		return Decimal{}, fmt.Errorf("invalid integer amount %q", units)
+	}
+	return New(i, scale), nil
+}
+
+// ParseDecimal reads a human readable amount such as "-1234.56" into base
+// units of the given scale. More fractional digits than scale is an error.
+func ParseDecimal(s string, scale int) (Decimal, error) {
+	text := strings.TrimSpace(s)
+	if scale < 0 {
+		scale = 0
+	}
+
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(strings.TrimPrefix(text, "-"), "+")
+
+	whole, frac, _ := strings.Cut(text, ".")
+	frac = strings.TrimRight(frac, "0")
+	if whole == "" && frac == "" || len(frac) > scale || !digits(whole) || !digits(frac) {
+		return Decimal{}, fmt.Errorf("invalid amount %q for %d decimals", s, scale)
+	}
+
+	units, _ := new(big.Int).SetString("0"+whole+frac+strings.Repeat("0", scale-len(frac)), 10)
+	if negative {
+		units.Neg(units)
+	}
+	return Decimal{units: units, scale: scale}, nil
+}
+
+// digits reports whether s consists of ASCII digits only
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Units returns the amount in base units
+func (d Decimal) Units() *big.Int {
+	if d.units == nil {
+		return new(big.Int)
+	}
+	return new(big.Int).Set(d.units)
+}
+
+// Scale returns the number of decimal places
+func (d Decimal) Scale() int {
+	return d.scale
+}
+
+// Rescale returns d with at least scale decimal places. The value is unchanged;
+// scales smaller than the current one are ignored.
+func (d Decimal) Rescale(scale int) Decimal {
+	if scale <= d.scale {
+		return d
+	}
+	factor := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale-d.scale)), nil)
+	return Decimal{units: factor.Mul(factor, d.Units()), scale: scale}
+}
+
+// align brings a and b to the same scale
+func align(a, b Decimal) (Decimal, Decimal) {
+	return a.Rescale(b.scale), b.Rescale(a.scale)
+}
+
+// Add returns d + other
+func (d Decimal) Add(other Decimal) Decimal {
+	a, b := align(d, other)
+	return Decimal{units: a.Units().Add(a.Units(), b.Units()), scale: a.scale}
+}
+
+// Sub returns d - other
+func (d Decimal) Sub(other Decimal) Decimal {
+	a, b := align(d, other)
+	return Decimal{units: a.Units().Sub(a.Units(), b.Units()), scale: a.scale}
+}
+
+// Neg returns -d
+func (d Decimal) Neg() Decimal {
+	units := d.Units()
+	return Decimal{units: units.Neg(units), scale: d.scale}
+}
+
+// Cmp compares d and other and returns -1, 0 or +1
+func (d Decimal) Cmp(other Decimal) int {
+	a, b := align(d, other)
+	return a.Units().Cmp(b.Units())
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of d
+func (d Decimal) Sign() int {
+	if d.units == nil {
+		return 0
+	}
+	return d.units.Sign()
+}
+
+// IsZero reports whether d is 0
+func (d Decimal) IsZero() bool {
+	return d.Sign() == 0
+}
+
+// String formats d exactly without trailing fractional zeros, e.g. "1.5"
+func (d Decimal) String() string {
+	text := d.StringFixed()
+	if strings.Contains(text, ".") {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	return text
+}
+
+// Float64 returns the float64 nearest to d, for outputs that can only hold
+// floating point numbers
+func (d Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// StringFixed formats d exactly with all Scale decimal places, e.g. "1.500000"
+func (d Decimal) StringFixed() string {
+	units := d.Units()
+	negative := units.Sign() < 0
+	text := units.Abs(units).String()
+
+	if d.scale > 0 {
+		if len(text) <= d.scale {
+			text = strings.Repeat("0", d.scale-len(text)+1) + text
+		}
+		point := len(text) - d.scale
+		text = text[:point] + "." + text[point:]
+	}
+
+	if negative {
+		return "-" + text
+	}
+	return text
+}
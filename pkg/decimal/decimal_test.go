@@ -0,0 +1,226 @@
+package decimal
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		units   string
+		scale   int
+		want    string
+		wantErr bool
+	}{
+		{"1", 18, "0.000000000000000001", false},
+		{"-1", 18, "-0.000000000000000001", false},
+		{"1000000000000000000", 18, "1", false},
+		{"123456789012345678901234567", 18, "123456789.012345678901234567", false},
+		{"-123456789012345678901234567", 18, "-123456789.012345678901234567", false},
+		{"0", 18, "0", false},
+		{" 42 ", 0, "42", false},
+		{"42", -3, "42", false},
+		{"", 18, "", true},
+		{"1.5", 18, "", true},
+		{"1e18", 18, "", true},
+		{"0x10", 18, "", true},
+		{"abc", 18, "", true},
+	}
+
+	for _, tt := range tests {
+		d, err := Parse(tt.units, tt.scale)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q, %d) = %s, want an error", tt.units, tt.scale, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q, %d): %v", tt.units, tt.scale, err)
+			continue
+		}
+		if got := d.String(); got != tt.want {
+			t.Errorf("Parse(%q, %d) = %s, want %s", tt.units, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		s       string
+		scale   int
+		units   string
+		wantErr bool
+	}{
+		{"0.000000000000000001", 18, "1", false},
+		{"-0.000000000000000001", 18, "-1", false},
+		{"-1234.56", 18, "-1234560000000000000000", false},
+		{"+5", 2, "500", false},
+		{".5", 18, "500000000000000000", false},
+		{"5.", 18, "5000000000000000000", false},
+		{"1.000", 0, "1", false},
+		{" 7 ", 6, "7000000", false},
+		{"99999999999999999999.999999999999999999", 18, "99999999999999999999999999999999999999", false},
+		{"-0", 18, "0", false},
+		{"", 18, "", true},
+		{"-", 18, "", true},
+		{".", 18, "", true},
+		{"1.0000000000000000001", 18, "", true},
+		{"0.5", 0, "", true},
+		{"1,5", 18, "", true},
+		{"1e3", 18, "", true},
+		{"--1", 18, "", true},
+		{"1.2.3", 18, "", true},
+		{"abc", 18, "", true},
+	}
+
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.s, tt.scale)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDecimal(%q, %d) = %s, want an error", tt.s, tt.scale, d)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDecimal(%q, %d): %v", tt.s, tt.scale, err)
+			continue
+		}
+		if got := d.Units().String(); got != tt.units {
+			t.Errorf("ParseDecimal(%q, %d) has %s base units, want %s", tt.s, tt.scale, got, tt.units)
+		}
+		if d.Scale() != tt.scale {
+			t.Errorf("ParseDecimal(%q, %d) has scale %d", tt.s, tt.scale, d.Scale())
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		units       string
+		scale       int
+		str, strFix string
+	}{
+		{"1", 18, "0.000000000000000001", "0.000000000000000001"},
+		{"-1", 18, "-0.000000000000000001", "-0.000000000000000001"},
+		{"1500000", 6, "1.5", "1.500000"},
+		{"-1500000", 6, "-1.5", "-1.500000"},
+		{"1000000000000000000", 18, "1", "1.000000000000000000"},
+		{"0", 6, "0", "0.000000"},
+		{"123", 0, "123", "123"},
+		{"100000000000000000000", 0, "100000000000000000000", "100000000000000000000"},
+		{"123456789012345678901234567", 18, "123456789.012345678901234567", "123456789.012345678901234567"},
+	}
+
+	for _, tt := range tests {
+		units, _ := new(big.Int).SetString(tt.units, 10)
+		d := New(units, tt.scale)
+		if got := d.String(); got != tt.str {
+			t.Errorf("String of %s at scale %d = %s, want %s", tt.units, tt.scale, got, tt.str)
+		}
+		if got := d.StringFixed(); got != tt.strFix {
+			t.Errorf("StringFixed of %s at scale %d = %s, want %s", tt.units, tt.scale, got, tt.strFix)
+		}
+	}
+
+	var zero Decimal
+	if zero.String() != "0" || zero.StringFixed() != "0" || !zero.IsZero() || zero.Sign() != 0 {
+		t.Errorf("zero value formats as %s and %s", zero.String(), zero.StringFixed())
+	}
+}
+
+// mustParse parses a human readable amount or fails the test
+func mustParse(t *testing.T, s string, scale int) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s, scale)
+	if err != nil {
+		t.Fatalf("ParseDecimal(%q, %d): %v", s, scale, err)
+	}
+	return d
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		a      string
+		aScale int
+		b      string
+		bScale int
+		sum    string
+		diff   string
+		cmp    int
+	}{
+		{"0.000000000000000001", 18, "0.000000000000000002", 18, "0.000000000000000003", "-0.000000000000000001", -1},
+		{"99999999999999999999.999999999999999999", 18, "0.000000000000000001", 18, "100000000000000000000", "99999999999999999999.999999999999999998", 1},
+		{"-5", 18, "-5", 18, "-10", "0", 0},
+		{"-0.000000000000000001", 18, "0.000000000000000001", 18, "0", "-0.000000000000000002", -1},
+		{"1.5", 6, "0.000000000000000001", 18, "1.500000000000000001", "1.499999999999999999", 1},
+		{"2", 0, "2.000000000000000000", 18, "4", "0", 0},
+	}
+
+	for _, tt := range tests {
+		a := mustParse(t, tt.a, tt.aScale)
+		b := mustParse(t, tt.b, tt.bScale)
+		if got := a.Add(b).String(); got != tt.sum {
+			t.Errorf("%s + %s = %s, want %s", tt.a, tt.b, got, tt.sum)
+		}
+		if got := a.Sub(b).String(); got != tt.diff {
+			t.Errorf("%s - %s = %s, want %s", tt.a, tt.b, got, tt.diff)
+		}
+		if got := a.Cmp(b); got != tt.cmp {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.cmp)
+		}
+		if got := b.Cmp(a); got != -tt.cmp {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.b, tt.a, got, -tt.cmp)
+		}
+		if scale := a.Add(b).Scale(); scale != max(tt.aScale, tt.bScale) {
+			t.Errorf("%s + %s has scale %d, want %d", tt.a, tt.b, scale, max(tt.aScale, tt.bScale))
+		}
+	}
+
+	// Operands are not modified
+	a := mustParse(t, "1", 18)
+	a.Add(a)
+	a.Neg().Units().SetInt64(7)
+	if a.String() != "1" {
+		t.Errorf("operand changed to %s", a)
+	}
+
+	var zero Decimal
+	if got := zero.Add(mustParse(t, "0.000000000000000001", 18)).String(); got != "0.000000000000000001" {
+		t.Errorf("0 + 1 wei = %s", got)
+	}
+}
+
+func TestRescale(t *testing.T) {
+	tests := []struct {
+		s        string
+		scale    int
+		rescale  int
+		fixed    string
+		newScale int
+	}{
+		{"1.5", 6, 18, "1.500000000000000000", 18},
+		{"-0.000001", 6, 18, "-0.000001000000000000", 18},
+		{"123456789", 0, 18, "123456789.000000000000000000", 18},
+		{"0.000000000000000001", 18, 18, "0.000000000000000001", 18},
+		{"0.000000000000000001", 18, 6, "0.000000000000000001", 18},
+		{"0", 0, 18, "0.000000000000000000", 18},
+	}
+
+	for _, tt := range tests {
+		d := mustParse(t, tt.s, tt.scale)
+		r := d.Rescale(tt.rescale)
+		if r.StringFixed() != tt.fixed || r.Scale() != tt.newScale {
+			t.Errorf("%s at scale %d rescaled to %d = %s at scale %d, want %s at scale %d",
+				tt.s, tt.scale, tt.rescale, r.StringFixed(), r.Scale(), tt.fixed, tt.newScale)
+		}
+		if r.Cmp(d) != 0 {
+			t.Errorf("rescaling %s changed its value to %s", tt.s, r)
+		}
+	}
+
+	var zero Decimal
+	if got := zero.Rescale(18).StringFixed(); got != "0.000000000000000000" {
+		t.Errorf("zero value rescaled to 18 = %s", got)
+	}
+}
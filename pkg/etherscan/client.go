@@ -1,12 +1,22 @@
 package etherscan
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"math/big"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/decimal"
 	"ethcrawler/pkg/models"
 )
 
@@ -18,152 +28,307 @@ var (
 	ColorYellow = "\033[33m"
 )
 
+// DefaultCallsPerSecond matches the free Etherscan API plan
+const DefaultCallsPerSecond = 5
+
 // Client represents an Etherscan API client
 type Client struct {
 	ApiKey   string
 	Contract string
 	BaseURL  string
+	ChainID  int // Etherscan V2 chainid, omitted from requests when zero
+
+	HTTPClient *http.Client
+	Retry      RetryPolicy
+	Limiter    *RateLimiter // Shared by every request the client makes
+	Log        io.Writer    // Progress and retry messages, nil discards them
 }
 
 // NewClient creates a new Etherscan API client
 func NewClient(apiKey, contract string) *Client {
 	return &Client{
-		ApiKey:   apiKey,
-		Contract: contract,
-		BaseURL:  "https://api.etherscan.io/api",
+		ApiKey:     apiKey,
+		Contract:   contract,
+		BaseURL:    chains.Ethereum.APIURL,
+		ChainID:    chains.Ethereum.ChainID,
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		Retry:      DefaultRetryPolicy,
+		Limiter:    NewRateLimiter(DefaultCallsPerSecond),
+		Log:        os.Stdout,
 	}
 }
 
-// GetTokenTransfers fetches ERC20 token transfers for a given address
-func (c *Client) GetTokenTransfers(address string) ([]models.ERC20Transfer, error) {
+// GetTokenTransfers fetches ERC20 token transfers for a given address within
+// the block range, 0 leaves a bound open
+func (c *Client) GetTokenTransfers(address string, startBlock, endBlock int) ([]models.ERC20Transfer, error) {
 	var allTransfers []models.ERC20Transfer
 
-	// Etherscan API limitation: page * offset must be <= 10000
-	// Using a dynamic pagination strategy to handle large datasets
-	maxWindow := 10000
-	initialPageSize := 5000
-
-	// First fetch with large page size to get most results efficiently
-	pageSize := initialPageSize
-	page := 1
-	startBlock := 0
-
-	// Display counter for showing logical page numbers to user
-	displayPage := 1
-
-	for {
-		// Check if we'd exceed the API limit with current page
-		if page*pageSize > maxWindow {
-			// We need to reset our pagination strategy
-			// Save the last block we processed
-			if len(allTransfers) > 0 {
-				lastTransfer := allTransfers[len(allTransfers)-1]
-				blockNum, err := models.StringToInt(lastTransfer.BlockNumber)
-				if err != nil {
-					return nil, fmt.Errorf("error converting block number: %v", err)
-				}
-				// Start from the next block
-				startBlock = blockNum + 1
+	q := models.Query{Address: address, Contract: c.Contract, StartBlock: startBlock, EndBlock: endBlock}
+	_, err := c.StreamTokenTransfers(context.Background(), q,
+		func(page []models.ERC20Transfer) error {
+			allTransfers = append(allTransfers, page...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return allTransfers, nil
+}
+
+// StreamTokenTransfers fetches ERC20 token transfers matching q and passes
+// every page to fn as soon as it arrives. The crawl stops when ctx is
+// cancelled or fn returns an error; the returned Progress describes what was
+// delivered to fn up to that point.
+func (c *Client) StreamTokenTransfers(ctx context.Context, q models.Query, fn func([]models.ERC20Transfer) error) (models.Progress, error) {
+	// Etherscan API limitation: page * offset must be <= 10000, so the
+	// window holds two pages of 5000 transfers
+	const pageSize = 5000
+	paging := Paging{
+		PageSize:    pageSize,
+		WindowPages: 10000 / pageSize,
+		Fetch: func(ctx context.Context, page, startBlock int) ([]models.ERC20Transfer, int, error) {
+			transfers, err := c.fetchPage(ctx, c.tokentxURL(q, page, pageSize, startBlock))
+			return transfers, len(transfers), err
+		},
+		Requesting: func(request, startBlock int) {
+			// Logical page numbers keep counting over windows
+			log := LogWriter(c.Log)
+			fmt.Fprintf(log, "%sDownloading page %d (transactions %d-%d)%s",
+				ColorYellow, request, (request-1)*pageSize+1, request*pageSize, ColorReset)
+
+			if startBlock > 0 {
+				fmt.Fprintf(log, "%s from block %d%s\n", ColorYellow, startBlock, ColorReset)
+			} else {
+				fmt.Fprintln(log, ColorReset)
 			}
-			// Reset pagination for API, but keep display counter incrementing
-			page = 1
-		}
+		},
+	}
 
-		// Calculate display transaction range based on total count so far
-		displayStart := ((displayPage - 1) * pageSize) + 1
-		displayEnd := displayPage * pageSize
+	progress, err := paging.Stream(ctx, q.StartBlock, fn)
+	if err != nil {
+		return progress, err
+	}
+
+	fmt.Fprintf(LogWriter(c.Log), "%sDownloaded %d transactions total%s\n",
+		ColorGreen, progress.Transfers, ColorReset)
+
+	return progress, nil
+}
 
-		fmt.Printf("%sDownloading page %d (transactions %d-%d)%s",
-			ColorYellow, displayPage, displayStart, displayEnd, ColorReset)
+// TokenBalance returns the current token balance of address in base units
+func (c *Client) TokenBalance(ctx context.Context, contract, address string) (*big.Int, error) {
+	params := url.Values{}
+	if c.ChainID != 0 {
+		params.Set("chainid", strconv.Itoa(c.ChainID))
+	}
+	params.Set("module", "account")
+	params.Set("action", "tokenbalance")
+	params.Set("contractaddress", contract)
+	params.Set("address", address)
+	params.Set("tag", "latest")
+	params.Set("apikey", c.ApiKey)
+	requestURL := c.BaseURL + "?" + params.Encode()
 
-		if startBlock > 0 {
-			fmt.Printf("%s from block %d%s\n", ColorYellow, startBlock, ColorReset)
-		} else {
-			fmt.Println(ColorReset)
+	var balance *big.Int
+	err := c.retry(ctx, func() error {
+		raw, err := c.get(ctx, requestURL)
+		if err != nil {
+			return err
+		}
+		if raw.Status != "1" {
+			return newAPIError(raw)
 		}
 
-		// Build URL with startBlock parameter if needed
-		var url string
-		if startBlock > 0 {
-			url = fmt.Sprintf(
-				"%s?module=account&action=tokentx&contractaddress=%s&address=%s&page=%d&offset=%d&sort=asc&startblock=%d&apikey=%s",
-				c.BaseURL, c.Contract, address, page, pageSize, startBlock, c.ApiKey,
-			)
-		} else {
-			url = fmt.Sprintf(
-				"%s?module=account&action=tokentx&contractaddress=%s&address=%s&page=%d&offset=%d&sort=asc&apikey=%s",
-				c.BaseURL, c.Contract, address, page, pageSize, c.ApiKey,
-			)
+		var text string
+		if err := json.Unmarshal(raw.Result, &text); err != nil {
+			return fmt.Errorf("error parsing balance: %v", err)
+		}
+		value, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return fmt.Errorf("error parsing balance %q", text)
 		}
+		balance = value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return balance, nil
+}
+
+// BlockNumberByTime returns the last block mined at or before t, or the first
+// block mined at or after t when after is set
+func (c *Client) BlockNumberByTime(ctx context.Context, t time.Time, after bool) (int, error) {
+	closest := "before"
+	if after {
+		closest = "after"
+	}
 
-		resp, err := http.Get(url)
+	params := url.Values{}
+	if c.ChainID != 0 {
+		params.Set("chainid", strconv.Itoa(c.ChainID))
+	}
+	params.Set("module", "block")
+	params.Set("action", "getblocknobytime")
+	params.Set("timestamp", strconv.FormatInt(t.Unix(), 10))
+	params.Set("closest", closest)
+	params.Set("apikey", c.ApiKey)
+	requestURL := c.BaseURL + "?" + params.Encode()
+
+	var block int
+	err := c.retry(ctx, func() error {
+		raw, err := c.get(ctx, requestURL)
 		if err != nil {
-			return nil, fmt.Errorf("error making request: %v", err)
+			return err
+		}
+		if raw.Status != "1" {
+			return newAPIError(raw)
 		}
 
-		body, err := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
-
+		var text string
+		if err := json.Unmarshal(raw.Result, &text); err != nil {
+			return fmt.Errorf("error parsing block number: %v", err)
+		}
+		block, err = models.StringToInt(text)
 		if err != nil {
-			return nil, fmt.Errorf("error reading response: %v", err)
+			return fmt.Errorf("error parsing block number: %v", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return block, nil
+}
+
+// tokentxURL builds the request URL for one page of token transfers
+func (c *Client) tokentxURL(q models.Query, page, pageSize, startBlock int) string {
+	params := url.Values{}
+	if c.ChainID != 0 {
+		params.Set("chainid", strconv.Itoa(c.ChainID))
+	}
+	params.Set("module", "account")
+	params.Set("action", "tokentx")
+	// Without a contract Etherscan returns transfers of every token
+	if q.Contract != "" {
+		params.Set("contractaddress", q.Contract)
+	}
+	params.Set("address", q.Address)
+	params.Set("page", strconv.Itoa(page))
+	params.Set("offset", strconv.Itoa(pageSize))
+	params.Set("sort", "asc")
+	if startBlock > 0 {
+		params.Set("startblock", strconv.Itoa(startBlock))
+	}
+	if q.EndBlock > 0 {
+		params.Set("endblock", strconv.Itoa(q.EndBlock))
+	}
+	params.Set("apikey", c.ApiKey)
 
-		var raw models.EtherscanResponse
-		err = json.Unmarshal(body, &raw)
+	return c.BaseURL + "?" + params.Encode()
+}
+
+// fetchPage requests a single tokentx page and decodes its transfers.
+// Transient failures are retried according to the client retry policy.
+func (c *Client) fetchPage(ctx context.Context, pageURL string) ([]models.ERC20Transfer, error) {
+	var pageTransfers []models.ERC20Transfer
+
+	err := c.retry(ctx, func() error {
+		raw, err := c.get(ctx, pageURL)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshalling response: %v", err)
+			return err
 		}
 
 		if raw.Status != "1" {
-			return nil, fmt.Errorf("Etherscan API error: %v", raw.Message)
+			// Etherscan reports an empty result as an error status
+			if isEmptyResult(raw) {
+				pageTransfers = nil
+				return nil
+			}
+			return newAPIError(raw)
 		}
 
-		var pageTransfers []models.ERC20Transfer
 		err = json.Unmarshal(raw.Result, &pageTransfers)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing list of transactions: %v", err)
-		}
-
-		// Add this page's transfers to the total
-		allTransfers = append(allTransfers, pageTransfers...)
-
-		// If we got fewer transfers than the page size, we've reached the end
-		if len(pageTransfers) < pageSize {
-			// If we were using a startBlock filter, we need to check if
-			// we've actually reached the end or just need to reset again
-			if startBlock > 0 && len(pageTransfers) == pageSize {
-				// Continue with next block range
-				lastTransfer := pageTransfers[len(pageTransfers)-1]
-				blockNum, err := models.StringToInt(lastTransfer.BlockNumber)
-				if err != nil {
-					return nil, fmt.Errorf("error converting block number: %v", err)
-				}
-				startBlock = blockNum + 1
-				page = 1
-				// Do not reset displayPage, it continues to increase
-				displayPage++
-				continue
-			}
-			// We've truly reached the end
-			break
+			return fmt.Errorf("error parsing list of transactions: %v", err)
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		// Increment page for next request
-		page++
-		displayPage++
+	return pageTransfers, nil
+}
 
-		// Sleep to avoid rate limiting
-		time.Sleep(200 * time.Millisecond)
+// get performs a single API call and decodes the response envelope
+func (c *Client) get(ctx context.Context, requestURL string) (*models.EtherscanResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
 	}
 
-	fmt.Printf("%sDownloaded %d transactions total%s\n",
-		ColorGreen, len(allTransfers), ColorReset)
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
 
-	return allTransfers, nil
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("error making request: %w", errors.Join(ErrTransport, err))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("error reading response: %w", errors.Join(ErrTransport, err))
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	var raw models.EtherscanResponse
+	err = json.Unmarshal(body, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	return &raw, nil
 }
 
-// FormatTransfers converts raw transfers to formatted transfers
-func FormatTransfers(transfers []models.ERC20Transfer) ([]models.FormattedTransfer, error) {
+// isEmptyResult reports whether a non-OK response just means "nothing found"
+func isEmptyResult(raw *models.EtherscanResponse) bool {
+	if !strings.HasPrefix(strings.ToLower(raw.Message), "no ") {
+		return false
+	}
+	var list []json.RawMessage
+	return json.Unmarshal(raw.Result, &list) == nil && len(list) == 0
+}
+
+// newAPIError builds an APIError from a non-OK response
+func newAPIError(raw *models.EtherscanResponse) *APIError {
+	apiErr := &APIError{Message: raw.Message}
+	var text string
+	if json.Unmarshal(raw.Result, &text) == nil {
+		apiErr.Result = text
+	}
+	return apiErr
+}
+
+// FormatTransfers converts raw transfers to formatted transfers classified
+// relative to address
+func FormatTransfers(transfers []models.ERC20Transfer, address string) ([]models.FormattedTransfer, error) {
 	var formatted []models.FormattedTransfer
 
 	for _, tx := range transfers {
@@ -172,6 +337,27 @@ func FormatTransfers(transfers []models.ERC20Transfer) ([]models.FormattedTransf
 			return nil, fmt.Errorf("error formatting timestamp: %v", err)
 		}
 
+		if _, err := decimal.Parse(tx.Value, 0); err != nil {
+			return nil, fmt.Errorf("error formatting value of %s: %v", tx.Hash, err)
+		}
+
+		blockNumber, err := models.StringToInt(tx.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("error formatting block number of %s: %v", tx.Hash, err)
+		}
+		// An empty log index is numbered below, a malformed one would break
+		// the order and the keys of stored transfers
+		logIndex := -1
+		if tx.LogIndex != "" {
+			logIndex, err = strconv.Atoi(tx.LogIndex)
+			if err != nil || logIndex < 0 {
+				return nil, fmt.Errorf("error formatting log index %q of %s", tx.LogIndex, tx.Hash)
+			}
+		}
+
+		// Decimals of unknown tokens are filled in later by the token resolver
+		decimals, _ := strconv.Atoi(tx.TokenDecimal)
+
 		formatted = append(formatted, models.FormattedTransfer{
 			Date:      date,
 			From:      tx.From,
@@ -179,8 +365,50 @@ func FormatTransfers(transfers []models.ERC20Transfer) ([]models.FormattedTransf
 			Value:     tx.Value,
 			Hash:      tx.Hash,
 			TimeStamp: timestamp,
+
+			BlockNumber: blockNumber,
+			LogIndex:    logIndex,
+
+			Contract:    strings.ToLower(tx.ContractAddress),
+			TokenName:   tx.TokenName,
+			TokenSymbol: tx.TokenSymbol,
+			Decimals:    decimals,
+
+			Direction: models.DirectionOf(tx.From, tx.To, address),
 		})
 	}
+	numberUnindexed(formatted)
 
 	return formatted, nil
 }
+
+// numberUnindexed replaces the unknown log indexes of a transaction with
+// -1, -2, ... in a stable order, so that hash and log index identify every
+// transfer even for sources that do not report log indexes
+func numberUnindexed(transfers []models.FormattedTransfer) {
+	byHash := make(map[string][]int)
+	for i, tx := range transfers {
+		if tx.LogIndex < 0 {
+			byHash[tx.Hash] = append(byHash[tx.Hash], i)
+		}
+	}
+
+	for _, indexes := range byHash {
+		sort.SliceStable(indexes, func(i, j int) bool {
+			a, b := transfers[indexes[i]], transfers[indexes[j]]
+			if a.Contract != b.Contract {
+				return a.Contract < b.Contract
+			}
+			if a.From != b.From {
+				return a.From < b.From
+			}
+			if a.To != b.To {
+				return a.To < b.To
+			}
+			return a.Value < b.Value
+		})
+		for n, i := range indexes {
+			transfers[i].LogIndex = -(n + 1)
+		}
+	}
+}
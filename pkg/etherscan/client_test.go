@@ -0,0 +1,164 @@
+package etherscan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"ethcrawler/pkg/models"
+)
+
+const testAddress = "0x1111111111111111111111111111111111111111"
+
+func TestFormatTransfersLogIndex(t *testing.T) {
+	transfer := func(logIndex string) models.ERC20Transfer {
+		return models.ERC20Transfer{
+			TimeStamp:   "1700000000",
+			From:        "0x2222222222222222222222222222222222222222",
+			To:          testAddress,
+			Value:       "1000000",
+			Hash:        "0xaa",
+			BlockNumber: "100",
+			LogIndex:    logIndex,
+		}
+	}
+
+	formatted, err := FormatTransfers([]models.ERC20Transfer{transfer("7"), transfer(""), transfer("")}, testAddress)
+	if err != nil {
+		t.Fatalf("FormatTransfers: %v", err)
+	}
+	got := []int{formatted[0].LogIndex, formatted[1].LogIndex, formatted[2].LogIndex}
+	if got[0] != 7 || got[1] != -1 || got[2] != -2 {
+		t.Errorf("log indexes = %v, want [7 -1 -2]", got)
+	}
+
+	for _, bad := range []string{"abc", "0x1f", "-3", "1.5"} {
+		if _, err := FormatTransfers([]models.ERC20Transfer{transfer(bad)}, testAddress); err == nil {
+			t.Errorf("FormatTransfers accepted log index %q", bad)
+		}
+	}
+}
+
+// tokentxServer serves tokentx pages of transfers in block order like
+// Etherscan, refusing pages beyond the 10,000 result window
+type tokentxServer struct {
+	transfers []models.ERC20Transfer
+
+	mu       sync.Mutex
+	requests []url.Values
+}
+
+func (s *tokentxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	s.requests = append(s.requests, q)
+	s.mu.Unlock()
+
+	page, _ := strconv.Atoi(q.Get("page"))
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	startBlock, _ := strconv.Atoi(q.Get("startblock"))
+	if page*offset > 10000 {
+		fmt.Fprint(w, `{"status":"0","message":"NOTOK","result":"Result window is too large, PageNo x Offset size must be less than or equal to 10000"}`)
+		return
+	}
+
+	var matching []models.ERC20Transfer
+	for _, tx := range s.transfers {
+		if block, _ := strconv.Atoi(tx.BlockNumber); block >= startBlock {
+			matching = append(matching, tx)
+		}
+	}
+	start := min((page-1)*offset, len(matching))
+	end := min(page*offset, len(matching))
+	if start == end {
+		fmt.Fprint(w, `{"status":"0","message":"No transactions found","result":[]}`)
+		return
+	}
+
+	result, _ := json.Marshal(matching[start:end])
+	fmt.Fprintf(w, `{"status":"1","message":"OK","result":%s}`, result)
+}
+
+func TestStreamRestartsWindowInsideBlock(t *testing.T) {
+	const splitBlock = 2000
+	var transfers []models.ERC20Transfer
+	add := func(block int, hash string) {
+		transfers = append(transfers, models.ERC20Transfer{
+			TimeStamp:   "1700000000",
+			From:        "0x2222222222222222222222222222222222222222",
+			To:          testAddress,
+			Value:       "5",
+			Hash:        hash,
+			BlockNumber: strconv.Itoa(block),
+		})
+	}
+	// 9,990 transfers in blocks of ten, then a block of 30 identical payouts
+	// without log index that straddles the 10,000 result window, then more
+	for i := 0; i < 9990; i++ {
+		add(1000+i/10, fmt.Sprintf("0x%064x", i))
+	}
+	for i := 0; i < 30; i++ {
+		add(splitBlock, "0xsplit")
+	}
+	for i := 0; i < 1980; i++ {
+		add(splitBlock+1+i/10, fmt.Sprintf("0x%064x", 10000+i))
+	}
+
+	server := &tokentxServer{transfers: transfers}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	// Pages of 5,000 transfers take longer than the timeout of retry tests
+	client := newTestClient(ts.URL)
+	client.HTTPClient = &http.Client{}
+	keys := models.NewTransferKeys()
+	delivered := make(map[string]int)
+	inSplitBlock := 0
+	progress, err := client.StreamTokenTransfers(context.Background(), models.Query{Address: testAddress},
+		func(page []models.ERC20Transfer) error {
+			for _, tx := range page {
+				delivered[keys.Key(tx)]++
+				if tx.BlockNumber == strconv.Itoa(splitBlock) {
+					inSplitBlock++
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("StreamTokenTransfers: %v", err)
+	}
+
+	if progress.Transfers != len(transfers) {
+		t.Errorf("delivered %d transfers, want %d", progress.Transfers, len(transfers))
+	}
+	if len(delivered) != len(transfers) {
+		t.Errorf("delivered %d distinct transfers, want %d", len(delivered), len(transfers))
+	}
+	for key, n := range delivered {
+		if n > 1 {
+			t.Errorf("transfer %s delivered %d times", key, n)
+		}
+	}
+	if inSplitBlock != 30 {
+		t.Errorf("delivered %d transfers of block %d, want 30", inSplitBlock, splitBlock)
+	}
+	if progress.LastBlock != splitBlock+198 {
+		t.Errorf("last block = %d, want %d", progress.LastBlock, splitBlock+198)
+	}
+
+	// Two pages fill the window, the third request restarts at the split block
+	if len(server.requests) != 3 {
+		t.Fatalf("made %d requests, want 3", len(server.requests))
+	}
+	restart := server.requests[2]
+	if restart.Get("startblock") != strconv.Itoa(splitBlock) || restart.Get("page") != "1" {
+		t.Errorf("third request has startblock %s and page %s, want %d and 1",
+			restart.Get("startblock"), restart.Get("page"), splitBlock)
+	}
+}
@@ -0,0 +1,106 @@
+package etherscan
+
+import (
+	"context"
+	"fmt"
+
+	"ethcrawler/pkg/models"
+)
+
+// Paging describes a tokentx style API that serves a query page by page but
+// only up to a window of WindowPages pages. It is shared by the Etherscan
+// and Blockscout sources.
+type Paging struct {
+	PageSize    int
+	WindowPages int
+
+	// Fetch requests page (counting from 1 in every window) of the transfers
+	// from startBlock on. Besides the transfers it returns the number of rows
+	// the API sent, including rows the source dropped, which tells whether
+	// the page was the last one.
+	Fetch func(ctx context.Context, page, startBlock int) ([]models.ERC20Transfer, int, error)
+
+	// Requesting is called before every request with its number, counting
+	// from 1 over all windows. It may be nil.
+	Requesting func(request, startBlock int)
+}
+
+// Stream pages through the transfers from startBlock on and passes every page
+// to fn. Once a window is used up, the next one starts from the last block
+// seen rather than the next one, since that block may have more transfers,
+// and the transfers already delivered from it are skipped. The crawl stops
+// when ctx is cancelled or fn returns an error; the returned Progress
+// describes what was delivered to fn up to that point.
+func (p Paging) Stream(ctx context.Context, startBlock int, fn func([]models.ERC20Transfer) error) (models.Progress, error) {
+	var progress models.Progress
+
+	// Keys of already delivered transfers in the last seen block. Keys are
+	// counted per block and window.
+	keys := models.NewTransferKeys()
+	lastBlockKeys := make(map[string]struct{})
+
+	page := 1
+	for request := 1; ; request++ {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		if page > p.WindowPages {
+			if progress.LastBlock == startBlock {
+				return progress, fmt.Errorf("block %d has more than %d transfers and cannot be paged through", startBlock, p.PageSize*p.WindowPages)
+			}
+			startBlock = progress.LastBlock
+			page = 1
+			keys.Reset()
+		}
+
+		if p.Requesting != nil {
+			p.Requesting(request, startBlock)
+		}
+
+		pageTransfers, rows, err := p.Fetch(ctx, page, startBlock)
+		if err != nil {
+			return progress, err
+		}
+
+		// Drop transfers already delivered before the window was restarted
+		newTransfers := make([]models.ERC20Transfer, 0, len(pageTransfers))
+		lastBlock := progress.LastBlock
+		for _, tx := range pageTransfers {
+			blockNum, err := models.StringToInt(tx.BlockNumber)
+			if err != nil {
+				return progress, fmt.Errorf("error converting block number: %v", err)
+			}
+
+			if blockNum != lastBlock {
+				lastBlock = blockNum
+				clear(lastBlockKeys)
+				keys.Reset()
+			}
+			key := keys.Key(tx)
+			if _, seen := lastBlockKeys[key]; seen {
+				continue
+			}
+			lastBlockKeys[key] = struct{}{}
+
+			newTransfers = append(newTransfers, tx)
+		}
+
+		if len(newTransfers) > 0 {
+			// Hand this page over to the caller
+			if err := fn(newTransfers); err != nil {
+				return progress, err
+			}
+
+			progress.Pages++
+			progress.Transfers += len(newTransfers)
+			progress.LastBlock = lastBlock
+		}
+
+		// If we got fewer rows than the page size, we've reached the end
+		if rows < p.PageSize {
+			return progress, nil
+		}
+		page++
+	}
+}
@@ -0,0 +1,93 @@
+package etherscan
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/models"
+)
+
+// pagedServer serves transfers sorted by block like tokentx does: pages of
+// pageSize from the first transfer at or after startBlock
+type pagedServer struct {
+	transfers []models.ERC20Transfer
+	pageSize  int
+	requests  []string
+}
+
+func (s *pagedServer) fetch(_ context.Context, page, startBlock int) ([]models.ERC20Transfer, int, error) {
+	s.requests = append(s.requests, strconv.Itoa(page)+"@"+strconv.Itoa(startBlock))
+
+	var matching []models.ERC20Transfer
+	for _, tx := range s.transfers {
+		if block, _ := models.StringToInt(tx.BlockNumber); block >= startBlock {
+			matching = append(matching, tx)
+		}
+	}
+	from := min((page-1)*s.pageSize, len(matching))
+	to := min(from+s.pageSize, len(matching))
+	return matching[from:to], to - from, nil
+}
+
+func pagedTransfer(block, logIndex int) models.ERC20Transfer {
+	return models.ERC20Transfer{
+		Hash:        "0x" + strconv.Itoa(block) + strconv.Itoa(logIndex),
+		BlockNumber: strconv.Itoa(block),
+		LogIndex:    strconv.Itoa(logIndex),
+	}
+}
+
+func TestPagingRestartsWindowsFromLastBlock(t *testing.T) {
+	// Block 102 straddles the end of the first window
+	server := &pagedServer{pageSize: 2, transfers: []models.ERC20Transfer{
+		pagedTransfer(100, 0), pagedTransfer(101, 0), pagedTransfer(101, 1),
+		pagedTransfer(102, 0), pagedTransfer(102, 1), pagedTransfer(103, 0),
+	}}
+	var requested []int
+	paging := Paging{
+		PageSize:    2,
+		WindowPages: 2,
+		Fetch:       server.fetch,
+		Requesting:  func(request, _ int) { requested = append(requested, request) },
+	}
+
+	var got []string
+	progress, err := paging.Stream(context.Background(), 0, func(page []models.ERC20Transfer) error {
+		for _, tx := range page {
+			got = append(got, tx.Hash)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+
+	want := "0x1000,0x1010,0x1011,0x1020,0x1021,0x1030"
+	if strings.Join(got, ",") != want {
+		t.Errorf("delivered %v, want %s", got, want)
+	}
+	if progress.Transfers != 6 || progress.LastBlock != 103 {
+		t.Errorf("progress = %+v, want 6 transfers up to block 103", progress)
+	}
+	if requests := strings.Join(server.requests, ","); requests != "1@0,2@0,1@102,2@102" {
+		t.Errorf("requests %s, want the second window from block 102", requests)
+	}
+	if len(requested) != 4 || requested[3] != 4 {
+		t.Errorf("requests reported as %v, want 1 to 4", requested)
+	}
+}
+
+func TestPagingBlockLargerThanWindow(t *testing.T) {
+	server := &pagedServer{pageSize: 2, transfers: []models.ERC20Transfer{
+		pagedTransfer(100, 0), pagedTransfer(100, 1), pagedTransfer(100, 2),
+		pagedTransfer(100, 3), pagedTransfer(100, 4),
+	}}
+	paging := Paging{PageSize: 2, WindowPages: 2, Fetch: server.fetch}
+
+	_, err := paging.Stream(context.Background(), 100, func([]models.ERC20Transfer) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "block 100 has more than 4 transfers") {
+		t.Errorf("Stream = %v, want the block to be reported", err)
+	}
+}
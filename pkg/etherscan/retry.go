@@ -0,0 +1,258 @@
+package etherscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts per request, including the first one
+	BaseDelay   time.Duration // Backoff before the second attempt
+	MaxDelay    time.Duration // Upper bound for a single backoff
+}
+
+// DefaultRetryPolicy is used by clients created with NewClient
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 6,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// rateLimitDelay is the minimal pause after a server reports a rate limit
+const rateLimitDelay = time.Second
+
+// Backoff returns a jittered exponential delay before the given retry (1-based)
+func (p RetryPolicy) Backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	// Equal jitter: anywhere between half and the whole delay
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// APIError is returned when Etherscan answers with a non-OK status
+type APIError struct {
+	Message string // "message" field of the response, usually NOTOK
+	Result  string // "result" field when it carries the error text
+}
+
+func (e *APIError) Error() string {
+	if e.Result != "" && e.Result != e.Message {
+		return fmt.Sprintf("Etherscan API error: %s (%s)", e.Message, e.Result)
+	}
+	return fmt.Sprintf("Etherscan API error: %s", e.Message)
+}
+
+// RateLimited reports whether Etherscan rejected the call because of rate limits
+func (e *APIError) RateLimited() bool {
+	text := strings.ToLower(e.Message + " " + e.Result)
+	return strings.Contains(text, "rate limit")
+}
+
+// InvalidKey reports whether Etherscan rejected the API key. Rate limit
+// messages mention the key too ("please use API Key for higher rate limit").
+func (e *APIError) InvalidKey() bool {
+	text := strings.ToLower(e.Message + " " + e.Result)
+	mentionsKey := strings.Contains(text, "api key") || strings.Contains(text, "apikey")
+	return mentionsKey && (strings.Contains(text, "invalid") || strings.Contains(text, "missing"))
+}
+
+// temporary reports whether the same request may succeed later
+func (e *APIError) temporary() bool {
+	if e.InvalidKey() {
+		return false
+	}
+	text := strings.ToLower(e.Message + " " + e.Result)
+	return e.RateLimited() ||
+		strings.Contains(text, "timeout") ||
+		strings.Contains(text, "timed out") ||
+		strings.Contains(text, "unexpected error") ||
+		strings.Contains(text, "try again")
+}
+
+// HTTPError is returned when an API answers with a non-200 status code
+type HTTPError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status: %s", e.Status)
+}
+
+// RateLimited reports whether the server answered 429 Too Many Requests
+func (e *HTTPError) RateLimited() bool {
+	return e.StatusCode == 429
+}
+
+// IsTemporary reports whether err is a transient failure worth retrying:
+// network errors, 5xx and 429 responses, rate limits and Etherscan timeouts.
+// Errors of other sources decide for themselves through a Temporary method.
+// Invalid API keys and other client errors are permanent.
+func IsTemporary(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.temporary()
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 500 || httpErr.StatusCode == 429
+	}
+
+	// Requests cut off by the HTTP client timeout carry DeadlineExceeded as
+	// well, but as transport errors. A bare context error means the crawl
+	// itself was cancelled.
+	if errors.Is(err, ErrTransport) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
+
+// isRateLimited reports whether err says that calls come too fast
+func isRateLimited(err error) bool {
+	var limited interface{ RateLimited() bool }
+	return errors.As(err, &limited) && limited.RateLimited()
+}
+
+// ErrTransport marks failures while sending a request or reading its body.
+// Sources wrap it with the cause to have them retried.
+var ErrTransport = errors.New("transport error")
+
+// retry calls fn with the retry policy and rate limiter of the client
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	return Retry(ctx, c.Retry, c.Limiter, c.Log, fn)
+}
+
+// Retry calls fn until it succeeds, fails permanently or runs out of the
+// attempts of policy. Every attempt waits for limiter first, a nil limiter
+// does not limit. Retries are reported to log, a nil log discards them.
+// Errors are classified by IsTemporary. It is shared by all HTTP based
+// sources.
+func Retry(ctx context.Context, policy RetryPolicy, limiter *RateLimiter, log io.Writer, fn func() error) error {
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+
+		err = fn()
+		if err == nil || attempt >= attempts || !IsTemporary(err) {
+			return err
+		}
+
+		delay := policy.Backoff(attempt)
+		if isRateLimited(err) && delay < rateLimitDelay {
+			delay = rateLimitDelay
+		}
+
+		fmt.Fprintf(LogWriter(log), "%sRequest failed: %v. Retrying in %s (attempt %d of %d)%s\n",
+			ColorYellow, err, delay.Round(time.Millisecond), attempt+1, attempts, ColorReset)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// LogWriter returns log, or a writer discarding the messages if it is nil.
+// Sources print their progress through it.
+func LogWriter(log io.Writer) io.Writer {
+	if log == nil {
+		return io.Discard
+	}
+	return log
+}
+
+// RateLimiter spaces out calls so that no more than the configured number of
+// calls per second is made. It is safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewRateLimiter creates a limiter allowing callsPerSecond calls per second.
+// A non-positive rate disables limiting.
+func NewRateLimiter(callsPerSecond float64) *RateLimiter {
+	var interval time.Duration
+	if callsPerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / callsPerSecond)
+	}
+	return &RateLimiter{interval: interval}
+}
+
+// CallsPerSecond returns the rate of the limiter, 0 if limiting is disabled
+func (l *RateLimiter) CallsPerSecond() float64 {
+	if l.interval <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(l.interval)
+}
+
+// Wait blocks until the next call is allowed or ctx is cancelled
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	slot := l.next
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
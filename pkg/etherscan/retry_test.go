@@ -0,0 +1,288 @@
+package etherscan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testPolicy retries quickly so that tests stay fast
+var testPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+// failures answers the first len(fail) requests with the given handlers and
+// every later one with a token balance of 42
+type failures struct {
+	fail     []http.HandlerFunc
+	requests atomic.Int32
+}
+
+func (s *failures) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := int(s.requests.Add(1))
+	if n <= len(s.fail) {
+		s.fail[n-1](w, r)
+		return
+	}
+	fmt.Fprint(w, `{"status":"1","message":"OK","result":"42"}`)
+}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}
+}
+
+func apiError(result string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":"0","message":"NOTOK","result":%q}`, result)
+	}
+}
+
+func hang(d time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(d):
+		}
+	}
+}
+
+func newTestClient(url string) *Client {
+	client := NewClient("key", "0xcontract")
+	client.BaseURL = url
+	client.HTTPClient = &http.Client{Timeout: 100 * time.Millisecond}
+	client.Retry = testPolicy
+	client.Limiter = nil
+	return client
+}
+
+func TestRetryInjectedFailures(t *testing.T) {
+	tests := []struct {
+		name     string
+		fail     []http.HandlerFunc
+		requests int
+		wantErr  bool
+	}{
+		{"success", nil, 1, false},
+		{"429", []http.HandlerFunc{status(429), status(429)}, 3, false},
+		{"5xx", []http.HandlerFunc{status(502), status(503), status(500)}, 4, false},
+		{"rate limit message", []http.HandlerFunc{apiError("Max rate limit reached")}, 2, false},
+		{"timeout", []http.HandlerFunc{hang(time.Second)}, 2, false},
+		{"Etherscan timeout", []http.HandlerFunc{apiError("Query Timeout occured. Please select a smaller result dataset")}, 2, false},
+		{"invalid key", []http.HandlerFunc{apiError("Invalid API Key")}, 1, true},
+		{"not found", []http.HandlerFunc{status(404)}, 1, true},
+		{"attempts exhausted", []http.HandlerFunc{status(500), status(500), status(500), status(500)}, 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &failures{fail: tt.fail}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			balance, err := newTestClient(ts.URL).TokenBalance(context.Background(), "0xcontract", "0xaddress")
+			if got := int(server.requests.Load()); got != tt.requests {
+				t.Errorf("requests = %d, want %d", got, tt.requests)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("TokenBalance succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TokenBalance: %v", err)
+			}
+			if balance.String() != "42" {
+				t.Errorf("balance = %s, want 42", balance)
+			}
+		})
+	}
+}
+
+func TestRetryPermanentAPIError(t *testing.T) {
+	server := &failures{fail: []http.HandlerFunc{apiError("Invalid API Key")}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	_, err := newTestClient(ts.URL).TokenBalance(context.Background(), "0xcontract", "0xaddress")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.InvalidKey() {
+		t.Fatalf("error = %v, want an invalid key APIError", err)
+	}
+	if n := server.requests.Load(); n != 1 {
+		t.Errorf("requests = %d, a permanent error must not be retried", n)
+	}
+}
+
+func TestRetryStopsOnCancel(t *testing.T) {
+	ts := httptest.NewServer(status(500))
+	defer ts.Close()
+
+	client := newTestClient(ts.URL)
+	client.Retry = RetryPolicy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.TokenBalance(ctx, "0xcontract", "0xaddress")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry waited %s after the context was done", elapsed)
+	}
+}
+
+func TestRetryLogsToWriter(t *testing.T) {
+	var log strings.Builder
+	calls := 0
+	err := Retry(context.Background(), testPolicy, nil, &log, func() error {
+		calls++
+		if calls < 3 {
+			return &HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Retry: %v", err)
+	}
+	if n := strings.Count(log.String(), "Request failed"); n != 2 {
+		t.Errorf("logged %d retries, want 2:\n%s", n, log.String())
+	}
+
+	// A nil log discards the messages
+	calls = 0
+	if err := Retry(context.Background(), testPolicy, nil, nil, func() error {
+		calls++
+		if calls < 2 {
+			return &HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Retry without log: %v", err)
+	}
+}
+
+func TestBackoffBounds(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry := 1; retry <= 10; retry++ {
+		full := policy.BaseDelay << (retry - 1)
+		if full > policy.MaxDelay {
+			full = policy.MaxDelay
+		}
+		for i := 0; i < 100; i++ {
+			delay := policy.Backoff(retry)
+			if delay < full/2 || delay > full {
+				t.Fatalf("Backoff(%d) = %s, want between %s and %s", retry, delay, full/2, full)
+			}
+		}
+	}
+
+	if delay := (RetryPolicy{}).Backoff(3); delay != 0 {
+		t.Errorf("Backoff without delays = %s, want 0", delay)
+	}
+}
+
+func TestIsTemporary(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"rate limit", &APIError{Message: "NOTOK", Result: "Max rate limit reached"}, true},
+		{"rate limit wrapped", fmt.Errorf("error fetching: %w", &APIError{Message: "NOTOK", Result: "Max rate limit reached, please use API Key for higher rate limit"}), true},
+		{"invalid key", &APIError{Message: "NOTOK", Result: "Invalid API Key"}, false},
+		{"missing key", &APIError{Message: "NOTOK", Result: "Missing/Invalid API Key, rate limit of 1/5sec applied"}, false},
+		{"unknown action", &APIError{Message: "NOTOK", Result: "Error! Invalid address format"}, false},
+		{"429", &HTTPError{StatusCode: 429, Status: "429 Too Many Requests"}, true},
+		{"503", &HTTPError{StatusCode: 503, Status: "503 Service Unavailable"}, true},
+		{"404", &HTTPError{StatusCode: 404, Status: "404 Not Found"}, false},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{"transport", fmt.Errorf("error reading response: %w", errors.Join(ErrTransport, errors.New("unexpected EOF"))), true},
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"other", errors.New("error parsing balance"), false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTemporary(tt.err); got != tt.want {
+			t.Errorf("%s: IsTemporary(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterSharedAcrossGoroutines(t *testing.T) {
+	const calls = 8
+	const interval = 40 * time.Millisecond
+
+	var mu sync.Mutex
+	var arrivals []time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		fmt.Fprint(w, `{"status":"1","message":"OK","result":"42"}`)
+	}))
+	defer ts.Close()
+
+	client := newTestClient(ts.URL)
+	client.Limiter = NewRateLimiter(float64(time.Second / interval))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.TokenBalance(context.Background(), "0xcontract", "0xaddress")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("TokenBalance: %v", err)
+		}
+	}
+
+	sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].Before(arrivals[j]) })
+	if len(arrivals) != calls {
+		t.Fatalf("server saw %d requests, want %d", len(arrivals), calls)
+	}
+	// Requests leave the limiter interval apart; allow some scheduling jitter
+	// on arrival
+	const slack = 15 * time.Millisecond
+	for i := 1; i < len(arrivals); i++ {
+		if gap := arrivals[i].Sub(arrivals[i-1]); gap < interval-slack {
+			t.Errorf("requests %d and %d arrived %s apart, want at least %s", i, i+1, gap, interval)
+		}
+	}
+	if total := arrivals[len(arrivals)-1].Sub(arrivals[0]); total < (calls-1)*interval-slack {
+		t.Errorf("%d requests took %s, want at least %s", calls, total, (calls-1)*interval)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := NewRateLimiter(1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("first Wait: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait = %v, want context.DeadlineExceeded", err)
+	}
+}
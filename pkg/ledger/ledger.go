@@ -0,0 +1,113 @@
+package ledger
+
+import (
+	"sort"
+	"strings"
+
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/models"
+)
+
+// Ledger is the reconstructed balance history of one token for the queried
+// address
+type Ledger struct {
+	Contract string
+	Symbol   string
+	Decimals int
+
+	Opening  decimal.Decimal  // Balance before the first transfer
+	Closing  decimal.Decimal  // Balance after the last transfer
+	Negative int              // Transfers after which the balance was below zero
+	OnChain  *decimal.Decimal // Balance read from the chain, nil if not checked
+}
+
+// Mismatch reports whether the reconstructed balance cannot be right: it went
+// below zero or disagrees with the balance read from the chain. Both usually
+// mean that transfers are missing.
+func (l Ledger) Mismatch() bool {
+	return l.Negative > 0 || l.OnChain != nil && l.OnChain.Cmp(l.Closing) != 0
+}
+
+// Before reports whether a comes before b on the chain: by block and, within
+// a block, by log index. Transfers without a log index are numbered per
+// transaction, which says nothing about their place in the block, so they
+// keep the order of the source.
+func Before(a, b models.FormattedTransfer) bool {
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	return a.LogIndex >= 0 && b.LogIndex >= 0 && a.LogIndex < b.LogIndex
+}
+
+// Build orders transfers with Before and sets the running balance of every
+// transfer, separately for each token contract. Each token starts at its
+// opening balance, tokens missing from openings start at zero. Ledgers are
+// returned in the order of their contracts.
+func Build(transfers []models.FormattedTransfer, openings map[string]decimal.Decimal) []Ledger {
+	sort.SliceStable(transfers, func(i, j int) bool {
+		return Before(transfers[i], transfers[j])
+	})
+
+	r := NewRunning(openings)
+	for i := range transfers {
+		r.Add(&transfers[i])
+	}
+	return r.Ledgers()
+}
+
+// Running builds the ledgers of transfers that arrive one at a time, already
+// already in the order of Before, without holding them
+type Running struct {
+	openings map[string]decimal.Decimal
+	index    map[string]int
+	ledgers  []Ledger
+}
+
+// NewRunning starts ledgers at the opening balances like Build
+func NewRunning(openings map[string]decimal.Decimal) *Running {
+	return &Running{openings: openings, index: make(map[string]int)}
+}
+
+// Add sets the running balance of tx and updates the ledger of its token
+func (r *Running) Add(tx *models.FormattedTransfer) {
+	contract := strings.ToLower(tx.Contract)
+
+	n, ok := r.index[contract]
+	if !ok {
+		n = len(r.ledgers)
+		r.index[contract] = n
+		opening := r.openings[contract]
+		r.ledgers = append(r.ledgers, Ledger{
+			Contract: contract,
+			Symbol:   tx.TokenSymbol,
+			Decimals: tx.Decimals,
+			Opening:  opening,
+			Closing:  opening,
+		})
+	}
+
+	l := &r.ledgers[n]
+	l.Closing = l.Closing.Add(tx.SignedAmount())
+	if l.Closing.Sign() < 0 {
+		l.Negative++
+	}
+	tx.Balance = l.Closing
+}
+
+// Ledgers returns the ledgers of the added transfers in the order of their
+// contracts
+func (r *Running) Ledgers() []Ledger {
+	ledgers := append([]Ledger(nil), r.ledgers...)
+	sort.Slice(ledgers, func(i, j int) bool { return ledgers[i].Contract < ledgers[j].Contract })
+	return ledgers
+}
+
+// Find returns the ledger of contract
+func Find(ledgers []Ledger, contract string) (Ledger, bool) {
+	for _, l := range ledgers {
+		if strings.EqualFold(l.Contract, contract) {
+			return l, true
+		}
+	}
+	return Ledger{}, false
+}
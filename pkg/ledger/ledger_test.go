@@ -0,0 +1,177 @@
+package ledger
+
+import (
+	"testing"
+
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/models"
+)
+
+const (
+	usdt = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	weth = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+)
+
+// transfer returns a transfer of value base units of a 6 decimal token
+func transfer(contract string, block, logIndex int, direction models.Direction, value string) models.FormattedTransfer {
+	return models.FormattedTransfer{
+		BlockNumber: block,
+		LogIndex:    logIndex,
+		Contract:    contract,
+		Decimals:    6,
+		Direction:   direction,
+		Value:       value,
+	}
+}
+
+// amount parses an amount in token units
+func amount(t *testing.T, s string) decimal.Decimal {
+	t.Helper()
+	d, err := decimal.ParseDecimal(s, 6)
+	if err != nil {
+		t.Fatalf("ParseDecimal(%s): %v", s, err)
+	}
+	return d
+}
+
+func TestBuild(t *testing.T) {
+	tests := []struct {
+		name      string
+		transfers []models.FormattedTransfer
+		openings  map[string]string
+		balances  []string // Running balances in block and log order
+		closing   map[string]string
+		negative  map[string]int
+	}{
+		{
+			name: "in and out",
+			transfers: []models.FormattedTransfer{
+				transfer(usdt, 10, 0, models.DirectionIn, "5000000"),
+				transfer(usdt, 11, 0, models.DirectionOut, "1500000"),
+				transfer(usdt, 12, 0, models.DirectionSelf, "900000"),
+			},
+			balances: []string{"5", "3.5", "3.5"},
+			closing:  map[string]string{usdt: "3.5"},
+		},
+		{
+			name: "block and log order",
+			transfers: []models.FormattedTransfer{
+				transfer(usdt, 12, 0, models.DirectionOut, "1000000"),
+				transfer(usdt, 10, 3, models.DirectionIn, "2000000"),
+				transfer(usdt, 10, 1, models.DirectionIn, "1000000"),
+			},
+			balances: []string{"1", "3", "2"},
+			closing:  map[string]string{usdt: "2"},
+		},
+		{
+			// Transfers without a log index are numbered per transaction and
+			// keep the order of the source
+			name: "unindexed transactions in one block",
+			transfers: []models.FormattedTransfer{
+				transfer(usdt, 10, -1, models.DirectionIn, "100000000"),
+				transfer(usdt, 10, -1, models.DirectionOut, "50000000"),
+				transfer(usdt, 10, -2, models.DirectionOut, "50000000"),
+			},
+			balances: []string{"100", "50", "0"},
+			closing:  map[string]string{usdt: "0"},
+		},
+		{
+			name: "opening balance",
+			transfers: []models.FormattedTransfer{
+				transfer(usdt, 10, 0, models.DirectionOut, "4000000"),
+			},
+			openings: map[string]string{usdt: "10"},
+			balances: []string{"6"},
+			closing:  map[string]string{usdt: "6"},
+		},
+		{
+			name: "below zero",
+			transfers: []models.FormattedTransfer{
+				transfer(usdt, 10, 0, models.DirectionOut, "1000000"),
+				transfer(usdt, 11, 0, models.DirectionOut, "1000000"),
+				transfer(usdt, 12, 0, models.DirectionIn, "5000000"),
+			},
+			balances: []string{"-1", "-2", "3"},
+			closing:  map[string]string{usdt: "3"},
+			negative: map[string]int{usdt: 2},
+		},
+		{
+			name: "tokens apart",
+			transfers: []models.FormattedTransfer{
+				transfer(weth, 10, 0, models.DirectionIn, "7000000"),
+				transfer(usdt, 10, 1, models.DirectionIn, "1000000"),
+				transfer(weth, 11, 0, models.DirectionOut, "2000000"),
+			},
+			openings: map[string]string{usdt: "1"},
+			balances: []string{"7", "2", "5"},
+			closing:  map[string]string{usdt: "2", weth: "5"},
+		},
+	}
+
+	for _, tt := range tests {
+		openings := make(map[string]decimal.Decimal)
+		for contract, s := range tt.openings {
+			openings[contract] = amount(t, s)
+		}
+
+		ledgers := Build(tt.transfers, openings)
+
+		for i, tx := range tt.transfers {
+			if tx.Balance.Cmp(amount(t, tt.balances[i])) != 0 {
+				t.Errorf("%s: balance after transfer %d = %s, want %s", tt.name, i, tx.Balance, tt.balances[i])
+			}
+		}
+		if len(ledgers) != len(tt.closing) {
+			t.Fatalf("%s: got %d ledgers, want %d", tt.name, len(ledgers), len(tt.closing))
+		}
+		for i, l := range ledgers {
+			if i > 0 && ledgers[i-1].Contract > l.Contract {
+				t.Errorf("%s: ledgers are not ordered by contract", tt.name)
+			}
+			if l.Closing.Cmp(amount(t, tt.closing[l.Contract])) != 0 {
+				t.Errorf("%s: closing balance of %s = %s, want %s", tt.name, l.Contract, l.Closing, tt.closing[l.Contract])
+			}
+			if l.Opening.Cmp(openings[l.Contract]) != 0 {
+				t.Errorf("%s: opening balance of %s = %s, want %s", tt.name, l.Contract, l.Opening, openings[l.Contract])
+			}
+			if l.Negative != tt.negative[l.Contract] {
+				t.Errorf("%s: %s negative after %d transfers, want %d", tt.name, l.Contract, l.Negative, tt.negative[l.Contract])
+			}
+		}
+	}
+}
+
+func TestMismatch(t *testing.T) {
+	onChain := func(s string) *decimal.Decimal {
+		d := amount(t, s)
+		return &d
+	}
+
+	tests := []struct {
+		name   string
+		ledger Ledger
+		want   bool
+	}{
+		{"not checked", Ledger{Closing: amount(t, "5")}, false},
+		{"matches", Ledger{Closing: amount(t, "5"), OnChain: onChain("5.000000")}, false},
+		{"differs", Ledger{Closing: amount(t, "5"), OnChain: onChain("5.000001")}, true},
+		{"went negative", Ledger{Closing: amount(t, "5"), Negative: 1}, true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.ledger.Mismatch(); got != tt.want {
+			t.Errorf("%s: Mismatch = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	ledgers := []Ledger{{Contract: usdt, Symbol: "USDT"}, {Contract: weth, Symbol: "WETH"}}
+
+	if l, ok := Find(ledgers, "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"); !ok || l.Symbol != "WETH" {
+		t.Errorf("Find(WETH) = %+v, %v", l, ok)
+	}
+	if _, ok := Find(ledgers, "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"); ok {
+		t.Errorf("Find found a ledger of a token without one")
+	}
+}
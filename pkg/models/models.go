@@ -3,7 +3,10 @@ package models
 import (
 	"encoding/json"
 	"strconv"
+	"strings"
 	"time"
+
+	"ethcrawler/pkg/decimal"
 )
 
 // EtherscanResponse represents the response from Etherscan API
@@ -15,12 +18,48 @@ type EtherscanResponse struct {
 
 // ERC20Transfer represents a single ERC20 token transfer
 type ERC20Transfer struct {
-	TimeStamp   string `json:"timeStamp"`
-	From        string `json:"from"`
-	To          string `json:"to"`
-	Value       string `json:"value"`
-	Hash        string `json:"hash"`
-	BlockNumber string `json:"blockNumber"`
+	TimeStamp        string `json:"timeStamp"`
+	From             string `json:"from"`
+	To               string `json:"to"`
+	Value            string `json:"value"`
+	Hash             string `json:"hash"`
+	BlockNumber      string `json:"blockNumber"`
+	LogIndex         string `json:"logIndex"`
+	TransactionIndex string `json:"transactionIndex"`
+	ContractAddress  string `json:"contractAddress"`
+	TokenName        string `json:"tokenName"`
+	TokenSymbol      string `json:"tokenSymbol"`
+	TokenDecimal     string `json:"tokenDecimal"`
+}
+
+// TransferKeys identifies transfers uniquely: a transaction may emit several
+// Transfer events, so the hash alone is not enough. Sources without log
+// indexes can report identical transfers in one transaction (split payouts),
+// those are told apart by their occurrence. Keys must therefore see the
+// transfers of a transaction in the order the source serves them, starting
+// with the first one.
+type TransferKeys struct {
+	occurrences map[string]int
+}
+
+// NewTransferKeys creates an empty key counter
+func NewTransferKeys() *TransferKeys {
+	return &TransferKeys{occurrences: make(map[string]int)}
+}
+
+// Key returns the key of the next transfer
+func (k *TransferKeys) Key(t ERC20Transfer) string {
+	if t.LogIndex != "" {
+		return t.Hash + ":" + t.LogIndex
+	}
+	key := t.Hash + ":" + t.ContractAddress + ":" + t.From + ":" + t.To + ":" + t.Value
+	k.occurrences[key]++
+	return key + "#" + strconv.Itoa(k.occurrences[key])
+}
+
+// Reset forgets the counted transfers, e.g. before a block is served again
+func (k *TransferKeys) Reset() {
+	clear(k.occurrences)
 }
 
 // FormattedTransfer adds a formatted timestamp for display
@@ -31,6 +70,88 @@ type FormattedTransfer struct {
 	Value     string
 	Hash      string
 	TimeStamp int64 // Original timestamp as int for sorting
+
+	BlockNumber int
+	LogIndex    int // Position within the block, negative if the source did not report it
+
+	Contract    string // Token contract address
+	TokenName   string
+	TokenSymbol string
+	Decimals    int // Value is in units of 10^-Decimals tokens
+
+	Direction Direction       // Relative to the queried address
+	Balance   decimal.Decimal // Balance of the queried address after the transfer
+}
+
+// Amount returns the exact value in token units. Values are validated by
+// FormatTransfers, an invalid one yields 0.
+func (t FormattedTransfer) Amount() decimal.Decimal {
+	amount, _ := decimal.Parse(t.Value, t.Decimals)
+	return amount
+}
+
+// SignedAmount returns the effect of the transfer on the balance of the
+// queried address: positive for incoming, negative for outgoing and zero for
+// transfers to itself
+func (t FormattedTransfer) SignedAmount() decimal.Decimal {
+	switch t.Direction {
+	case DirectionIn:
+		return t.Amount()
+	case DirectionOut:
+		return t.Amount().Neg()
+	}
+	return decimal.Decimal{}
+}
+
+// Direction tells whether a transfer was received or sent by an address
+type Direction string
+
+const (
+	DirectionIn   Direction = "in"
+	DirectionOut  Direction = "out"
+	DirectionSelf Direction = "self" // Sent by the address to itself
+)
+
+// DirectionOf classifies a transfer from one address to another relative to address
+func DirectionOf(from, to, address string) Direction {
+	sent := strings.EqualFold(from, address)
+	received := strings.EqualFold(to, address)
+	switch {
+	case sent && received:
+		return DirectionSelf
+	case sent:
+		return DirectionOut
+	}
+	return DirectionIn
+}
+
+// Matches reports whether a transfer in direction d passes a filter of
+// "in", "out" or "all". Transfers to itself are both incoming and outgoing.
+func (d Direction) Matches(filter string) bool {
+	switch filter {
+	case "", "all":
+		return true
+	case string(DirectionIn):
+		return d == DirectionIn || d == DirectionSelf
+	case string(DirectionOut):
+		return d == DirectionOut || d == DirectionSelf
+	}
+	return false
+}
+
+// Query describes which transfers a crawl should fetch
+type Query struct {
+	Address    string
+	Contract   string // Token contract, empty means all tokens
+	StartBlock int    // First block to fetch, 0 means from the beginning
+	EndBlock   int    // Last block to fetch, 0 means up to the latest block
+}
+
+// Progress reports how far a streaming crawl got
+type Progress struct {
+	Pages     int // Number of pages delivered to the caller
+	Transfers int // Number of transfers delivered to the caller
+	LastBlock int // Block number of the last delivered transfer
 }
 
 // TimeStampToDate converts Unix timestamp string to a formatted date string
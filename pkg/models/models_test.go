@@ -0,0 +1,30 @@
+package models
+
+import "testing"
+
+func TestTransferKeys(t *testing.T) {
+	payout := ERC20Transfer{Hash: "0xaa", ContractAddress: "0xc", From: "0x1", To: "0x2", Value: "100"}
+	other := ERC20Transfer{Hash: "0xaa", ContractAddress: "0xc", From: "0x1", To: "0x3", Value: "100"}
+	indexed := ERC20Transfer{Hash: "0xaa", LogIndex: "4", ContractAddress: "0xc", From: "0x1", To: "0x2", Value: "100"}
+
+	keys := NewTransferKeys()
+	got := []string{keys.Key(payout), keys.Key(other), keys.Key(payout), keys.Key(indexed), keys.Key(indexed)}
+	want := []string{
+		"0xaa:0xc:0x1:0x2:100#1",
+		"0xaa:0xc:0x1:0x3:100#1",
+		"0xaa:0xc:0x1:0x2:100#2",
+		"0xaa:4",
+		"0xaa:4",
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("key %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// Serving the transaction again yields the same keys
+	keys.Reset()
+	if key := keys.Key(payout); key != want[0] {
+		t.Errorf("key after Reset = %q, want %q", key, want[0])
+	}
+}
@@ -0,0 +1,487 @@
+package output
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Analytics sheets of single address workbooks
+const (
+	monthlySheet          = "Monthly"
+	topVolumeSheet        = "Counterparties by Volume"
+	topCountSheet         = "Counterparties by Count"
+	largestTransfersSheet = "Largest Transfers"
+)
+
+// analyticsTop is the number of counterparties and transfers ranked per token
+const analyticsTop = 50
+
+// Number formats of analytics sheets
+const (
+	amountFormat = "#,##0.00######"
+	countFormat  = "#,##0"
+	dateFormat   = "yyyy-mm-dd hh:mm:ss"
+	monthFormat  = "yyyy-mm"
+)
+
+// MonthlyFlow aggregates the transfers of a token in a calendar month
+type MonthlyFlow struct {
+	Month     time.Time // First day of the month in local time
+	Transfers int
+	Inflow    decimal.Decimal
+	Outflow   decimal.Decimal
+	Closing   decimal.Decimal // Balance after the last transfer of the month
+	First     int             // Index of the first transfer of the month
+}
+
+// Net returns the balance change over the month
+func (m MonthlyFlow) Net() decimal.Decimal {
+	return m.Inflow.Sub(m.Outflow)
+}
+
+// Counterparty aggregates the transfers between the address and another one
+type Counterparty struct {
+	Address   string
+	Transfers int
+	Received  decimal.Decimal // Sum of transfers from the counterparty
+	Sent      decimal.Decimal // Sum of transfers to the counterparty
+	FirstTime int64
+	LastTime  int64
+	First     int // Index of the first transfer with the counterparty
+}
+
+// Volume returns the amount moved in both directions
+func (c Counterparty) Volume() decimal.Decimal {
+	return c.Received.Add(c.Sent)
+}
+
+// Monthly aggregates the transfers of the group per month, oldest first
+func (g TokenGroup) Monthly() []MonthlyFlow {
+	return g.stats().monthly()
+}
+
+// Counterparties aggregates the transfers of the group per counterparty.
+// Transfers to the address itself have no counterparty and are skipped.
+func (g TokenGroup) Counterparties() []Counterparty {
+	return g.stats().parties
+}
+
+// stats aggregates the transfers of the group
+func (g TokenGroup) stats() *tokenStats {
+	s := newTokenStats(g)
+	for _, tx := range g.Transfers {
+		s.add(tx)
+	}
+	return s
+}
+
+// tokenStats aggregates the transfers of a token one at a time for the
+// summary and analytics sheets, without holding the transfers
+type tokenStats struct {
+	group   TokenGroup // The token, without its transfers
+	summary TokenSummary
+	months  []MonthlyFlow
+	parties []Counterparty
+	largest []indexedTransfer // Largest transfers first, at most analyticsTop
+
+	monthIndex map[time.Time]int
+	partyIndex map[string]int
+}
+
+// indexedTransfer is a transfer with its index among the transfers of its
+// token
+type indexedTransfer struct {
+	Index    int
+	Transfer models.FormattedTransfer
+}
+
+// newTokenStats starts the aggregates of the token of group
+func newTokenStats(group TokenGroup) *tokenStats {
+	group.Transfers = nil
+	return &tokenStats{
+		group:      group,
+		monthIndex: make(map[time.Time]int),
+		partyIndex: make(map[string]int),
+	}
+}
+
+// add aggregates the next transfer of the token
+func (s *tokenStats) add(tx models.FormattedTransfer) {
+	i := s.summary.Transfers
+	s.summary.add(tx)
+
+	t := time.Unix(tx.TimeStamp, 0)
+	month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local)
+	k, ok := s.monthIndex[month]
+	if !ok {
+		k = len(s.months)
+		s.monthIndex[month] = k
+		s.months = append(s.months, MonthlyFlow{Month: month, First: i})
+	}
+	m := &s.months[k]
+	m.Transfers++
+	if tx.Direction.Matches(string(models.DirectionIn)) {
+		m.Inflow = m.Inflow.Add(tx.Amount())
+	}
+	if tx.Direction.Matches(string(models.DirectionOut)) {
+		m.Outflow = m.Outflow.Add(tx.Amount())
+	}
+	m.Closing = tx.Balance
+
+	s.addCounterparty(i, tx)
+	s.rank(i, tx)
+}
+
+// addCounterparty aggregates the i-th transfer under its counterparty
+func (s *tokenStats) addCounterparty(i int, tx models.FormattedTransfer) {
+	var address string
+	switch tx.Direction {
+	case models.DirectionIn:
+		address = strings.ToLower(tx.From)
+	case models.DirectionOut:
+		address = strings.ToLower(tx.To)
+	default:
+		return
+	}
+
+	k, ok := s.partyIndex[address]
+	if !ok {
+		k = len(s.parties)
+		s.partyIndex[address] = k
+		s.parties = append(s.parties, Counterparty{Address: address, FirstTime: tx.TimeStamp, LastTime: tx.TimeStamp, First: i})
+	}
+
+	p := &s.parties[k]
+	p.Transfers++
+	if tx.Direction == models.DirectionIn {
+		p.Received = p.Received.Add(tx.Amount())
+	} else {
+		p.Sent = p.Sent.Add(tx.Amount())
+	}
+	if tx.TimeStamp < p.FirstTime {
+		p.FirstTime, p.First = tx.TimeStamp, i
+	}
+	if tx.TimeStamp > p.LastTime {
+		p.LastTime = tx.TimeStamp
+	}
+}
+
+// rank keeps the i-th transfer if it is among the analyticsTop largest so
+// far. Equal amounts keep the order of the transfers.
+func (s *tokenStats) rank(i int, tx models.FormattedTransfer) {
+	amount := tx.Amount()
+	k := sort.Search(len(s.largest), func(k int) bool {
+		return s.largest[k].Transfer.Amount().Cmp(amount) < 0
+	})
+	if k >= analyticsTop {
+		return
+	}
+	s.largest = slices.Insert(s.largest, k, indexedTransfer{Index: i, Transfer: tx})
+	if len(s.largest) > analyticsTop {
+		s.largest = s.largest[:analyticsTop]
+	}
+}
+
+// monthly returns the monthly aggregates, oldest first
+func (s *tokenStats) monthly() []MonthlyFlow {
+	months := append([]MonthlyFlow(nil), s.months...)
+	sort.SliceStable(months, func(i, j int) bool {
+		return months[i].Month.Before(months[j].Month)
+	})
+	return months
+}
+
+// tokenStatsOf reads rows once and aggregates them per token contract, in
+// the order of GroupByToken. Single token outputs have one group with the
+// symbol of meta, even without transfers.
+func tokenStatsOf(rows Rows, meta Meta) ([]*tokenStats, error) {
+	index := make(map[string]*tokenStats)
+	var stats []*tokenStats
+	err := rows(func(tx models.FormattedTransfer) error {
+		contract := strings.ToLower(tx.Contract)
+		if !meta.AllTokens {
+			contract = ""
+		}
+		s, ok := index[contract]
+		if !ok {
+			group := TokenGroup{Contract: contract, Symbol: tx.TokenSymbol, Name: tx.TokenName}
+			if !meta.AllTokens {
+				group = TokenGroup{Contract: tx.Contract, Symbol: meta.symbol()}
+			}
+			s = newTokenStats(group)
+			index[contract] = s
+			stats = append(stats, s)
+		}
+		s.add(tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !meta.AllTokens && len(stats) == 0 {
+		stats = append(stats, newTokenStats(TokenGroup{Symbol: meta.symbol()}))
+	}
+	sort.SliceStable(stats, func(i, j int) bool {
+		return groupLess(stats[i].group, stats[j].group)
+	})
+	return stats, nil
+}
+
+// topByVolume returns the n counterparties with the largest volume
+func topByVolume(parties []Counterparty, n int) []Counterparty {
+	ranked := append([]Counterparty(nil), parties...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if c := ranked[i].Volume().Cmp(ranked[j].Volume()); c != 0 {
+			return c > 0
+		}
+		if ranked[i].Transfers != ranked[j].Transfers {
+			return ranked[i].Transfers > ranked[j].Transfers
+		}
+		return ranked[i].Address < ranked[j].Address
+	})
+	return ranked[:min(n, len(ranked))]
+}
+
+// topByCount returns the n counterparties with the most transfers
+func topByCount(parties []Counterparty, n int) []Counterparty {
+	ranked := append([]Counterparty(nil), parties...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].Transfers != ranked[j].Transfers {
+			return ranked[i].Transfers > ranked[j].Transfers
+		}
+		if c := ranked[i].Volume().Cmp(ranked[j].Volume()); c != 0 {
+			return c > 0
+		}
+		return ranked[i].Address < ranked[j].Address
+	})
+	return ranked[:min(n, len(ranked))]
+}
+
+// counterpartyOf returns the other side of a transfer, the address itself for
+// transfers to itself
+func counterpartyOf(tx models.FormattedTransfer) string {
+	if tx.Direction == models.DirectionIn {
+		return tx.From
+	}
+	return tx.To
+}
+
+// rowLink returns the cell of the i-th transfer on the sheets written by
+// writeTransfersSheet
+func rowLink(sheets []string, i int) string {
+	perSheet := maxSheetRows - 1
+	return fmt.Sprintf("'%s'!A%d", sheets[i/perSheet], i%perSheet+2)
+}
+
+// excelTime returns the local wall clock time of a timestamp. Excel dates
+// have no time zone, the sheets show the same local times as the Date column.
+func excelTime(timestamp int64) time.Time {
+	t := time.Unix(timestamp, 0)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// analyticsStyles are the cell styles of analytics sheets
+type analyticsStyles struct {
+	header, amount, count, date, month, link int
+}
+
+// newAnalyticsStyles creates the number formats and the link style
+func newAnalyticsStyles(f *excelize.File, headerStyle int) (analyticsStyles, error) {
+	styles := analyticsStyles{header: headerStyle}
+	formats := []struct {
+		id    *int
+		style *excelize.Style
+	}{
+		{&styles.amount, &excelize.Style{CustomNumFmt: ptr(amountFormat)}},
+		{&styles.count, &excelize.Style{CustomNumFmt: ptr(countFormat)}},
+		{&styles.date, &excelize.Style{CustomNumFmt: ptr(dateFormat)}},
+		{&styles.month, &excelize.Style{CustomNumFmt: ptr(monthFormat)}},
+		{&styles.link, &excelize.Style{Font: &excelize.Font{Color: "#0563C1", Underline: "single"}}},
+	}
+	for _, format := range formats {
+		id, err := f.NewStyle(format.style)
+		if err != nil {
+			return styles, fmt.Errorf("error creating analytics style: %v", err)
+		}
+		*format.id = id
+	}
+	return styles, nil
+}
+
+// ptr returns a pointer to s
+func ptr(s string) *string {
+	return &s
+}
+
+// analyticsColumn is a column of an analytics sheet. Style is 0 for text.
+type analyticsColumn struct {
+	Title string
+	Width float64
+	Style int
+}
+
+// analyticsRow is a row of an analytics sheet with a link from its Link
+// column to a transfer on the transactions sheets
+type analyticsRow struct {
+	Cells []interface{}
+	Link  int
+	To    string
+}
+
+// writeAnalyticsSheets adds the monthly, counterparty and largest transfer
+// sheets after the existing ones. sheets holds the transactions sheets of
+// each token, rows link back to the transfers they are built from.
+func writeAnalyticsSheets(f *excelize.File, stats []*tokenStats, sheets [][]string, headerStyle int) error {
+	styles, err := newAnalyticsStyles(f, headerStyle)
+	if err != nil {
+		return err
+	}
+
+	var monthly, byVolume, byCount, largest []analyticsRow
+	for i, s := range stats {
+		token := s.group.Label()
+
+		for _, m := range s.monthly() {
+			monthly = append(monthly, analyticsRow{
+				Cells: []interface{}{token, m.Month, m.Transfers, m.Inflow, m.Outflow, m.Net(), m.Closing},
+				Link:  1,
+				To:    rowLink(sheets[i], m.First),
+			})
+		}
+
+		parties := s.parties
+		partyRows := func(ranked []Counterparty) []analyticsRow {
+			rows := make([]analyticsRow, len(ranked))
+			for rank, p := range ranked {
+				rows[rank] = analyticsRow{
+					Cells: []interface{}{rank + 1, token, p.Address, p.Transfers, p.Received, p.Sent, p.Volume(),
+						excelTime(p.FirstTime), excelTime(p.LastTime)},
+					Link: 2,
+					To:   rowLink(sheets[i], p.First),
+				}
+			}
+			return rows
+		}
+		byVolume = append(byVolume, partyRows(topByVolume(parties, analyticsTop))...)
+		byCount = append(byCount, partyRows(topByCount(parties, analyticsTop))...)
+
+		for rank, ranked := range s.largest {
+			tx := ranked.Transfer
+			largest = append(largest, analyticsRow{
+				Cells: []interface{}{rank + 1, token, excelTime(tx.TimeStamp), string(tx.Direction), counterpartyOf(tx),
+					signedAmount(tx), tx.Balance, tx.Hash},
+				Link: 7,
+				To:   rowLink(sheets[i], ranked.Index),
+			})
+		}
+	}
+
+	partyColumns := []analyticsColumn{
+		{"Rank", 8, styles.count}, {"Token", 15, 0}, {"Counterparty", 45, 0}, {"Transfers", 12, styles.count},
+		{"Received", 20, styles.amount}, {"Sent", 20, styles.amount}, {"Volume", 20, styles.amount},
+		{"First", 20, styles.date}, {"Last", 20, styles.date},
+	}
+	tables := []struct {
+		sheet   string
+		columns []analyticsColumn
+		rows    []analyticsRow
+	}{
+		{monthlySheet, []analyticsColumn{
+			{"Token", 15, 0}, {"Month", 12, styles.month}, {"Transfers", 12, styles.count},
+			{"Inflow", 20, styles.amount}, {"Outflow", 20, styles.amount}, {"Net", 20, styles.amount},
+			{"Closing Balance", 20, styles.amount},
+		}, monthly},
+		{topVolumeSheet, partyColumns, byVolume},
+		{topCountSheet, partyColumns, byCount},
+		{largestTransfersSheet, []analyticsColumn{
+			{"Rank", 8, styles.count}, {"Token", 15, 0}, {"Date", 20, styles.date}, {"Direction", 10, 0},
+			{"Counterparty", 45, 0}, {"Amount", 20, styles.amount}, {"Balance", 20, styles.amount}, {"Hash", 70, 0},
+		}, largest},
+	}
+	for _, table := range tables {
+		if err := writeAnalyticsSheet(f, table.sheet, table.columns, table.rows, styles); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAnalyticsSheet creates a sheet with a header row, the rows and their
+// links to the transactions sheets
+func writeAnalyticsSheet(f *excelize.File, sheet string, columns []analyticsColumn, rows []analyticsRow, styles analyticsStyles) error {
+	if _, err := f.NewSheet(sheet); err != nil {
+		return fmt.Errorf("error creating sheet: %v", err)
+	}
+
+	for i, column := range columns {
+		cell, err := excelize.CoordinatesToCellName(i+1, 1)
+		if err != nil {
+			return err
+		}
+		if err := f.SetCellValue(sheet, cell, column.Title); err != nil {
+			return fmt.Errorf("error setting header value: %v", err)
+		}
+		name, err := excelize.ColumnNumberToName(i + 1)
+		if err != nil {
+			return err
+		}
+		if err := f.SetColWidth(sheet, name, name, column.Width); err != nil {
+			return fmt.Errorf("error setting column width: %v", err)
+		}
+	}
+	lastCol, err := excelize.ColumnNumberToName(len(columns))
+	if err != nil {
+		return err
+	}
+	if err := f.SetCellStyle(sheet, "A1", lastCol+"1", styles.header); err != nil {
+		return fmt.Errorf("error applying header style: %v", err)
+	}
+
+	for r, row := range rows {
+		for i, value := range row.Cells {
+			cell, err := excelize.CoordinatesToCellName(i+1, r+2)
+			if err != nil {
+				return err
+			}
+			if err := setCell(f, sheet, cell, value); err != nil {
+				return fmt.Errorf("error setting cell value at %s: %v", cell, err)
+			}
+			style := columns[i].Style
+			if i == row.Link {
+				if err := f.SetCellHyperLink(sheet, cell, row.To, "Location"); err != nil {
+					return fmt.Errorf("error setting hyperlink at %s: %v", cell, err)
+				}
+				if style == 0 {
+					style = styles.link
+				}
+			}
+			if style != 0 {
+				if err := f.SetCellStyle(sheet, cell, cell, style); err != nil {
+					return fmt.Errorf("error applying style at %s: %v", cell, err)
+				}
+			}
+		}
+	}
+
+	if err := f.AutoFilter(sheet, "A1:"+lastCol+"1", []excelize.AutoFilterOptions{}); err != nil {
+		return fmt.Errorf("error adding filter: %v", err)
+	}
+	if err := f.SetPanes(sheet, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return fmt.Errorf("error freezing header row: %v", err)
+	}
+	return nil
+}
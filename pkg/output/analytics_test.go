@@ -0,0 +1,301 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	usdtContract = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	alice        = "0xaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	bob          = "0xbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// usdtAt builds a USDT transfer of value base units at a local time
+func usdtAt(date string, direction models.Direction, counterparty, value, hash string) models.FormattedTransfer {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", date, time.Local)
+	if err != nil {
+		panic(err)
+	}
+	tx := models.FormattedTransfer{
+		Date:        date,
+		From:        counterparty,
+		To:          testAddress,
+		Value:       value,
+		Hash:        hash,
+		TimeStamp:   t.Unix(),
+		BlockNumber: int(t.Unix() / 12),
+		Contract:    usdtContract,
+		TokenSymbol: "USDT",
+		Decimals:    6,
+		Direction:   direction,
+	}
+	switch direction {
+	case models.DirectionOut:
+		tx.From, tx.To = testAddress, counterparty
+	case models.DirectionSelf:
+		tx.From = testAddress
+	}
+	return tx
+}
+
+// saveAndOpen writes transfers with running balances to a workbook and
+// opens it
+func saveAndOpen(t *testing.T, transfers []models.FormattedTransfer, meta Meta) *excelize.File {
+	t.Helper()
+	meta.Ledgers = ledger.Build(transfers, nil)
+	filename := filepath.Join(t.TempDir(), "report.xlsx")
+	if err := SaveToExcelWithName(transfers, meta, filename); err != nil {
+		t.Fatalf("SaveToExcelWithName: %v", err)
+	}
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// sheetRows returns the rows of a sheet below its header with raw values
+func sheetRows(t *testing.T, f *excelize.File, sheet string) [][]string {
+	t.Helper()
+	rows, err := f.GetRows(sheet, excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatalf("GetRows(%s): %v", sheet, err)
+	}
+	if len(rows) == 0 {
+		t.Fatalf("sheet %s has no header", sheet)
+	}
+	return rows[1:]
+}
+
+// linkedRow follows the link of a cell to a transactions sheet and returns
+// the row it points to
+func linkedRow(t *testing.T, f *excelize.File, sheet, cell string) []string {
+	t.Helper()
+	ok, target, err := f.GetCellHyperLink(sheet, cell)
+	if err != nil || !ok {
+		t.Fatalf("%s!%s has no link (%v)", sheet, cell, err)
+	}
+	name, ref, found := strings.Cut(target, "!")
+	if !found {
+		t.Fatalf("%s!%s links to %s, want a cell of another sheet", sheet, cell, target)
+	}
+	_, row, err := excelize.CellNameToCoordinates(ref)
+	if err != nil {
+		t.Fatalf("link target %s: %v", target, err)
+	}
+	rows, err := f.GetRows(strings.Trim(name, "'"))
+	if err != nil || row > len(rows) {
+		t.Fatalf("link target %s is not a row (%v)", target, err)
+	}
+	return rows[row-1]
+}
+
+// dateCell checks that a cell holds a date shown as the local time of date
+func dateCell(t *testing.T, f *excelize.File, sheet, cell, date string) {
+	t.Helper()
+	raw, err := f.GetCellValue(sheet, cell, excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatalf("GetCellValue(%s!%s): %v", sheet, cell, err)
+	}
+	if _, err := strconv.ParseFloat(raw, 64); err != nil {
+		t.Errorf("%s!%s = %q, want a date serial", sheet, cell, raw)
+	}
+	if shown, _ := f.GetCellValue(sheet, cell); shown != date {
+		t.Errorf("%s!%s shows %s, want %s", sheet, cell, shown, date)
+	}
+}
+
+func TestAnalyticsSheets(t *testing.T) {
+	transfers := []models.FormattedTransfer{
+		usdtAt("2024-01-05 10:00:00", models.DirectionIn, alice, "1000000", "0x01"),  // +1
+		usdtAt("2024-01-20 12:30:00", models.DirectionIn, bob, "500000000", "0x02"),  // +500
+		usdtAt("2024-01-31 23:00:00", models.DirectionOut, alice, "2000000", "0x03"), // -2
+		usdtAt("2024-02-02 08:00:00", models.DirectionSelf, "", "7000000", "0x04"),   // self
+		usdtAt("2024-02-10 09:15:00", models.DirectionIn, alice, "3000000", "0x05"),  // +3
+		usdtAt("2024-03-01 00:00:01", models.DirectionOut, bob, "100000000", "0x06"), // -100
+		usdtAt("2024-03-15 18:45:00", models.DirectionIn, alice, "250000", "0x07"),   // +0.25
+	}
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "USDT", Contract: usdtContract}
+	f := saveAndOpen(t, transfers, meta)
+	sheet := SheetName(meta)
+
+	t.Run("monthly", func(t *testing.T) {
+		rows := sheetRows(t, f, monthlySheet)
+		want := [][]string{
+			// Token, transfers, inflow, outflow, net, closing balance
+			{"USDT", "3", "501", "2", "499", "499"},
+			{"USDT", "2", "10", "7", "3", "502"},
+			{"USDT", "2", "0.25", "100", "-99.75", "402.25"},
+		}
+		if len(rows) != len(want) {
+			t.Fatalf("got %d months, want %d", len(rows), len(want))
+		}
+		firstHashes := []string{"0x01", "0x04", "0x06"}
+		for i, w := range want {
+			got := append([]string{rows[i][0]}, rows[i][2:7]...)
+			if strings.Join(got, " ") != strings.Join(w, " ") {
+				t.Errorf("month %d = %v, want %v", i+1, got, w)
+			}
+			// The month links to its first transfer
+			if target := linkedRow(t, f, monthlySheet, fmt.Sprintf("B%d", i+2)); target[7] != firstHashes[i] {
+				t.Errorf("month %d links to %s, want %s", i+1, target[7], firstHashes[i])
+			}
+		}
+		if got, _ := f.GetCellValue(monthlySheet, "B3"); got != "2024-02" {
+			t.Errorf("second month = %s, want 2024-02", got)
+		}
+	})
+
+	t.Run("counterparties", func(t *testing.T) {
+		tests := []struct {
+			sheet string
+			want  [][]string // Rank, counterparty, transfers, received, sent, volume
+		}{
+			{topVolumeSheet, [][]string{
+				{"1", bob, "2", "500", "100", "600"},
+				{"2", alice, "4", "4.25", "2", "6.25"},
+			}},
+			{topCountSheet, [][]string{
+				{"1", alice, "4", "4.25", "2", "6.25"},
+				{"2", bob, "2", "500", "100", "600"},
+			}},
+		}
+		for _, tt := range tests {
+			rows := sheetRows(t, f, tt.sheet)
+			if len(rows) != len(tt.want) {
+				t.Fatalf("%s has %d rows, want %d", tt.sheet, len(rows), len(tt.want))
+			}
+			for i, w := range tt.want {
+				got := append([]string{rows[i][0]}, rows[i][2:7]...)
+				if strings.Join(got, " ") != strings.Join(w, " ") {
+					t.Errorf("%s row %d = %v, want %v", tt.sheet, i+1, got, w)
+				}
+				// The counterparty links to its first transfer
+				target := linkedRow(t, f, tt.sheet, fmt.Sprintf("C%d", i+2))
+				if !strings.EqualFold(target[2], w[1]) && !strings.EqualFold(target[3], w[1]) {
+					t.Errorf("%s row %d links to a transfer between %s and %s", tt.sheet, i+1, target[2], target[3])
+				}
+			}
+		}
+
+		// First and last transfers with bob
+		dateCell(t, f, topVolumeSheet, "H2", "2024-01-20 12:30:00")
+		dateCell(t, f, topVolumeSheet, "I2", "2024-03-01 00:00:01")
+	})
+
+	t.Run("largest transfers", func(t *testing.T) {
+		rows := sheetRows(t, f, largestTransfersSheet)
+		wantHashes := []string{"0x02", "0x06", "0x04", "0x05", "0x03", "0x01", "0x07"}
+		wantAmounts := []string{"500", "-100", "7", "3", "-2", "1", "0.25"}
+		if len(rows) != len(wantHashes) {
+			t.Fatalf("got %d largest transfers, want %d", len(rows), len(wantHashes))
+		}
+		for i, row := range rows {
+			if row[0] != strconv.Itoa(i+1) || row[5] != wantAmounts[i] || row[7] != wantHashes[i] {
+				t.Errorf("rank %d = %v, want %s of %s", i+1, row, wantAmounts[i], wantHashes[i])
+			}
+			if target := linkedRow(t, f, largestTransfersSheet, fmt.Sprintf("H%d", i+2)); target[7] != row[7] {
+				t.Errorf("rank %d links to %s, want %s", i+1, target[7], row[7])
+			}
+		}
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		rows := sheetRows(t, f, summarySheet)
+		if len(rows) != 1 {
+			t.Fatalf("got %d summary rows, want 1", len(rows))
+		}
+		row := rows[0]
+		// The transfer to itself counts on both sides
+		if row[3] != "7" || row[4] != "511.25" || row[5] != "109" || row[6] != "402.25" {
+			t.Errorf("summary = %v, want 7 transfers, 511.25 in, 109 out and 402.25 net", row)
+		}
+
+		// First and last are date cells shown like the Date column
+		dateCell(t, f, summarySheet, "H2", "2024-01-05 10:00:00")
+		dateCell(t, f, summarySheet, "I2", "2024-03-15 18:45:00")
+		if target := linkedRow(t, f, summarySheet, "A2"); target[0] != "Date" {
+			t.Errorf("summary links to %v, want the header of %s", target, sheet)
+		}
+	})
+}
+
+func TestAnalyticsTop(t *testing.T) {
+	// Three transactions sheets for the first token
+	defer func(n int) { maxSheetRows = n }(maxSheetRows)
+	maxSheetRows = 26
+
+	// 60 counterparties send 1 to 60 USDT, a second token has two transfers
+	var transfers []models.FormattedTransfer
+	for i := 1; i <= 60; i++ {
+		tx := usdtAt("2024-01-01 00:00:00", models.DirectionIn, fmt.Sprintf("0x%040x", i), strconv.Itoa(i*1000000), fmt.Sprintf("0x%04d", i))
+		tx.TimeStamp += int64(i)
+		tx.BlockNumber += i
+		transfers = append(transfers, tx)
+	}
+	for i, value := range []string{"5", "9"} {
+		tx := usdtAt("2024-02-01 00:00:00", models.DirectionIn, alice, value, fmt.Sprintf("0xdai%d", i))
+		tx.Contract, tx.TokenSymbol, tx.Decimals = "0x6b175474e89094c44da98b954eedeac495271d0f", "DAI", 0
+		tx.BlockNumber += 100 + i
+		transfers = append(transfers, tx)
+	}
+	f := saveAndOpen(t, transfers, Meta{Address: testAddress, Chain: chains.Ethereum, AllTokens: true})
+
+	tests := []struct {
+		sheet  string
+		rows   int
+		link   string // Column of the link
+		amount int    // Index of the volume or amount
+	}{
+		{topVolumeSheet, analyticsTop + 1, "C", 6},
+		{topCountSheet, analyticsTop + 1, "C", 6},
+		{largestTransfersSheet, analyticsTop + 2, "H", 5},
+	}
+	for _, tt := range tests {
+		rows := sheetRows(t, f, tt.sheet)
+		if len(rows) != tt.rows {
+			t.Errorf("%s has %d rows, want %d", tt.sheet, len(rows), tt.rows)
+			continue
+		}
+
+		// Tokens are ordered by symbol, each ranked on its own: DAI first,
+		// then the 50 largest of the 60 USDT transfers
+		dai := tt.rows - analyticsTop
+		for i, row := range rows {
+			token, rank := "DAI", i+1
+			if i >= dai {
+				token, rank = "USDT", i-dai+1
+			}
+			if row[0] != strconv.Itoa(rank) || row[1] != token {
+				t.Errorf("%s row %d = rank %s of %s, want rank %d of %s", tt.sheet, i+1, row[0], row[1], rank, token)
+			}
+
+			// Every link points to a transfer of the row, across the three
+			// USDT sheets
+			target := linkedRow(t, f, tt.sheet, fmt.Sprintf("%s%d", tt.link, i+2))
+			if tt.link == "H" {
+				if target[7] != row[7] {
+					t.Errorf("%s row %d links to %s, want %s", tt.sheet, i+1, target[7], row[7])
+				}
+			} else if !strings.EqualFold(target[2], row[2]) {
+				t.Errorf("%s row %d links to a transfer from %s, want %s", tt.sheet, i+1, target[2], row[2])
+			}
+		}
+		// The transfers of 1 to 10 USDT fall outside the top
+		if last := rows[len(rows)-1]; last[tt.amount] != "11" {
+			t.Errorf("%s ends with %v, want 11 USDT", tt.sheet, last)
+		}
+	}
+}
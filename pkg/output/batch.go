@@ -0,0 +1,208 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// addressesSheet is the name of the batch overview sheet
+const addressesSheet = "Addresses"
+
+// AddressResult holds the outcome of one address of a batch
+type AddressResult struct {
+	Address     string
+	Label       string                     // Optional name of the address from the address list
+	Count       int                        // Number of transfers
+	Transfers   []models.FormattedTransfer // Kept only for the combined workbook
+	Ledgers     []ledger.Ledger
+	Interrupted bool  // The download stopped early, transfers are incomplete
+	Err         error // The address failed, it has no transfers
+}
+
+// Status returns OK, PARTIAL for interrupted downloads or FAILED
+func (r AddressResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return "FAILED"
+	case r.Interrupted:
+		return "PARTIAL"
+	}
+	return "OK"
+}
+
+// sheetLabel returns the label of the address, or its shortened form
+func (r AddressResult) sheetLabel() string {
+	if label := strings.TrimSpace(r.Label); label != "" {
+		return label
+	}
+	if len(r.Address) > 10 {
+		return r.Address[:10]
+	}
+	return r.Address
+}
+
+// BatchFileName returns the name of the combined workbook of a batch
+func BatchFileName(meta Meta) string {
+	meta.Address = "batch"
+	return GenerateFileName(meta, "xlsx")
+}
+
+// SaveBatchExcel writes the combined workbook of a batch: an overview of all
+// addresses followed by one transactions sheet per address. The address and
+// the chain of meta are ignored.
+func SaveBatchExcel(results []AddressResult, meta Meta) (filename string, err error) {
+	filename = BatchFileName(meta)
+	fmt.Fprintf(meta.log(), "Creating combined Excel file for %d addresses...\n", len(results))
+
+	f := excelize.NewFile()
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error closing Excel file: %v", closeErr)
+		}
+	}()
+
+	headerStyle, err := newHeaderStyle(f)
+	if err != nil {
+		return "", err
+	}
+
+	// The overview takes over the default Sheet1 before any sheet is
+	// streamed: deleting Sheet1 afterwards would make excelize read the
+	// streamed sheets back into memory
+	if err := f.SetSheetName("Sheet1", addressesSheet); err != nil {
+		return "", fmt.Errorf("error creating sheet: %v", err)
+	}
+
+	// Mixed tokens share a sheet with a token column
+	symbol := ""
+	if !meta.AllTokens {
+		symbol = meta.symbol()
+	}
+
+	sheets := make([]string, len(results))
+	used := map[string]bool{strings.ToLower(addressesSheet): true}
+	for i, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		sheets[i] = uniqueSheetName(result.sheetLabel(), used)
+		if _, err := f.NewSheet(sheets[i]); err != nil {
+			return "", fmt.Errorf("error creating sheet: %v", err)
+		}
+		addressMeta := meta
+		addressMeta.Address = result.Address
+		addressMeta.Ledgers = result.Ledgers
+		if _, err := writeTransfersSheet(f, sheets[i], symbol, result.Transfers, addressMeta, headerStyle, used); err != nil {
+			return "", err
+		}
+	}
+
+	if err := writeAddressesSheet(f, results, sheets, meta, headerStyle); err != nil {
+		return "", err
+	}
+
+	f.SetActiveSheet(0)
+
+	fmt.Fprintln(meta.log(), "Saving Excel file...")
+	if err := f.SaveAs(filename); err != nil {
+		return "", fmt.Errorf("error saving Excel file: %v", err)
+	}
+
+	return filename, nil
+}
+
+// writeAddressesSheet fills the overview sheet with one row per address.
+// Totals are only shown for single token batches, amounts of different
+// tokens don't add up.
+func writeAddressesSheet(f *excelize.File, results []AddressResult, sheets []string, meta Meta, headerStyle int) error {
+	failedStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "#C00000"},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating failure style: %v", err)
+	}
+
+	headers := []string{"Label", "Address", "Status", "Transfers"}
+	columnWidths := []float64{20, 45, 10, 12}
+	if !meta.AllTokens {
+		headers = append(headers, "Inflow", "Outflow", "Net", "Closing Balance", "Balance Check")
+		columnWidths = append(columnWidths, 20, 20, 20, 20, 15)
+	}
+	headers = append(headers, "Error")
+	columnWidths = append(columnWidths, 60)
+
+	for i, header := range headers {
+		cell := fmt.Sprintf("%c1", 'A'+i)
+		if err := f.SetCellValue(addressesSheet, cell, header); err != nil {
+			return fmt.Errorf("error setting header value: %v", err)
+		}
+	}
+	lastCol := string(rune('A' + len(headers) - 1))
+	if err := f.SetCellStyle(addressesSheet, "A1", lastCol+"1", headerStyle); err != nil {
+		return fmt.Errorf("error applying header style: %v", err)
+	}
+
+	for i, result := range results {
+		row := i + 2
+		cells := []interface{}{result.Label, result.Address, result.Status(), result.Count}
+		if !meta.AllTokens {
+			summary := TokenGroup{Transfers: result.Transfers}.Summary()
+			cells = append(cells, summary.Inflow, summary.Outflow, summary.Net())
+			if len(result.Ledgers) == 1 {
+				cells = append(cells, result.Ledgers[0].Closing, balanceStatus(result.Ledgers[0]))
+			} else {
+				cells = append(cells, nil, nil)
+			}
+		}
+		errText := ""
+		if result.Err != nil {
+			errText = result.Err.Error()
+		}
+		cells = append(cells, errText)
+
+		for k, value := range cells {
+			cell := fmt.Sprintf("%c%d", 'A'+k, row)
+			if err := setCell(f, addressesSheet, cell, value); err != nil {
+				return fmt.Errorf("error setting cell value at %s: %v", cell, err)
+			}
+		}
+
+		cell := fmt.Sprintf("C%d", row)
+		if result.Status() != "OK" {
+			if err := f.SetCellStyle(addressesSheet, cell, cell, failedStyle); err != nil {
+				return fmt.Errorf("error applying failure style: %v", err)
+			}
+		}
+
+		// Link the address to its sheet
+		if sheets[i] != "" {
+			cell = fmt.Sprintf("B%d", row)
+			if err := f.SetCellHyperLink(addressesSheet, cell, fmt.Sprintf("'%s'!A1", sheets[i]), "Location"); err != nil {
+				return fmt.Errorf("error setting hyperlink at %s: %v", cell, err)
+			}
+		}
+	}
+
+	for i, width := range columnWidths {
+		colName := string(rune('A' + i))
+		if err := f.SetColWidth(addressesSheet, colName, colName, width); err != nil {
+			return fmt.Errorf("error setting column width: %v", err)
+		}
+	}
+
+	if err := f.SetPanes(addressesSheet, &excelize.Panes{
+		Freeze:      true,
+		YSplit:      1,
+		TopLeftCell: "A2",
+		ActivePane:  "bottomLeft",
+	}); err != nil {
+		return fmt.Errorf("error freezing header row: %v", err)
+	}
+
+	return nil
+}
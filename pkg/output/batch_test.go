@@ -0,0 +1,55 @@
+package output
+
+import (
+	"os"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func TestSaveBatchExcel(t *testing.T) {
+	// The workbook is written to the working directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	transfers, ledgers := wethTransfers()
+	results := []AddressResult{
+		{Address: testAddress, Label: "Sheet1", Count: len(transfers), Transfers: transfers, Ledgers: ledgers},
+		{Address: otherAddress, Err: os.ErrNotExist},
+	}
+	meta := Meta{Chain: chains.Ethereum, Token: "WETH", Contract: testContract}
+	filename, err := SaveBatchExcel(results, meta)
+	if err != nil {
+		t.Fatalf("SaveBatchExcel: %v", err)
+	}
+
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	// The overview took over the default sheet, so a label can use its name
+	sheets := f.GetSheetList()
+	if len(sheets) != 2 || sheets[0] != addressesSheet || sheets[1] != "Sheet1" {
+		t.Fatalf("sheets = %v, want %s and the sheet of the labelled address", sheets, addressesSheet)
+	}
+	rows, err := f.GetRows("Sheet1")
+	if err != nil {
+		t.Fatalf("GetRows: %v", err)
+	}
+	if len(rows) != len(transfers)+1 {
+		t.Errorf("address sheet has %d rows, want a header and %d transfers", len(rows), len(transfers))
+	}
+	if status, _ := f.GetCellValue(addressesSheet, "C3"); status != "FAILED" {
+		t.Errorf("status of the failed address = %q, want FAILED", status)
+	}
+}
@@ -0,0 +1,177 @@
+package output
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/models"
+)
+
+// Columns of delimited outputs
+const (
+	ColumnBlock     = "block"     // Block number
+	ColumnTime      = "time"      // ISO 8601 time in UTC
+	ColumnTimestamp = "timestamp" // Unix time in seconds
+	ColumnFrom      = "from"
+	ColumnTo        = "to"
+	ColumnDirection = "direction" // in, out or self
+	ColumnValue     = "value"     // Raw value in base units
+	ColumnAmount    = "amount"    // Value in token units
+	ColumnToken     = "token"     // Token symbol
+	ColumnContract  = "contract"  // Token contract
+	ColumnBalance   = "balance"   // Reconstructed balance after the transfer
+	ColumnHash      = "hash"
+	ColumnLogIndex  = "log_index" // Empty if the source did not report it
+)
+
+// AllColumns lists every column of delimited outputs
+var AllColumns = []string{
+	ColumnBlock, ColumnTime, ColumnTimestamp, ColumnFrom, ColumnTo, ColumnDirection,
+	ColumnValue, ColumnAmount, ColumnToken, ColumnContract, ColumnBalance, ColumnHash, ColumnLogIndex,
+}
+
+// DefaultColumns are written when no columns are chosen
+var DefaultColumns = []string{
+	ColumnBlock, ColumnTime, ColumnTimestamp, ColumnFrom, ColumnTo, ColumnDirection,
+	ColumnValue, ColumnAmount, ColumnToken, ColumnHash, ColumnLogIndex,
+}
+
+// ParseColumns parses a comma separated list of columns. An empty list means
+// the default columns.
+func ParseColumns(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return DefaultColumns, nil
+	}
+
+	var columns []string
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !isColumn(name) {
+			return nil, fmt.Errorf("unknown column %q, use %s", name, strings.Join(AllColumns, ", "))
+		}
+		columns = append(columns, name)
+	}
+	return columns, nil
+}
+
+// isColumn reports whether name is a known column
+func isColumn(name string) bool {
+	for _, column := range AllColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DelimitedWriter streams transfers as CSV or TSV rows
+type DelimitedWriter struct {
+	buf     *bufio.Writer
+	csv     *csv.Writer
+	columns []string
+	row     []string
+}
+
+// NewDelimitedWriter writes the header row to w. The comma separates fields,
+// e.g. ',' for CSV or '\t' for TSV.
+func NewDelimitedWriter(w io.Writer, comma rune, columns []string) (*DelimitedWriter, error) {
+	buf := bufio.NewWriter(w)
+	dw := &DelimitedWriter{
+		buf:     buf,
+		csv:     csv.NewWriter(buf),
+		columns: columns,
+		row:     make([]string, len(columns)),
+	}
+	dw.csv.Comma = comma
+
+	if err := dw.csv.Write(columns); err != nil {
+		return nil, fmt.Errorf("error writing header: %v", err)
+	}
+	return dw, nil
+}
+
+// Write appends a transfer
+func (w *DelimitedWriter) Write(tx models.FormattedTransfer) error {
+	for i, column := range w.columns {
+		w.row[i] = columnValue(tx, column)
+	}
+	if err := w.csv.Write(w.row); err != nil {
+		return fmt.Errorf("error writing row: %v", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered rows to the underlying writer
+func (w *DelimitedWriter) Flush() error {
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		return fmt.Errorf("error writing rows: %v", err)
+	}
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("error writing rows: %v", err)
+	}
+	return nil
+}
+
+// columnValue formats a column of a transfer
+func columnValue(tx models.FormattedTransfer, column string) string {
+	switch column {
+	case ColumnBlock:
+		return strconv.Itoa(tx.BlockNumber)
+	case ColumnTime:
+		return time.Unix(tx.TimeStamp, 0).UTC().Format(time.RFC3339)
+	case ColumnTimestamp:
+		return strconv.FormatInt(tx.TimeStamp, 10)
+	case ColumnFrom:
+		return tx.From
+	case ColumnTo:
+		return tx.To
+	case ColumnDirection:
+		return string(tx.Direction)
+	case ColumnValue:
+		return tx.Value
+	case ColumnAmount:
+		return tx.Amount().String()
+	case ColumnToken:
+		return tx.TokenSymbol
+	case ColumnContract:
+		return tx.Contract
+	case ColumnBalance:
+		return tx.Balance.String()
+	case ColumnHash:
+		return tx.Hash
+	case ColumnLogIndex:
+		if tx.LogIndex < 0 {
+			return ""
+		}
+		return strconv.Itoa(tx.LogIndex)
+	}
+	return ""
+}
+
+// WriteCSV writes the transfers of rows as comma separated rows to w
+func WriteCSV(w io.Writer, rows Rows, columns []string) error {
+	return writeDelimited(w, rows, ',', columns)
+}
+
+// WriteTSV writes the transfers of rows as tab separated rows to w
+func WriteTSV(w io.Writer, rows Rows, columns []string) error {
+	return writeDelimited(w, rows, '\t', columns)
+}
+
+// writeDelimited streams the transfers of rows to w one row at a time
+func writeDelimited(w io.Writer, rows Rows, comma rune, columns []string) error {
+	dw, err := NewDelimitedWriter(w, comma, columns)
+	if err != nil {
+		return err
+	}
+	if err := rows(dw.Write); err != nil {
+		return err
+	}
+	return dw.Flush()
+}
@@ -0,0 +1,117 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/models"
+)
+
+// readDelimited parses the output of a delimited writer
+func readDelimited(t *testing.T, data []byte, comma rune) [][]string {
+	t.Helper()
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = comma
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("reading output: %v\n%s", err, data)
+	}
+	return records
+}
+
+func TestDelimitedColumns(t *testing.T) {
+	transfers, _ := wethTransfers()
+	transfers[2].LogIndex = -1 // Not reported by the source
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, SliceRows(transfers), DefaultColumns); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	records := readDelimited(t, buf.Bytes(), ',')
+	if got := strings.Join(records[0], ","); got != "block,time,timestamp,from,to,direction,value,amount,token,hash,log_index" {
+		t.Errorf("default header %s", got)
+	}
+	want := []string{"102", "2024-01-01T00:00:00Z", "1704067200", testAddress, otherAddress, "out", "2", "0.000000000000000002", "WETH", "0xaa", ""}
+	if got := records[3]; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("row 3 = %v, want %v", got, want)
+	}
+
+	// Chosen columns are written in the given order, including the ones left
+	// out by default
+	columns, err := ParseColumns(" Contract, balance,block ")
+	if err != nil {
+		t.Fatalf("ParseColumns: %v", err)
+	}
+	buf.Reset()
+	if err := WriteTSV(&buf, SliceRows(transfers), columns); err != nil {
+		t.Fatalf("WriteTSV: %v", err)
+	}
+	records = readDelimited(t, buf.Bytes(), '\t')
+	if len(records) != len(transfers)+1 {
+		t.Fatalf("got %d TSV records, want a header and %d rows", len(records), len(transfers))
+	}
+	if got := strings.Join(records[0], ","); got != "contract,balance,block" {
+		t.Errorf("header %s, want contract,balance,block", got)
+	}
+	if got := strings.Join(records[1], ","); got != testContract+",0.000000000000000001,100" {
+		t.Errorf("row 1 = %v", records[1])
+	}
+
+	if _, err := ParseColumns("block,label"); err == nil || !strings.Contains(err.Error(), `"label"`) {
+		t.Errorf("ParseColumns with an unknown column = %v", err)
+	}
+}
+
+func TestDelimitedQuoting(t *testing.T) {
+	tx := models.FormattedTransfer{
+		TokenSymbol: `Fake "USD", Inc`,
+		Contract:    "tab\tseparated",
+		Hash:        "line\nbreak",
+		Value:       "1",
+		Decimals:    0,
+	}
+	columns := []string{ColumnToken, ColumnContract, ColumnHash, ColumnAmount}
+
+	for _, tt := range []struct {
+		name  string
+		comma rune
+		write func(io.Writer, Rows, []string) error
+	}{
+		{"csv", ',', WriteCSV},
+		{"tsv", '\t', WriteTSV},
+	} {
+		var buf bytes.Buffer
+		if err := tt.write(&buf, SliceRows([]models.FormattedTransfer{tx}), columns); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		records := readDelimited(t, buf.Bytes(), tt.comma)
+		if len(records) != 2 {
+			t.Fatalf("%s: got %d records, want 2", tt.name, len(records))
+		}
+		want := []string{tx.TokenSymbol, tx.Contract, tx.Hash, "1"}
+		for i := range want {
+			if records[1][i] != want[i] {
+				t.Errorf("%s: field %d = %q, want %q", tt.name, i, records[1][i], want[i])
+			}
+		}
+	}
+}
+
+func TestDelimitedRowsError(t *testing.T) {
+	failed := errors.New("store unreadable")
+	rows := func(fn func(models.FormattedTransfer) error) error {
+		if err := fn(models.FormattedTransfer{Value: "1"}); err != nil {
+			return err
+		}
+		return failed
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, rows, DefaultColumns); !errors.Is(err, failed) {
+		t.Errorf("WriteCSV = %v, want the error of the rows", err)
+	}
+}
@@ -0,0 +1,194 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/models"
+)
+
+// JSONSchema names the JSON and NDJSON formats. JSONSchemaVersion changes
+// only when fields are renamed or removed, new fields keep the version.
+const (
+	JSONSchema        = "ethcrawler.transfers"
+	JSONSchemaVersion = 1
+)
+
+// JSONTransfer is a transfer in JSON outputs. Amounts are strings to keep
+// their exact value.
+type JSONTransfer struct {
+	Block     int    `json:"block"`
+	LogIndex  *int   `json:"logIndex"`  // Null if the source did not report it
+	Time      string `json:"time"`      // ISO 8601 in UTC
+	Timestamp int64  `json:"timestamp"` // Unix seconds
+	Hash      string `json:"hash"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Direction string `json:"direction"`
+	Contract  string `json:"contract"`
+	Token     string `json:"token"`
+	Decimals  int    `json:"decimals"`
+	Value     string `json:"value"`   // Base units
+	Amount    string `json:"amount"`  // Token units
+	Balance   string `json:"balance"` // Reconstructed balance after the transfer
+}
+
+// JSONChain identifies the chain of a JSON document
+type JSONChain struct {
+	Name    string `json:"name"`
+	ChainID int    `json:"chainId,omitempty"`
+}
+
+// JSONRange is the requested range, missing bounds are open
+type JSONRange struct {
+	FromBlock int    `json:"fromBlock,omitempty"`
+	ToBlock   int    `json:"toBlock,omitempty"`
+	FromTime  string `json:"fromTime,omitempty"`
+	ToTime    string `json:"toTime,omitempty"`
+}
+
+// JSONBalance is the reconstructed balance of a token
+type JSONBalance struct {
+	Contract string  `json:"contract"`
+	Token    string  `json:"token"`
+	Opening  string  `json:"opening"`
+	Closing  string  `json:"closing"`
+	OnChain  *string `json:"onChain,omitempty"`
+	Mismatch bool    `json:"mismatch"`
+}
+
+// JSONDocument is the json output: metadata followed by the transfers
+type JSONDocument struct {
+	Schema      string         `json:"schema"`
+	Version     int            `json:"version"`
+	GeneratedAt string         `json:"generatedAt"`
+	Address     string         `json:"address"`
+	Chain       JSONChain      `json:"chain"`
+	Contract    string         `json:"contract,omitempty"` // Empty for all tokens
+	Token       string         `json:"token,omitempty"`
+	Range       JSONRange      `json:"range"`
+	Count       int            `json:"count"`
+	Balances    []JSONBalance  `json:"balances"`
+	Transfers   []JSONTransfer `json:"transfers"`
+}
+
+// NDJSONRecord is a line of ndjson outputs: a transfer with enough context
+// to be read on its own
+type NDJSONRecord struct {
+	Version int    `json:"version"`
+	Chain   string `json:"chain"`
+	Address string `json:"address"`
+	JSONTransfer
+}
+
+// NewJSONTransfer converts a formatted transfer
+func NewJSONTransfer(tx models.FormattedTransfer) JSONTransfer {
+	jt := JSONTransfer{
+		Block:     tx.BlockNumber,
+		Time:      time.Unix(tx.TimeStamp, 0).UTC().Format(time.RFC3339),
+		Timestamp: tx.TimeStamp,
+		Hash:      tx.Hash,
+		From:      tx.From,
+		To:        tx.To,
+		Direction: string(tx.Direction),
+		Contract:  tx.Contract,
+		Token:     tx.TokenSymbol,
+		Decimals:  tx.Decimals,
+		Value:     tx.Value,
+		Amount:    tx.Amount().String(),
+		Balance:   tx.Balance.String(),
+	}
+	// Negative log indexes only tell apart the transfers of a transaction
+	if tx.LogIndex >= 0 {
+		logIndex := tx.LogIndex
+		jt.LogIndex = &logIndex
+	}
+	return jt
+}
+
+// WriteJSON writes transfers as a single JSON document to w
+func WriteJSON(w io.Writer, transfers []models.FormattedTransfer, meta Meta) error {
+	doc := JSONDocument{
+		Schema:      JSONSchema,
+		Version:     JSONSchemaVersion,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Address:     strings.ToLower(meta.Address),
+		Chain:       JSONChain{Name: meta.Chain.Name, ChainID: meta.Chain.ChainID},
+		Range: JSONRange{
+			FromBlock: meta.FromBlock,
+			ToBlock:   meta.ToBlock,
+			FromTime:  jsonTime(meta.Since),
+			ToTime:    jsonTime(meta.Until),
+		},
+		Count:     len(transfers),
+		Balances:  make([]JSONBalance, 0, len(meta.Ledgers)),
+		Transfers: make([]JSONTransfer, 0, len(transfers)),
+	}
+	if !meta.AllTokens {
+		doc.Contract = strings.ToLower(meta.Contract)
+		doc.Token = meta.symbol()
+	}
+	for _, l := range meta.Ledgers {
+		balance := JSONBalance{
+			Contract: l.Contract,
+			Token:    l.Symbol,
+			Opening:  l.Opening.String(),
+			Closing:  l.Closing.String(),
+			Mismatch: l.Mismatch(),
+		}
+		if l.OnChain != nil {
+			onChain := l.OnChain.String()
+			balance.OnChain = &onChain
+		}
+		doc.Balances = append(doc.Balances, balance)
+	}
+	for _, tx := range transfers {
+		doc.Transfers = append(doc.Transfers, NewJSONTransfer(tx))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("error writing JSON: %v", err)
+	}
+	return nil
+}
+
+// WriteNDJSON writes one JSON record per transfer of rows to w, one row at
+// a time
+func WriteNDJSON(w io.Writer, rows Rows, meta Meta) error {
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	address := strings.ToLower(meta.Address)
+	err := rows(func(tx models.FormattedTransfer) error {
+		record := NDJSONRecord{
+			Version:      JSONSchemaVersion,
+			Chain:        meta.Chain.Name,
+			Address:      address,
+			JSONTransfer: NewJSONTransfer(tx),
+		}
+		if err := enc.Encode(record); err != nil {
+			return fmt.Errorf("error writing NDJSON: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		return fmt.Errorf("error writing NDJSON: %v", err)
+	}
+	return nil
+}
+
+// jsonTime formats an optional time bound
+func jsonTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,134 @@
+package output
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/models"
+)
+
+// jsonKeys returns the sorted keys of a JSON object
+func jsonKeys(object map[string]interface{}) string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+const transferKeys = "amount,balance,block,contract,decimals,direction,from,hash,logIndex,time,timestamp,to,token,value"
+
+func TestJSONSchema(t *testing.T) {
+	transfers, ledgers := wethTransfers()
+	transfers[1].LogIndex = 7
+	transfers[2].LogIndex = -1 // Not reported by the source
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "WETH", Contract: testContract, Ledgers: ledgers, FromBlock: 100}
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, transfers, meta); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("reading JSON: %v", err)
+	}
+
+	if got := jsonKeys(doc); got != "address,balances,chain,contract,count,generatedAt,range,schema,token,transfers,version" {
+		t.Errorf("document keys %s", got)
+	}
+	if doc["schema"] != JSONSchema || doc["version"] != float64(JSONSchemaVersion) || doc["count"] != float64(3) {
+		t.Errorf("schema %v version %v count %v", doc["schema"], doc["version"], doc["count"])
+	}
+	if doc["address"] != testAddress || doc["contract"] != testContract || doc["token"] != "WETH" {
+		t.Errorf("address %v contract %v token %v", doc["address"], doc["contract"], doc["token"])
+	}
+	if chain := doc["chain"].(map[string]interface{}); chain["name"] != "ethereum" || chain["chainId"] != float64(1) {
+		t.Errorf("chain %v", chain)
+	}
+	if got := jsonKeys(doc["range"].(map[string]interface{})); got != "fromBlock" {
+		t.Errorf("range keys %s, want only the given bound", got)
+	}
+	balance := doc["balances"].([]interface{})[0].(map[string]interface{})
+	if got := jsonKeys(balance); got != "closing,contract,mismatch,opening,token" {
+		t.Errorf("balance keys %s", got)
+	}
+
+	docTransfers := doc["transfers"].([]interface{})
+	for i, logIndex := range []interface{}{float64(0), float64(7), nil} {
+		tx := docTransfers[i].(map[string]interface{})
+		if got := jsonKeys(tx); got != transferKeys {
+			t.Errorf("transfer %d keys %s", i, got)
+		}
+		if tx["logIndex"] != logIndex {
+			t.Errorf("transfer %d logIndex %v, want %v", i, tx["logIndex"], logIndex)
+		}
+	}
+	if tx := docTransfers[0].(map[string]interface{}); tx["time"] != "2024-01-01T00:00:00Z" || tx["amount"] != "0.000000000000000001" {
+		t.Errorf("transfer 0 time %v amount %v", tx["time"], tx["amount"])
+	}
+
+	// All tokens have no contract and token of their own
+	meta.AllTokens = true
+	buf.Reset()
+	if err := WriteJSON(&buf, nil, meta); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	doc = nil
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("reading JSON: %v", err)
+	}
+	if _, ok := doc["contract"]; ok || doc["count"] != float64(0) || len(doc["transfers"].([]interface{})) != 0 {
+		t.Errorf("all tokens document %v, want no contract and an empty transfer list", doc)
+	}
+}
+
+func TestNDJSONSchema(t *testing.T) {
+	transfers, _ := wethTransfers()
+	transfers[2].LogIndex = -2
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum}
+
+	var buf bytes.Buffer
+	if err := WriteNDJSON(&buf, SliceRows(transfers), meta); err != nil {
+		t.Fatalf("WriteNDJSON: %v", err)
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	lines := 0
+	for ; scanner.Scan(); lines++ {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("line %d: %v", lines+1, err)
+		}
+		if got := jsonKeys(record); got != "address,amount,balance,block,chain,contract,decimals,direction,from,hash,logIndex,time,timestamp,to,token,value,version" {
+			t.Errorf("line %d keys %s", lines+1, got)
+		}
+		if record["version"] != float64(JSONSchemaVersion) || record["chain"] != "ethereum" || record["address"] != testAddress {
+			t.Errorf("line %d context %v %v %v", lines+1, record["version"], record["chain"], record["address"])
+		}
+		if wantNull := lines == 2; (record["logIndex"] == nil) != wantNull {
+			t.Errorf("line %d logIndex %v", lines+1, record["logIndex"])
+		}
+	}
+	if lines != len(transfers) {
+		t.Errorf("got %d lines, want %d", lines, len(transfers))
+	}
+
+	// Rows are written as they are read, an error stops the output
+	failed := errors.New("store unreadable")
+	rows := func(fn func(models.FormattedTransfer) error) error {
+		if err := fn(transfers[0]); err != nil {
+			return err
+		}
+		return failed
+	}
+	if err := WriteNDJSON(&buf, rows, meta); !errors.Is(err, failed) {
+		t.Errorf("WriteNDJSON = %v, want the error of the rows", err)
+	}
+}
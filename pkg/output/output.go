@@ -2,66 +2,150 @@ package output
 
 import (
 	"fmt"
-	"math/big"
+	"io"
 	"os"
+	"strings"
+	"time"
 
-	// "strings"
-
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/ledger"
 	"ethcrawler/pkg/models"
 
 	"github.com/xuri/excelize/v2"
 )
 
-// GenerateFileName generates a filename with the address prefix
-func GenerateFileName(address string, fileType string) string {
+// maxSheetRows is the Excel limit of rows per worksheet, header included.
+// Tests lower it to roll over to continuation sheets.
+var maxSheetRows = excelize.TotalRows
+
+// Meta describes the saved transfers
+type Meta struct {
+	Address   string
+	Chain     chains.Chain
+	Token     string // Token symbol of single token outputs, USDT if empty
+	Contract  string // Token contract of single token outputs
+	AllTokens bool   // Transfers of every token, outputs are grouped per token
+
+	// Requested range, zero values mean open bounds
+	FromBlock int
+	ToBlock   int
+	Since     time.Time
+	Until     time.Time
+
+	Ledgers []ledger.Ledger // Reconstructed balances per token, optional
+
+	Log io.Writer // Progress messages of the Excel writers, nil discards them
+}
+
+// log returns the writer of progress messages
+func (m Meta) log() io.Writer {
+	if m.Log == nil {
+		return io.Discard
+	}
+	return m.Log
+}
+
+// symbol returns the token symbol of single token outputs
+func (m Meta) symbol() string {
+	if m.Token == "" {
+		return "USDT"
+	}
+	return m.Token
+}
+
+// GenerateFileName generates a filename with the chain and address prefix
+func GenerateFileName(meta Meta, fileType string) string {
 	// Use the first 10 characters of the address (including 0x)
-	shortAddress := address
-	if len(address) > 10 {
-		shortAddress = address[:10]
+	shortAddress := meta.Address
+	if len(meta.Address) > 10 {
+		shortAddress = meta.Address[:10]
+	}
+
+	prefix := fileNamePart(meta.symbol())
+	if meta.AllTokens {
+		prefix = "tokens"
 	}
 
-	return fmt.Sprintf("usdt_transactions_%s.%s", shortAddress, fileType)
+	return fmt.Sprintf("%s_transactions_%s_%s.%s", prefix, meta.Chain.Name, shortAddress, fileType)
+}
+
+// SheetName returns the name of the transactions sheet labelled with the
+// chain, cut to the Excel limit and without the characters Excel rejects
+func SheetName(meta Meta) string {
+	return sheetNameOf(fmt.Sprintf("%s Transactions (%s)", meta.symbol(), meta.Chain.Title))
 }
 
 // SaveToTextFile saves formatted transfers to a text file with address in filename
-func SaveToTextFile(transfers []models.FormattedTransfer, address string) (string, error) {
-	filename := GenerateFileName(address, "txt")
-	err := saveToTextFileImpl(transfers, filename)
+func SaveToTextFile(transfers []models.FormattedTransfer, meta Meta) (string, error) {
+	filename := GenerateFileName(meta, "txt")
+	err := saveToTextFileImpl(transfers, meta, filename)
 	return filename, err
 }
 
 // Internal implementation function for text file saving
-func saveToTextFileImpl(transfers []models.FormattedTransfer, filename string) error {
+func saveToTextFileImpl(transfers []models.FormattedTransfer, meta Meta, filename string) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("error creating file: %v", err)
 	}
-	defer f.Close()
+	if err := WriteText(f, transfers, meta); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	for _, tx := range transfers {
-		line := fmt.Sprintf("%s | FROM: %s | TO: %s | VALUE: %s | HASH: %s\n",
-			tx.Date, tx.From, tx.To, tx.Value, tx.Hash)
-		_, err := f.WriteString(line)
-		if err != nil {
+// WriteText writes transfers in the text format to w
+func WriteText(f io.Writer, transfers []models.FormattedTransfer, meta Meta) error {
+	header := fmt.Sprintf("CHAIN: %s | ADDRESS: %s\n", meta.Chain.Title, meta.Address)
+	if _, err := io.WriteString(f, header); err != nil {
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+
+	if !meta.AllTokens {
+		if err := writeTextTransfers(f, transfers); err != nil {
+			return err
+		}
+
+		// Totals of the single token
+		group := TokenGroup{Transfers: transfers}
+		summary := group.Summary()
+		footer := fmt.Sprintf("\nTRANSFERS: %d | IN: %s | OUT: %s | NET: %s %s\n",
+			summary.Transfers, summary.Inflow, summary.Outflow, summary.Net(), meta.symbol())
+		for _, l := range meta.Ledgers {
+			footer += balanceLine(l)
+		}
+		if _, err := io.WriteString(f, footer); err != nil {
+			return fmt.Errorf("error writing to file: %v", err)
+		}
+		return nil
+	}
+
+	// One section per token, preceded by its summary
+	for _, group := range GroupByToken(transfers) {
+		summary := group.Summary()
+		section := fmt.Sprintf("\nTOKEN: %s | CONTRACT: %s | TRANSFERS: %d | IN: %s | OUT: %s | NET: %s | FIRST: %s | LAST: %s\n",
+			group.Label(), group.Contract, summary.Transfers, summary.Inflow, summary.Outflow, summary.Net(), summary.First, summary.Last)
+		if l, ok := ledger.Find(meta.Ledgers, group.Contract); ok {
+			section += balanceLine(l)
+		}
+		if _, err := io.WriteString(f, section); err != nil {
 			return fmt.Errorf("error writing to file: %v", err)
 		}
+		if err := writeTextTransfers(f, group.Transfers); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// SaveToTextFileWithName saves formatted transfers to a text file with specific filename
-func SaveToTextFileWithName(transfers []models.FormattedTransfer, filename string) error {
-	f, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("error creating file: %v", err)
-	}
-	defer f.Close()
-
+// writeTextTransfers writes one line per transfer
+func writeTextTransfers(f io.Writer, transfers []models.FormattedTransfer) error {
 	for _, tx := range transfers {
-		line := fmt.Sprintf("%s | FROM: %s | TO: %s | VALUE: %s | HASH: %s\n",
-			tx.Date, tx.From, tx.To, tx.Value, tx.Hash)
-		_, err := f.WriteString(line)
+		line := fmt.Sprintf("%s | %s | FROM: %s | TO: %s | VALUE: %s %s | BALANCE: %s | HASH: %s\n",
+			tx.Date, strings.ToUpper(string(tx.Direction)), tx.From, tx.To, signedAmount(tx), tx.TokenSymbol, tx.Balance, tx.Hash)
+		_, err := io.WriteString(f, line)
 		if err != nil {
 			return fmt.Errorf("error writing to file: %v", err)
 		}
@@ -70,40 +154,141 @@ func SaveToTextFileWithName(transfers []models.FormattedTransfer, filename strin
 	return nil
 }
 
+// SaveToTextFileWithName saves formatted transfers to a text file with specific filename
+func SaveToTextFileWithName(transfers []models.FormattedTransfer, meta Meta, filename string) error {
+	return saveToTextFileImpl(transfers, meta, filename)
+}
+
 // SaveToExcel saves formatted transfers to an Excel file with address in filename
-func SaveToExcel(transfers []models.FormattedTransfer, address string) (string, error) {
-	filename := GenerateFileName(address, "xlsx")
-	err := saveToExcelImpl(transfers, filename)
+func SaveToExcel(transfers []models.FormattedTransfer, meta Meta) (string, error) {
+	filename := GenerateFileName(meta, "xlsx")
+	err := saveToExcelImpl(SliceRows(transfers), meta, filename)
 	return filename, err
 }
 
-// Internal implementation function for Excel file saving
-func saveToExcelImpl(transfers []models.FormattedTransfer, filename string) error {
-	fmt.Printf("Creating Excel file with %d transactions...\n", len(transfers))
+// SaveExcelRows saves the transfers of rows to an Excel file with specific
+// filename. Rows are read twice and never held in memory.
+func SaveExcelRows(rows Rows, meta Meta, filename string) error {
+	return saveToExcelImpl(rows, meta, filename)
+}
+
+// Internal implementation function for Excel file saving. The first pass over
+// rows adds up the totals of every token and counts the sheets they need, the
+// second one streams the rows into the sheets.
+func saveToExcelImpl(rows Rows, meta Meta, filename string) (err error) {
+	stats, err := tokenStatsOf(rows, meta)
+	if err != nil {
+		return fmt.Errorf("error reading transfers: %v", err)
+	}
+	total := 0
+	for _, s := range stats {
+		total += s.summary.Transfers
+	}
+	fmt.Fprintf(meta.log(), "Creating Excel file with %d transactions...\n", total)
 
 	// Create a new Excel file
 	f := excelize.NewFile()
 	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Println("Error closing Excel file:", err)
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("error closing Excel file: %v", closeErr)
 		}
 	}()
 
-	// Create a new sheet
-	sheetName := "USDT Transactions"
-	_, err := f.NewSheet(sheetName)
+	// Set headers style
+	headerStyle, err := newHeaderStyle(f)
+	if err != nil {
+		return err
+	}
+
+	// All sheets are created in their final order before streaming: the
+	// summary leads workbooks of several tokens and follows the transactions
+	// of a single one. The first sheet takes over the default Sheet1, which
+	// stays the active one: deleting Sheet1 afterwards would make excelize
+	// parse every streamed sheet still buffered in memory.
+	first := true
+	newSheet := func(name string) error {
+		var err error
+		if first {
+			first = false
+			err = f.SetSheetName("Sheet1", name)
+		} else {
+			_, err = f.NewSheet(name)
+		}
+		if err != nil {
+			return fmt.Errorf("error creating sheet: %v", err)
+		}
+		return nil
+	}
+	if meta.AllTokens {
+		if err := newSheet(summarySheet); err != nil {
+			return err
+		}
+	}
+	used := reservedSheetNames()
+	sheets := make([]string, len(stats))
+	rawSheets := make([][]string, len(stats))
+	writers := make([]*sheetWriter, len(stats))
+	index := make(map[string]int, len(stats))
+	for i, s := range stats {
+		name, symbol := SheetName(meta), meta.symbol()
+		if meta.AllTokens {
+			name, symbol = s.group.Label(), s.group.Label()
+		}
+		sheets[i] = uniqueSheetName(name, used)
+		if err := newSheet(sheets[i]); err != nil {
+			return err
+		}
+		if writers[i], err = newSheetWriter(f, sheets[i], symbol, s.summary.Transfers, meta, headerStyle, used); err != nil {
+			return err
+		}
+		rawSheets[i] = writers[i].sheets
+		index[s.group.Contract] = i
+	}
+	if !meta.AllTokens {
+		if err := newSheet(summarySheet); err != nil {
+			return err
+		}
+	}
+
+	err = rows(func(tx models.FormattedTransfer) error {
+		if !meta.AllTokens {
+			return writers[0].add(tx)
+		}
+		i, ok := index[strings.ToLower(tx.Contract)]
+		if !ok {
+			return fmt.Errorf("transfer %s of token %s was not there when counting", tx.Hash, tx.Contract)
+		}
+		return writers[i].add(tx)
+	})
 	if err != nil {
-		return fmt.Errorf("error creating sheet: %v", err)
+		return fmt.Errorf("error writing transfers: %v", err)
+	}
+	for _, w := range writers {
+		if err := w.close(); err != nil {
+			return err
+		}
+	}
+
+	// Inflow and outflow totals, then the analytics
+	if err := writeSummarySheet(f, stats, sheets, meta, headerStyle); err != nil {
+		return err
+	}
+	if err := writeAnalyticsSheets(f, stats, rawSheets, headerStyle); err != nil {
+		return err
 	}
 
-	// Delete default Sheet1
-	f.DeleteSheet("Sheet1")
+	// Save the Excel file
+	fmt.Fprintln(meta.log(), "Saving Excel file...")
+	if err := f.SaveAs(filename); err != nil {
+		return fmt.Errorf("error saving Excel file: %v", err)
+	}
 
-	// Set the active sheet
-	f.SetActiveSheet(1)
+	return nil
+}
 
-	// Set headers style
-	headerStyle, err := f.NewStyle(&excelize.Style{
+// newHeaderStyle creates the style of header rows
+func newHeaderStyle(f *excelize.File) (int, error) {
+	style, err := f.NewStyle(&excelize.Style{
 		Font: &excelize.Font{
 			Bold: true,
 			Size: 12,
@@ -118,245 +303,219 @@ func saveToExcelImpl(transfers []models.FormattedTransfer, filename string) erro
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("error creating header style: %v", err)
+		return 0, fmt.Errorf("error creating header style: %v", err)
 	}
+	return style, nil
+}
 
-	// Add header
-	headers := []string{"Date", "From", "To", "Value (Wei)", "Value (USDT)", "Hash"}
-	for i, header := range headers {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		if err := f.SetCellValue(sheetName, cell, header); err != nil {
-			return fmt.Errorf("error setting header value: %v", err)
+// writeTransfersSheet streams transfers held in memory to an existing sheet
+// and its continuation sheets, see newSheetWriter. It returns the names of
+// the written sheets.
+func writeTransfersSheet(f *excelize.File, sheetName, symbol string, transfers []models.FormattedTransfer, meta Meta, headerStyle int, used map[string]bool) ([]string, error) {
+	w, err := newSheetWriter(f, sheetName, symbol, len(transfers), meta, headerStyle, used)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range transfers {
+		if err := w.add(tx); err != nil {
+			return nil, err
 		}
 	}
+	return w.sheets, w.close()
+}
+
+// sheetWriter streams the transfers of one token to its transactions sheets
+// through a stream writer, which buffers the rows in a temporary file instead
+// of building the worksheet in memory
+type sheetWriter struct {
+	f           *excelize.File
+	sheets      []string
+	headers     []string
+	widths      []float64
+	headerStyle int
+	amountStyle int // Number format of the amount cells
+	mixed       bool
+	meta        Meta
+
+	count   int // Transfers the sheets were created for
+	written int
+	sheet   string // Sheet of the stream writer
+	sw      *excelize.StreamWriter
+	cells   []interface{}
+}
 
-	// Apply header style to header row
-	lastCol := string(rune('A' + len(headers) - 1))
-	if err := f.SetCellStyle(sheetName, "A1", lastCol+"1", headerStyle); err != nil {
-		return fmt.Errorf("error applying header style: %v", err)
+// newSheetWriter prepares the sheets of count transfers of one token, the
+// first one must exist. The converted value column is labelled with symbol.
+// An empty symbol means the transfers mix tokens and adds a token column.
+// Transfers that don't fit into a sheet continue on new sheets named after
+// the first one, e.g. "USDT (2)", reserved in used and created right away
+// so that they follow it.
+func newSheetWriter(f *excelize.File, sheetName, symbol string, count int, meta Meta, headerStyle int, used map[string]bool) (*sheetWriter, error) {
+	w := &sheetWriter{
+		f:           f,
+		sheets:      []string{sheetName},
+		headerStyle: headerStyle,
+		mixed:       symbol == "",
+		meta:        meta,
+		count:       count,
+		cells:       make([]interface{}, 0, 9),
 	}
 
-	// Add data in batches to avoid memory issues
-	const batchSize = 5000
-	for i := 0; i < len(transfers); i += batchSize {
-		end := i + batchSize
-		if end > len(transfers) {
-			end = len(transfers)
-		}
+	// Add header
+	w.headers = []string{"Date", "Direction", "From", "To", "Value (Base Units)", fmt.Sprintf("Value (%s)", symbol), "Balance", "Hash"}
+	w.widths = []float64{20, 10, 45, 45, 20, 15, 15, 70}
+	if w.mixed {
+		w.headers = []string{"Date", "Direction", "From", "To", "Token", "Value (Base Units)", "Value", "Balance", "Hash"}
+		w.widths = []float64{20, 10, 45, 45, 12, 20, 15, 15, 70}
+	}
+	var err error
+	if w.amountStyle, err = newAmountStyle(f); err != nil {
+		return nil, err
+	}
 
-		fmt.Printf("Processing transactions %d-%d of %d...\n", i+1, end, len(transfers))
-
-		// Process this batch
-		for j := i; j < end; j++ {
-			tx := transfers[j]
-			row := j + 2 // +2 because Excel rows are 1-indexed and we have a header row
-
-			// Format USDT value (convert from wei, 1 USDT = 10^6 wei for USDT)
-			valueWei := tx.Value
-			valueUSDT := 0.0
-
-			// Use big.Float for more accurate calculation
-			if val, ok := new(big.Float).SetString(valueWei); ok {
-				divisor := new(big.Float).SetFloat64(1e6)
-				val.Quo(val, divisor)
-
-				// Convert to float64 for display
-				valueUSDT, _ = val.Float64()
-			}
-
-			// Add row data
-			cells := []interface{}{
-				tx.Date,
-				tx.From,
-				tx.To,
-				valueWei,
-				valueUSDT,
-				tx.Hash,
-			}
-
-			for k, value := range cells {
-				cell := fmt.Sprintf("%c%d", 'A'+k, row)
-				if err := f.SetCellValue(sheetName, cell, value); err != nil {
-					return fmt.Errorf("error setting cell value at %s: %v", cell, err)
-				}
-			}
+	for n := maxSheetRows - 1; n < count; n += maxSheetRows - 1 {
+		sheet := uniqueSheetName(sheetName, used)
+		if _, err := f.NewSheet(sheet); err != nil {
+			return nil, fmt.Errorf("error creating sheet: %v", err)
 		}
+		w.sheets = append(w.sheets, sheet)
 	}
+	return w, nil
+}
 
-	// Set column widths
-	columnWidths := []float64{20, 45, 45, 20, 15, 70}
-	for i, width := range columnWidths {
-		colName := string(rune('A' + i))
-		if err := f.SetColWidth(sheetName, colName, colName, width); err != nil {
-			return fmt.Errorf("error setting column width: %v", err)
+// add streams the next transfer, moving on to the next sheet when the
+// current one is full
+func (w *sheetWriter) add(tx models.FormattedTransfer) error {
+	// Progress is reported in batches
+	const batchSize = 5000
+
+	perSheet := maxSheetRows - 1
+	row := w.written % perSheet
+	if row == 0 {
+		if err := w.next(w.written / perSheet); err != nil {
+			return err
 		}
 	}
-
-	// Add filter
-	filterRange := fmt.Sprintf("A1:%s1", lastCol)
-	if err := f.AutoFilter(sheetName, filterRange, []excelize.AutoFilterOptions{}); err != nil {
-		return fmt.Errorf("error adding filter: %v", err)
+	if w.written%batchSize == 0 {
+		fmt.Fprintf(w.meta.log(), "Processing transactions %d-%d of %d...\n", w.written+1, min(w.written+batchSize, w.count), w.count)
 	}
 
-	// Freeze the header row
-	if err := f.SetPanes(sheetName, &excelize.Panes{
-		Freeze:      true,
-		Split:       false,
-		XSplit:      0,
-		YSplit:      1,
-		TopLeftCell: "A2",
-		ActivePane:  "bottomLeft",
-	}); err != nil {
-		return fmt.Errorf("error freezing header row: %v", err)
+	cells := append(w.cells[:0], tx.Date, string(tx.Direction), tx.From, tx.To)
+	if w.mixed {
+		cells = append(cells, tx.TokenSymbol)
 	}
-
-	// Save the Excel file
-	fmt.Println("Saving Excel file...")
-	if err := f.SaveAs(filename); err != nil {
-		return fmt.Errorf("error saving Excel file: %v", err)
+	cells = append(cells, tx.Value,
+		amountCell(signedAmount(tx), w.amountStyle), amountCell(tx.Balance, w.amountStyle), tx.Hash)
+
+	// Link the hash to the transaction page in the chain explorer. A
+	// formula has no limit on the number of links per sheet.
+	if w.meta.Chain.ExplorerURL != "" {
+		cells[len(cells)-1] = excelize.Cell{
+			Value:   tx.Hash,
+			Formula: fmt.Sprintf("HYPERLINK(\"%s\",\"%s\")", w.meta.Chain.TxURL(tx.Hash), tx.Hash),
+		}
 	}
 
+	cell, err := excelize.CoordinatesToCellName(1, row+2)
+	if err != nil {
+		return err
+	}
+	if err := w.sw.SetRow(cell, cells); err != nil {
+		return fmt.Errorf("error writing row %s: %v", cell, err)
+	}
+	w.written++
 	return nil
 }
 
-// SaveToExcelWithName saves formatted transfers to an Excel file with specific filename
-func SaveToExcelWithName(transfers []models.FormattedTransfer, filename string) error {
-	fmt.Printf("Creating Excel file with %d transactions...\n", len(transfers))
-
-	// Create a new Excel file
-	f := excelize.NewFile()
-	defer func() {
-		if err := f.Close(); err != nil {
-			fmt.Println("Error closing Excel file:", err)
+// next flushes the current sheet and starts streaming the n-th one
+func (w *sheetWriter) next(n int) error {
+	if n >= len(w.sheets) {
+		return fmt.Errorf("sheet %s got more than the %d transfers it was created for", w.sheets[0], w.count)
+	}
+	if w.sw != nil {
+		if err := w.flush(); err != nil {
+			return err
 		}
-	}()
-
-	// Create a new sheet
-	sheetName := "USDT Transactions"
-	_, err := f.NewSheet(sheetName)
-	if err != nil {
-		return fmt.Errorf("error creating sheet: %v", err)
+		fmt.Fprintf(w.meta.log(), "Sheet %s is full, continuing on sheet %s...\n", w.sheets[n-1], w.sheets[n])
 	}
 
-	// Delete default Sheet1
-	f.DeleteSheet("Sheet1")
-
-	// Set the active sheet
-	f.SetActiveSheet(1)
-
-	// Set headers style
-	headerStyle, err := f.NewStyle(&excelize.Style{
-		Font: &excelize.Font{
-			Bold: true,
-			Size: 12,
-		},
-		Fill: excelize.Fill{
-			Type:    "pattern",
-			Color:   []string{"#DDEBF7"},
-			Pattern: 1,
-		},
-		Border: []excelize.Border{
-			{Type: "bottom", Color: "#000000", Style: 1},
-		},
-	})
+	sw, err := startSheet(w.f, w.sheets[n], w.headers, w.widths, w.headerStyle)
 	if err != nil {
-		return fmt.Errorf("error creating header style: %v", err)
+		return err
 	}
+	w.sheet, w.sw = w.sheets[n], sw
+	return nil
+}
 
-	// Add header
-	headers := []string{"Date", "From", "To", "Value (Wei)", "Value (USDT)", "Hash"}
-	for i, header := range headers {
-		cell := fmt.Sprintf("%c1", 'A'+i)
-		if err := f.SetCellValue(sheetName, cell, header); err != nil {
-			return fmt.Errorf("error setting header value: %v", err)
+// close finishes the last sheet. A token without transfers gets a sheet with
+// the header only.
+func (w *sheetWriter) close() error {
+	if w.written != w.count {
+		return fmt.Errorf("sheet %s got %d of the %d transfers it was created for", w.sheets[0], w.written, w.count)
+	}
+	if w.sw == nil {
+		if err := w.next(0); err != nil {
+			return err
 		}
 	}
+	return w.flush()
+}
 
-	// Apply header style to header row
-	lastCol := string(rune('A' + len(headers) - 1))
-	if err := f.SetCellStyle(sheetName, "A1", lastCol+"1", headerStyle); err != nil {
-		return fmt.Errorf("error applying header style: %v", err)
+// flush finishes the sheet of the stream writer
+func (w *sheetWriter) flush() error {
+	err := w.sw.Flush()
+	w.sw = nil
+	if err != nil {
+		return fmt.Errorf("error writing sheet %s: %v", w.sheet, err)
 	}
+	return nil
+}
 
-	// Add data in batches to avoid memory issues
-	const batchSize = 5000
-	for i := 0; i < len(transfers); i += batchSize {
-		end := i + batchSize
-		if end > len(transfers) {
-			end = len(transfers)
-		}
+// startSheet starts streaming a sheet with a styled, filtered and frozen
+// header row. Rows must not exceed maxSheetRows - 1.
+func startSheet(f *excelize.File, sheet string, headers []string, columnWidths []float64, headerStyle int) (*excelize.StreamWriter, error) {
+	// The filter has to be set before streaming, the stream writer keeps it
+	lastCol, err := excelize.ColumnNumberToName(len(headers))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.AutoFilter(sheet, "A1:"+lastCol+"1", []excelize.AutoFilterOptions{}); err != nil {
+		return nil, fmt.Errorf("error adding filter: %v", err)
+	}
 
-		fmt.Printf("Processing transactions %d-%d of %d...\n", i+1, end, len(transfers))
-
-		// Process this batch
-		for j := i; j < end; j++ {
-			tx := transfers[j]
-			row := j + 2 // +2 because Excel rows are 1-indexed and we have a header row
-
-			// Format USDT value (convert from wei, 1 USDT = 10^6 wei for USDT)
-			valueWei := tx.Value
-			valueUSDT := 0.0
-
-			// Use big.Float for more accurate calculation
-			if val, ok := new(big.Float).SetString(valueWei); ok {
-				divisor := new(big.Float).SetFloat64(1e6)
-				val.Quo(val, divisor)
-
-				// Convert to float64 for display
-				valueUSDT, _ = val.Float64()
-			}
-
-			// Add row data
-			cells := []interface{}{
-				tx.Date,
-				tx.From,
-				tx.To,
-				valueWei,
-				valueUSDT,
-				tx.Hash,
-			}
-
-			for k, value := range cells {
-				cell := fmt.Sprintf("%c%d", 'A'+k, row)
-				if err := f.SetCellValue(sheetName, cell, value); err != nil {
-					return fmt.Errorf("error setting cell value at %s: %v", cell, err)
-				}
-			}
-		}
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, fmt.Errorf("error creating stream writer: %v", err)
 	}
 
 	// Set column widths
-	columnWidths := []float64{20, 45, 45, 20, 15, 70}
 	for i, width := range columnWidths {
-		colName := string(rune('A' + i))
-		if err := f.SetColWidth(sheetName, colName, colName, width); err != nil {
-			return fmt.Errorf("error setting column width: %v", err)
+		if err := sw.SetColWidth(i+1, i+1, width); err != nil {
+			return nil, fmt.Errorf("error setting column width: %v", err)
 		}
 	}
 
-	// Add filter
-	filterRange := fmt.Sprintf("A1:%s1", lastCol)
-	if err := f.AutoFilter(sheetName, filterRange, []excelize.AutoFilterOptions{}); err != nil {
-		return fmt.Errorf("error adding filter: %v", err)
-	}
-
 	// Freeze the header row
-	if err := f.SetPanes(sheetName, &excelize.Panes{
+	if err := sw.SetPanes(&excelize.Panes{
 		Freeze:      true,
-		Split:       false,
-		XSplit:      0,
 		YSplit:      1,
 		TopLeftCell: "A2",
 		ActivePane:  "bottomLeft",
 	}); err != nil {
-		return fmt.Errorf("error freezing header row: %v", err)
+		return nil, fmt.Errorf("error freezing header row: %v", err)
 	}
 
-	// Save the Excel file
-	fmt.Println("Saving Excel file...")
-	if err := f.SaveAs(filename); err != nil {
-		return fmt.Errorf("error saving Excel file: %v", err)
+	header := make([]interface{}, len(headers))
+	for i, title := range headers {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: title}
+	}
+	if err := sw.SetRow("A1", header); err != nil {
+		return nil, fmt.Errorf("error setting header value: %v", err)
 	}
+	return sw, nil
+}
 
-	return nil
+// SaveToExcelWithName saves formatted transfers to an Excel file with specific filename
+func SaveToExcelWithName(transfers []models.FormattedTransfer, meta Meta, filename string) error {
+	return saveToExcelImpl(SliceRows(transfers), meta, filename)
 }
@@ -0,0 +1,104 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// TestExactRoundTrip writes 18 decimal amounts through the CSV, JSON and
+// Excel writers and reads back the exact text. Excel keeps it in the base
+// units column of the transactions and on the summary sheet.
+func TestExactRoundTrip(t *testing.T) {
+	transfers, ledgers := wethTransfers()
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "WETH", Contract: testContract, Ledgers: ledgers}
+
+	wantAmounts := []string{"0.000000000000000001", "123456789.012345678901234567", "0.000000000000000002"}
+	wantBalances := []string{"0.000000000000000001", "123456789.012345678901234568", "123456789.012345678901234566"}
+	const closing = "123456789.012345678901234566"
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteCSV(&buf, SliceRows(transfers), []string{ColumnAmount, ColumnBalance}); err != nil {
+			t.Fatalf("WriteCSV: %v", err)
+		}
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("reading CSV: %v", err)
+		}
+		if len(records) != len(transfers)+1 {
+			t.Fatalf("got %d CSV records, want a header and %d rows", len(records), len(transfers))
+		}
+		for i, record := range records[1:] {
+			if record[0] != wantAmounts[i] || record[1] != wantBalances[i] {
+				t.Errorf("row %d = %v, want [%s %s]", i+1, record, wantAmounts[i], wantBalances[i])
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := WriteJSON(&buf, transfers, meta); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+		var doc JSONDocument
+		if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+			t.Fatalf("reading JSON: %v", err)
+		}
+		if len(doc.Transfers) != len(transfers) {
+			t.Fatalf("got %d JSON transfers, want %d", len(doc.Transfers), len(transfers))
+		}
+		for i, tx := range doc.Transfers {
+			if tx.Amount != wantAmounts[i] || tx.Balance != wantBalances[i] {
+				t.Errorf("transfer %d has amount %s and balance %s, want %s and %s",
+					i, tx.Amount, tx.Balance, wantAmounts[i], wantBalances[i])
+			}
+		}
+		if len(doc.Balances) != 1 || doc.Balances[0].Closing != closing {
+			t.Errorf("balances = %+v, want closing %s", doc.Balances, closing)
+		}
+	})
+
+	t.Run("excel", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "weth.xlsx")
+		if err := SaveToExcelWithName(transfers, meta, filename); err != nil {
+			t.Fatalf("SaveToExcelWithName: %v", err)
+		}
+		f, err := excelize.OpenFile(filename)
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		defer f.Close()
+
+		// Streamed rows keep the exact amount in base units
+		raw := excelize.Options{RawCellValue: true}
+		sheet := SheetName(meta)
+		for i, tx := range transfers {
+			value, err := f.GetCellValue(sheet, fmt.Sprintf("E%d", i+2), raw)
+			if err != nil {
+				t.Fatalf("GetCellValue: %v", err)
+			}
+			if value != tx.Value {
+				t.Errorf("base units of row %d = %s, want %s", i+2, value, tx.Value)
+			}
+		}
+
+		// Net flow and the closing balance on the summary sheet
+		for _, cell := range []string{"G2", "K2"} {
+			got, err := f.GetCellValue(summarySheet, cell, raw)
+			if err != nil {
+				t.Fatalf("GetCellValue(%s): %v", cell, err)
+			}
+			if got != closing {
+				t.Errorf("summary %s = %s, want %s", cell, got, closing)
+			}
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/models"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// ParquetRowGroupSize is the number of transfers per row group. Rows are
+// buffered in memory until a row group is full.
+const ParquetRowGroupSize = 100_000
+
+// parquetBatchSize is the number of rows handed to the parquet writer at once
+const parquetBatchSize = 1024
+
+// ParquetTransfer is a row of parquet outputs. Addresses and hashes are
+// lowercase hex text in fixed length byte arrays, addresses are dictionary
+// encoded since the same few appear on many rows. Amounts are decimal strings
+// since token amounts exceed the precision of parquet decimals.
+type ParquetTransfer struct {
+	Block     int64     `parquet:"block,delta"`
+	LogIndex  *int32    `parquet:"log_index"` // Null if the source did not report it
+	Time      time.Time `parquet:"time,timestamp(millisecond:utc)"`
+	Hash      [66]byte  `parquet:"hash"`
+	From      [42]byte  `parquet:"from,dict"`
+	To        [42]byte  `parquet:"to,dict"`
+	Direction string    `parquet:"direction,dict"`
+	Contract  [42]byte  `parquet:"contract,dict"`
+	Token     string    `parquet:"token,dict"`
+	Decimals  int32     `parquet:"decimals"`
+	Value     string    `parquet:"value"`   // Base units
+	Amount    string    `parquet:"amount"`  // Token units
+	Balance   string    `parquet:"balance"` // Reconstructed balance after the transfer
+}
+
+// NewParquetTransfer converts a formatted transfer. Hashes and addresses of
+// another length would be cut or padded, so they are an error.
+func NewParquetTransfer(tx models.FormattedTransfer) (ParquetTransfer, error) {
+	row := ParquetTransfer{
+		Block:     int64(tx.BlockNumber),
+		Time:      time.Unix(tx.TimeStamp, 0).UTC(),
+		Direction: string(tx.Direction),
+		Token:     tx.TokenSymbol,
+		Decimals:  int32(tx.Decimals),
+		Value:     tx.Value,
+		Amount:    tx.Amount().String(),
+		Balance:   tx.Balance.String(),
+	}
+	if tx.LogIndex >= 0 {
+		logIndex := int32(tx.LogIndex)
+		row.LogIndex = &logIndex
+	}
+
+	fields := []struct {
+		name  string
+		dst   []byte
+		value string
+	}{
+		{"hash", row.Hash[:], tx.Hash},
+		{"from", row.From[:], tx.From},
+		{"to", row.To[:], tx.To},
+		{"contract", row.Contract[:], tx.Contract},
+	}
+	for _, f := range fields {
+		if len(f.value) != len(f.dst) {
+			return ParquetTransfer{}, fmt.Errorf("%s %q of transfer %s is not %d characters long", f.name, f.value, tx.Hash, len(f.dst))
+		}
+		copy(f.dst, strings.ToLower(f.value))
+	}
+	return row, nil
+}
+
+// ParquetWriter writes transfers to a zstd compressed parquet file, one row
+// group at a time
+type ParquetWriter struct {
+	w     *parquet.GenericWriter[ParquetTransfer]
+	batch []ParquetTransfer
+}
+
+// NewParquetWriter writes to w. The schema name and version, address and
+// chain are stored in the file's key-value metadata.
+func NewParquetWriter(w io.Writer, meta Meta) *ParquetWriter {
+	return &ParquetWriter{
+		w: parquet.NewGenericWriter[ParquetTransfer](w,
+			parquet.Compression(&parquet.Zstd),
+			parquet.MaxRowsPerRowGroup(ParquetRowGroupSize),
+			parquet.CreatedBy("ethcrawler", "", ""),
+			parquet.KeyValueMetadata("schema", JSONSchema),
+			parquet.KeyValueMetadata("version", strconv.Itoa(JSONSchemaVersion)),
+			parquet.KeyValueMetadata("address", strings.ToLower(meta.Address)),
+			parquet.KeyValueMetadata("chain", meta.Chain.Name),
+		),
+		batch: make([]ParquetTransfer, 0, parquetBatchSize),
+	}
+}
+
+// Write appends a transfer
+func (w *ParquetWriter) Write(tx models.FormattedTransfer) error {
+	row, err := NewParquetTransfer(tx)
+	if err != nil {
+		return err
+	}
+	w.batch = append(w.batch, row)
+	if len(w.batch) < cap(w.batch) {
+		return nil
+	}
+	return w.flushBatch()
+}
+
+// Close writes the remaining rows and the file footer
+func (w *ParquetWriter) Close() error {
+	if err := w.flushBatch(); err != nil {
+		return err
+	}
+	if err := w.w.Close(); err != nil {
+		return fmt.Errorf("error writing parquet: %v", err)
+	}
+	return nil
+}
+
+// flushBatch hands the buffered rows to the parquet writer
+func (w *ParquetWriter) flushBatch() error {
+	if len(w.batch) == 0 {
+		return nil
+	}
+	if _, err := w.w.Write(w.batch); err != nil {
+		return fmt.Errorf("error writing parquet rows: %v", err)
+	}
+	w.batch = w.batch[:0]
+	return nil
+}
+
+// WriteParquet writes the transfers of rows as a parquet file to w. Only the
+// row group being filled is held in memory.
+func WriteParquet(w io.Writer, rows Rows, meta Meta) error {
+	pw := NewParquetWriter(w, meta)
+	if err := rows(pw.Write); err != nil {
+		return err
+	}
+	return pw.Close()
+}
@@ -0,0 +1,131 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/models"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	transfers, _ := wethTransfers()
+	for i := range transfers {
+		transfers[i].Hash = fmt.Sprintf("0x%064X", i+0xabc) // Stored in lower case
+	}
+	transfers[2].LogIndex = -1 // Not reported by the source
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "WETH", Contract: testContract}
+
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, SliceRows(transfers), meta); err != nil {
+		t.Fatalf("WriteParquet: %v", err)
+	}
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	columns := []struct {
+		name   string
+		kind   parquet.Kind
+		length int // Of fixed length byte arrays
+	}{
+		{"block", parquet.Int64, 0},
+		{"log_index", parquet.Int32, 0},
+		{"hash", parquet.FixedLenByteArray, 66},
+		{"from", parquet.FixedLenByteArray, 42},
+		{"to", parquet.FixedLenByteArray, 42},
+		{"contract", parquet.FixedLenByteArray, 42},
+		{"direction", parquet.ByteArray, 0},
+		{"amount", parquet.ByteArray, 0},
+	}
+	for _, column := range columns {
+		leaf, ok := file.Schema().Lookup(column.name)
+		if !ok {
+			t.Fatalf("no column %s", column.name)
+		}
+		typ := leaf.Node.Type()
+		if typ.Kind() != column.kind || column.kind == parquet.FixedLenByteArray && typ.Length() != column.length {
+			t.Errorf("column %s has type %s of length %d, want %s of %d", column.name, typ.Kind(), typ.Length(), column.kind, column.length)
+		}
+	}
+	if leaf, _ := file.Schema().Lookup("log_index"); !leaf.Node.Optional() {
+		t.Errorf("log_index is required, want it optional")
+	}
+	if address, _ := file.Lookup("address"); address != testAddress {
+		t.Errorf("address metadata %q", address)
+	}
+
+	rows, err := parquet.Read[ParquetTransfer](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(rows) != len(transfers) {
+		t.Fatalf("read %d rows, want %d", len(rows), len(transfers))
+	}
+	for i, row := range rows {
+		if string(row.Hash[:]) != strings.ToLower(transfers[i].Hash) {
+			t.Errorf("row %d hash = %s", i, row.Hash)
+		}
+		if string(row.From[:]) != transfers[i].From || string(row.To[:]) != transfers[i].To || string(row.Contract[:]) != testContract {
+			t.Errorf("row %d addresses = %s, %s, %s", i, row.From, row.To, row.Contract)
+		}
+		if row.Amount != transfers[i].Amount().String() {
+			t.Errorf("row %d amount = %s, want %s", i, row.Amount, transfers[i].Amount())
+		}
+		if row.Balance != transfers[i].Balance.String() {
+			t.Errorf("row %d balance = %s, want %s", i, row.Balance, transfers[i].Balance)
+		}
+	}
+	if rows[0].LogIndex == nil || *rows[0].LogIndex != 0 || rows[2].LogIndex != nil {
+		t.Errorf("log indexes %v and %v, want 0 and null", rows[0].LogIndex, rows[2].LogIndex)
+	}
+}
+
+func TestParquetRejectsShortHash(t *testing.T) {
+	transfers, _ := wethTransfers()
+	transfers[0].Hash = "0xabc" // Would be padded with zero bytes
+
+	var buf bytes.Buffer
+	err := WriteParquet(&buf, SliceRows(transfers), Meta{Address: testAddress, Chain: chains.Ethereum})
+	if err == nil || !strings.Contains(err.Error(), `hash "0xabc"`) {
+		t.Errorf("WriteParquet with a short hash = %v", err)
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+	tx := models.FormattedTransfer{
+		Hash:     "0x" + strings.Repeat("ab", 32),
+		From:     otherAddress,
+		To:       testAddress,
+		Contract: testContract,
+		Value:    "1",
+	}
+	count := ParquetRowGroupSize + 10
+	rows := func(fn func(models.FormattedTransfer) error) error {
+		for i := 0; i < count; i++ {
+			tx.BlockNumber = i
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := WriteParquet(&buf, rows, Meta{Address: testAddress, Chain: chains.Ethereum}); err != nil {
+		t.Fatalf("WriteParquet: %v", err)
+	}
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	groups := file.RowGroups()
+	if len(groups) != 2 || groups[0].NumRows() != ParquetRowGroupSize || file.NumRows() != int64(count) {
+		t.Errorf("%d rows in %d row groups, want %d in 2 of at most %d", file.NumRows(), len(groups), count, ParquetRowGroupSize)
+	}
+}
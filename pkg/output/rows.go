@@ -0,0 +1,36 @@
+package output
+
+import (
+	"ethcrawler/pkg/models"
+)
+
+// Rows calls fn for each transfer in order and stops at the first error of
+// fn. Writers that need several passes call it more than once, every call
+// must yield the same transfers.
+type Rows func(fn func(models.FormattedTransfer) error) error
+
+// SliceRows returns the rows of transfers held in memory
+func SliceRows(transfers []models.FormattedTransfer) Rows {
+	return func(fn func(models.FormattedTransfer) error) error {
+		for _, tx := range transfers {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Collect reads all rows into memory, for writers that need every transfer
+// at once
+func (rows Rows) Collect() ([]models.FormattedTransfer, error) {
+	var transfers []models.FormattedTransfer
+	err := rows(func(tx models.FormattedTransfer) error {
+		transfers = append(transfers, tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
@@ -0,0 +1,343 @@
+package output
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ethcrawler/pkg/decimal"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// maxSheetNameLength is the Excel limit for worksheet names
+const maxSheetNameLength = 31
+
+// summarySheet is the name of the sheet with per-token totals
+const summarySheet = "Summary"
+
+// TokenGroup holds the transfers of a single token contract
+type TokenGroup struct {
+	Contract  string
+	Symbol    string
+	Name      string
+	Transfers []models.FormattedTransfer
+}
+
+// TokenSummary aggregates the transfers of a token
+type TokenSummary struct {
+	Transfers int
+	Inflow    decimal.Decimal // Sum of incoming transfers in token units
+	Outflow   decimal.Decimal // Sum of outgoing transfers in token units
+	First     string          // Date of the earliest transfer
+	Last      string          // Date of the latest transfer
+	FirstTime int64           // Timestamp of the earliest transfer
+	LastTime  int64           // Timestamp of the latest transfer
+}
+
+// Net returns the balance change over the summarized transfers
+func (s TokenSummary) Net() decimal.Decimal {
+	return s.Inflow.Sub(s.Outflow)
+}
+
+// GroupByToken splits transfers by token contract. Groups are ordered by
+// symbol and contract, transfers keep their order.
+func GroupByToken(transfers []models.FormattedTransfer) []TokenGroup {
+	index := make(map[string]int)
+	var groups []TokenGroup
+	for _, tx := range transfers {
+		contract := strings.ToLower(tx.Contract)
+		i, ok := index[contract]
+		if !ok {
+			i = len(groups)
+			index[contract] = i
+			groups = append(groups, TokenGroup{Contract: contract, Symbol: tx.TokenSymbol, Name: tx.TokenName})
+		}
+		groups[i].Transfers = append(groups[i].Transfers, tx)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groupLess(groups[i], groups[j])
+	})
+	return groups
+}
+
+// groupLess orders token groups by symbol and contract
+func groupLess(a, b TokenGroup) bool {
+	x, y := strings.ToUpper(a.Symbol), strings.ToUpper(b.Symbol)
+	if x != y {
+		return x < y
+	}
+	return a.Contract < b.Contract
+}
+
+// Label returns the token symbol, or a shortened contract if the token has none
+func (g TokenGroup) Label() string {
+	if symbol := strings.TrimSpace(g.Symbol); symbol != "" {
+		return symbol
+	}
+	if len(g.Contract) > 10 {
+		return g.Contract[:10]
+	}
+	if g.Contract == "" {
+		return "Unknown"
+	}
+	return g.Contract
+}
+
+// Summary aggregates the transfers of the group
+func (g TokenGroup) Summary() TokenSummary {
+	var summary TokenSummary
+	for _, tx := range g.Transfers {
+		summary.add(tx)
+	}
+	return summary
+}
+
+// add aggregates one more transfer
+func (s *TokenSummary) add(tx models.FormattedTransfer) {
+	s.Transfers++
+	// Transfers to itself count on both sides and cancel out
+	if tx.Direction.Matches(string(models.DirectionIn)) {
+		s.Inflow = s.Inflow.Add(tx.Amount())
+	}
+	if tx.Direction.Matches(string(models.DirectionOut)) {
+		s.Outflow = s.Outflow.Add(tx.Amount())
+	}
+	if s.Transfers == 1 || tx.TimeStamp < s.FirstTime {
+		s.FirstTime, s.First = tx.TimeStamp, tx.Date
+	}
+	if s.Transfers == 1 || tx.TimeStamp > s.LastTime {
+		s.LastTime, s.Last = tx.TimeStamp, tx.Date
+	}
+}
+
+// signedAmount returns the amount shown for a transfer: negative when it
+// left the address. Transfers to itself keep their unsigned amount.
+func signedAmount(tx models.FormattedTransfer) decimal.Decimal {
+	if tx.Direction == models.DirectionSelf {
+		return tx.Amount()
+	}
+	return tx.SignedAmount()
+}
+
+// balanceStatus summarizes the balance check of a ledger: MISMATCH, OK when
+// the on-chain balance agrees, empty when it was not checked
+func balanceStatus(l ledger.Ledger) string {
+	switch {
+	case l.Mismatch():
+		return "MISMATCH"
+	case l.OnChain != nil:
+		return "OK"
+	}
+	return ""
+}
+
+// balanceLine formats the reconstructed balance of a token for text outputs
+func balanceLine(l ledger.Ledger) string {
+	line := fmt.Sprintf("OPENING: %s | CLOSING: %s", l.Opening, l.Closing)
+	if l.OnChain != nil {
+		line += fmt.Sprintf(" | ON-CHAIN: %s", l.OnChain)
+	}
+	if l.Negative > 0 {
+		line += fmt.Sprintf(" | NEGATIVE AFTER %d TRANSFERS", l.Negative)
+	}
+	if status := balanceStatus(l); status != "" {
+		line += " | CHECK: " + status
+	}
+	return line + "\n"
+}
+
+// setCell writes a cell value. Amounts are written as numeric cells from
+// their exact decimal text instead of going through float64.
+func setCell(f *excelize.File, sheet, cell string, value interface{}) error {
+	if amount, ok := value.(decimal.Decimal); ok {
+		return f.SetCellDefault(sheet, cell, amount.String())
+	}
+	return f.SetCellValue(sheet, cell, value)
+}
+
+// fileNamePart makes a token symbol safe to use in file names
+func fileNamePart(symbol string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return -1
+	}, symbol)
+	if name == "" {
+		return "token"
+	}
+	return name
+}
+
+// reservedSheetNames returns the lowercase names of the sheets a workbook
+// has besides the transactions sheets
+func reservedSheetNames() map[string]bool {
+	used := map[string]bool{"sheet1": true}
+	for _, name := range []string{summarySheet, monthlySheet, topVolumeSheet, topCountSheet, largestTransfersSheet} {
+		used[strings.ToLower(name)] = true
+	}
+	return used
+}
+
+// writeSummarySheet fills the summary sheet with one row of totals per token.
+// Each token links to its transactions sheet from sheets.
+func writeSummarySheet(f *excelize.File, stats []*tokenStats, sheets []string, meta Meta, headerStyle int) error {
+	mismatchStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true, Color: "#C00000"},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating mismatch style: %v", err)
+	}
+	amountStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: ptr(amountFormat)})
+	if err != nil {
+		return fmt.Errorf("error creating amount style: %v", err)
+	}
+	dateStyle, err := f.NewStyle(&excelize.Style{CustomNumFmt: ptr(dateFormat)})
+	if err != nil {
+		return fmt.Errorf("error creating date style: %v", err)
+	}
+
+	headers := []string{"Token", "Name", "Contract", "Transfers", "Inflow", "Outflow", "Net", "First", "Last",
+		"Opening Balance", "Closing Balance", "On-chain Balance", "Balance Check"}
+	for i, header := range headers {
+		cell := fmt.Sprintf("%c1", 'A'+i)
+		if err := f.SetCellValue(summarySheet, cell, header); err != nil {
+			return fmt.Errorf("error setting header value: %v", err)
+		}
+	}
+	lastCol := string(rune('A' + len(headers) - 1))
+	if err := f.SetCellStyle(summarySheet, "A1", lastCol+"1", headerStyle); err != nil {
+		return fmt.Errorf("error applying header style: %v", err)
+	}
+
+	for i, s := range stats {
+		group, summary := s.group, s.summary
+		row := i + 2
+		var first, last interface{}
+		if summary.Transfers > 0 {
+			first, last = excelTime(summary.FirstTime), excelTime(summary.LastTime)
+		}
+		cells := []interface{}{
+			group.Label(),
+			group.Name,
+			group.Contract,
+			summary.Transfers,
+			summary.Inflow,
+			summary.Outflow,
+			summary.Net(),
+			first,
+			last,
+		}
+		l, hasLedger := ledger.Find(meta.Ledgers, group.Contract)
+		if hasLedger {
+			var onChain interface{}
+			if l.OnChain != nil {
+				onChain = *l.OnChain
+			}
+			cells = append(cells, l.Opening, l.Closing, onChain, balanceStatus(l))
+		}
+		for k, value := range cells {
+			cell := fmt.Sprintf("%c%d", 'A'+k, row)
+			if err := setCell(f, summarySheet, cell, value); err != nil {
+				return fmt.Errorf("error setting cell value at %s: %v", cell, err)
+			}
+		}
+
+		if hasLedger && l.Mismatch() {
+			cell := fmt.Sprintf("%c%d", 'A'+len(cells)-1, row)
+			if err := f.SetCellStyle(summarySheet, cell, cell, mismatchStyle); err != nil {
+				return fmt.Errorf("error applying mismatch style: %v", err)
+			}
+		}
+
+		// Link the token to its sheet
+		cell := fmt.Sprintf("A%d", row)
+		if err := f.SetCellHyperLink(summarySheet, cell, fmt.Sprintf("'%s'!A1", sheets[i]), "Location"); err != nil {
+			return fmt.Errorf("error setting hyperlink at %s: %v", cell, err)
+		}
+	}
+
+	// Inflow to net and the balances get thousands separators, the first and
+	// last transfers are dates
+	lastRow := len(stats) + 1
+	for _, columns := range [][2]string{{"E", "G"}, {"J", "L"}} {
+		if err := f.SetCellStyle(summarySheet, fmt.Sprintf("%s2", columns[0]), fmt.Sprintf("%s%d", columns[1], lastRow), amountStyle); err != nil {
+			return fmt.Errorf("error applying amount style: %v", err)
+		}
+	}
+	if err := f.SetCellStyle(summarySheet, "H2", fmt.Sprintf("I%d", lastRow), dateStyle); err != nil {
+		return fmt.Errorf("error applying date style: %v", err)
+	}
+
+	columnWidths := []float64{15, 30, 45, 12, 20, 20, 20, 20, 20, 20, 20, 20, 15}
+	for i, width := range columnWidths {
+		colName := string(rune('A' + i))
+		if err := f.SetColWidth(summarySheet, colName, colName, width); err != nil {
+			return fmt.Errorf("error setting column width: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// uniqueSheetName turns a token label into a valid worksheet name that is
+// not in used yet. Fake tokens often reuse well-known symbols. Names that
+// need a number to be unique are shortened by whole words to make room for
+// it, e.g. "USDT Transactions (2)".
+func uniqueSheetName(label string, used map[string]bool) string {
+	name := cleanSheetName(label)
+	if name == "" {
+		name = "Token"
+	}
+
+	candidate := truncateWords(name, maxSheetNameLength)
+	for n := 2; used[strings.ToLower(candidate)]; n++ {
+		suffix := fmt.Sprintf(" (%d)", n)
+		candidate = truncateWords(name, maxSheetNameLength-len(suffix)) + suffix
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+// sheetNameOf turns a label into a valid worksheet name
+func sheetNameOf(label string) string {
+	return truncateWords(cleanSheetName(label), maxSheetNameLength)
+}
+
+// cleanSheetName replaces the characters Excel rejects in worksheet names
+func cleanSheetName(label string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\'`, r) {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(label))
+}
+
+// truncateWords shortens s to at most n characters. It cuts before the word
+// that does not fit and drops a parenthesis left open by the cut; a single
+// word longer than n is cut in the middle.
+func truncateWords(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+
+	cut := string(runes[:n])
+	if runes[n] != ' ' {
+		if i := strings.LastIndexByte(cut, ' '); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	if open := strings.LastIndexByte(cut, '('); open > 0 && !strings.Contains(cut[open:], ")") {
+		cut = cut[:open]
+	}
+	return strings.TrimSpace(cut)
+}
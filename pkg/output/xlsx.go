@@ -0,0 +1,27 @@
+package output
+
+import (
+	"fmt"
+
+	"ethcrawler/pkg/decimal"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// newAmountStyle returns the number format of amount cells on streamed
+// sheets. Creating the same style again returns the existing one.
+func newAmountStyle(f *excelize.File) (int, error) {
+	style, err := f.NewStyle(&excelize.Style{CustomNumFmt: ptr(amountFormat)})
+	if err != nil {
+		return 0, fmt.Errorf("error creating amount style: %v", err)
+	}
+	return style, nil
+}
+
+// amountCell is an amount on a streamed sheet. The stream writer writes
+// numbers only from float64, so the exact amount is the formula of the cell
+// and the nearest float64 its cached value: the cell is a number, and the
+// formula bar and readers of the formula get every digit.
+func amountCell(amount decimal.Decimal, style int) excelize.Cell {
+	return excelize.Cell{StyleID: style, Formula: amount.String(), Value: amount.Float64()}
+}
@@ -0,0 +1,367 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/models"
+
+	"github.com/xuri/excelize/v2"
+)
+
+const (
+	testAddress  = "0x1111111111111111111111111111111111111111"
+	otherAddress = "0x2222222222222222222222222222222222222222"
+	testContract = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+)
+
+// wethTransfers returns transfers of an 18 decimal token with running
+// balances and the ledger, amounts that float64 cannot hold
+func wethTransfers() ([]models.FormattedTransfer, []ledger.Ledger) {
+	transfer := func(block int, direction models.Direction, value string) models.FormattedTransfer {
+		tx := models.FormattedTransfer{
+			Date:        "2024-01-01 00:00:00",
+			From:        otherAddress,
+			To:          testAddress,
+			Value:       value,
+			Hash:        "0xaa",
+			TimeStamp:   1704067200,
+			BlockNumber: block,
+			Contract:    testContract,
+			TokenSymbol: "WETH",
+			Decimals:    18,
+			Direction:   direction,
+		}
+		if direction == models.DirectionOut {
+			tx.From, tx.To = tx.To, tx.From
+		}
+		return tx
+	}
+
+	transfers := []models.FormattedTransfer{
+		transfer(100, models.DirectionIn, "1"),
+		transfer(101, models.DirectionIn, "123456789012345678901234567"),
+		transfer(102, models.DirectionOut, "2"),
+	}
+	return transfers, ledger.Build(transfers, nil)
+}
+
+func TestExcelAmounts(t *testing.T) {
+	transfers, _ := wethTransfers()
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "WETH", Contract: testContract}
+	filename := filepath.Join(t.TempDir(), "weth.xlsx")
+	if err := SaveToExcelWithName(transfers, meta, filename); err != nil {
+		t.Fatalf("SaveToExcelWithName: %v", err)
+	}
+
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	// Value and balance columns of the streamed transactions sheet are
+	// numbers that keep the exact amount in their formula
+	sheet := SheetName(meta)
+	want := map[string]string{
+		"F2": "0.000000000000000001",
+		"G2": "0.000000000000000001",
+		"F3": "123456789.012345678901234567",
+		"G3": "123456789.012345678901234568",
+		"F4": "-0.000000000000000002",
+		"G4": "123456789.012345678901234566",
+	}
+	for cell, text := range want {
+		assertNumber(t, f, sheet, cell, text)
+	}
+
+	// Base units keep the exact amount as text
+	for cell, text := range map[string]string{"E2": "1", "E3": "123456789012345678901234567", "E4": "2"} {
+		if got, _ := f.GetCellValue(sheet, cell); got != text {
+			t.Errorf("%s = %q, want %q", cell, got, text)
+		}
+		if typ, _ := f.GetCellType(sheet, cell); typ != excelize.CellTypeInlineString {
+			t.Errorf("%s has cell type %v, want an inline string", cell, typ)
+		}
+	}
+}
+
+func TestExcelProgressLog(t *testing.T) {
+	transfers, _ := wethTransfers()
+	var log bytes.Buffer
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "WETH", Contract: testContract, Log: &log}
+	if err := SaveToExcelWithName(transfers, meta, filepath.Join(t.TempDir(), "weth.xlsx")); err != nil {
+		t.Fatalf("SaveToExcelWithName: %v", err)
+	}
+	for _, want := range []string{"Creating Excel file with 3 transactions", "Processing transactions 1-3 of 3", "Saving Excel file"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("log %q, want %q", log.String(), want)
+		}
+	}
+}
+
+// assertNumber checks that a cell is a number with the exact amount want as
+// its formula and the float64 nearest to it as its value
+func assertNumber(t *testing.T, f *excelize.File, sheet, cell, want string) {
+	t.Helper()
+	formula, err := f.GetCellFormula(sheet, cell)
+	if err != nil {
+		t.Fatalf("GetCellFormula(%s): %v", cell, err)
+	}
+	if formula != want {
+		t.Errorf("%s!%s formula = %q, want %q", sheet, cell, formula, want)
+	}
+	raw, err := f.GetCellValue(sheet, cell, excelize.Options{RawCellValue: true})
+	if err != nil {
+		t.Fatalf("GetCellValue(%s): %v", cell, err)
+	}
+	if typ, _ := f.GetCellType(sheet, cell); typ != excelize.CellTypeUnset && typ != excelize.CellTypeNumber {
+		t.Errorf("%s!%s has cell type %v, want a number", sheet, cell, typ)
+	}
+	got, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		t.Fatalf("%s!%s = %q, want a number", sheet, cell, raw)
+	}
+	if exact, _ := strconv.ParseFloat(want, 64); got != exact {
+		t.Errorf("%s!%s = %s, want %s", sheet, cell, raw, want)
+	}
+}
+
+func TestExcelSheetNames(t *testing.T) {
+	transfers, _ := wethTransfers()
+	meta := Meta{
+		Address:  testAddress,
+		Chain:    chains.Custom("BNB Smart Chain: Testnet [archive]"),
+		Token:    "Cake-LP/aEthUSDC*",
+		Contract: testContract,
+	}
+	filename := filepath.Join(t.TempDir(), "cake.xlsx")
+	if err := SaveToExcelWithName(transfers, meta, filename); err != nil {
+		t.Fatalf("SaveToExcelWithName: %v", err)
+	}
+
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	sheet := SheetName(meta)
+	if n := len([]rune(sheet)); n > maxSheetNameLength || strings.ContainsAny(sheet, `:\/?*[]`) {
+		t.Errorf("sheet name %q is not valid in Excel", sheet)
+	}
+	if got, err := f.GetCellValue(sheet, "H2"); err != nil || got != "0xaa" {
+		t.Errorf("hash on sheet %q = %q (%v), want 0xaa", sheet, got, err)
+	}
+}
+
+func TestUniqueSheetName(t *testing.T) {
+	long := strings.Repeat("A", 40)
+	tests := []struct {
+		labels []string
+		want   []string
+	}{
+		{
+			[]string{"USDT Transactions (Ethereum)", "USDT Transactions (Ethereum)", "USDT Transactions (Ethereum)"},
+			[]string{"USDT Transactions (Ethereum)", "USDT Transactions (2)", "USDT Transactions (3)"},
+		},
+		{
+			[]string{"USDT Transactions (Ethereum Mainnet)", "Tether USD on BNB Smart Chain Testnet"},
+			[]string{"USDT Transactions", "Tether USD on BNB Smart Chain"},
+		},
+		{[]string{long, long}, []string{long[:31], long[:27] + " (2)"}},
+		{[]string{"weth", "WETH", ""}, []string{"weth", "WETH (2)", "Token"}},
+		{[]string{"Cake-LP/aEthUSDC*"}, []string{"Cake-LP_aEthUSDC_"}},
+	}
+	for _, tt := range tests {
+		used := make(map[string]bool)
+		for i, label := range tt.labels {
+			got := uniqueSheetName(label, used)
+			if got != tt.want[i] {
+				t.Errorf("sheet for %q after %v = %q, want %q", label, tt.labels[:i], got, tt.want[i])
+			}
+			if n := len([]rune(got)); n > maxSheetNameLength {
+				t.Errorf("sheet %q has %d characters", got, n)
+			}
+		}
+	}
+}
+
+func TestExcelStreamsRows(t *testing.T) {
+	transfer := func(contract, symbol, hash string, block int) models.FormattedTransfer {
+		return models.FormattedTransfer{
+			Date:        "2024-01-01 00:00:00",
+			From:        otherAddress,
+			To:          testAddress,
+			Value:       "1",
+			Hash:        hash,
+			TimeStamp:   1704067200,
+			BlockNumber: block,
+			Contract:    contract,
+			TokenSymbol: symbol,
+			Direction:   models.DirectionIn,
+		}
+	}
+	const dai = "0x6b175474e89094c44da98b954eedeac495271d0f"
+	transfers := []models.FormattedTransfer{
+		transfer(testContract, "WETH", "0x01", 100),
+		transfer(dai, "DAI", "0x02", 101),
+		transfer(testContract, "WETH", "0x03", 102),
+		transfer(dai, "DAI", "0x04", 103),
+		transfer(dai, "DAI", "0x05", 104),
+	}
+
+	// The rows are read once for the totals and once for the sheets
+	calls := 0
+	rows := func(fn func(models.FormattedTransfer) error) error {
+		calls++
+		return SliceRows(transfers)(fn)
+	}
+
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, AllTokens: true}
+	filename := filepath.Join(t.TempDir(), "tokens.xlsx")
+	if err := SaveExcelRows(rows, meta, filename); err != nil {
+		t.Fatalf("SaveExcelRows: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("rows read %d times, want 2", calls)
+	}
+
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	// Interleaved tokens end up on their own sheets in their order
+	want := map[string][]string{"DAI": {"0x02", "0x04", "0x05"}, "WETH": {"0x01", "0x03"}}
+	for sheet, hashes := range want {
+		got, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatalf("GetRows(%s): %v", sheet, err)
+		}
+		if len(got) != len(hashes)+1 {
+			t.Fatalf("sheet %s has %d rows, want a header and %d transfers", sheet, len(got), len(hashes))
+		}
+		for i, hash := range hashes {
+			if row := got[i+1]; row[len(row)-1] != hash {
+				t.Errorf("row %d of %s has hash %s, want %s", i+2, sheet, row[len(row)-1], hash)
+			}
+		}
+	}
+	if sheets := f.GetSheetList(); strings.Join(sheets[:3], ",") != "Summary,DAI,WETH" {
+		t.Errorf("sheets = %v, want Summary, DAI and WETH first", sheets)
+	}
+}
+
+func TestExcelContinuationSheets(t *testing.T) {
+	// Two transfers per sheet
+	defer func(n int) { maxSheetRows = n }(maxSheetRows)
+	maxSheetRows = 3
+
+	values := []string{"1", "2", "123456789012345678901234567", "4", "5"}
+	transfers := make([]models.FormattedTransfer, len(values))
+	for i, value := range values {
+		transfers[i] = models.FormattedTransfer{
+			Date:        "2024-01-01 00:00:00",
+			From:        otherAddress,
+			To:          testAddress,
+			Value:       value,
+			Hash:        fmt.Sprintf("0x%02d", i+1),
+			TimeStamp:   1704067200 + int64(i),
+			BlockNumber: 100 + i,
+			Contract:    testContract,
+			TokenSymbol: "WETH",
+			Decimals:    18,
+			Direction:   models.DirectionIn,
+		}
+	}
+	ledger.Build(transfers, nil)
+
+	meta := Meta{Address: testAddress, Chain: chains.Ethereum, Token: "WETH", Contract: testContract}
+	filename := filepath.Join(t.TempDir(), "weth.xlsx")
+	if err := SaveToExcelWithName(transfers, meta, filename); err != nil {
+		t.Fatalf("SaveToExcelWithName: %v", err)
+	}
+
+	f, err := excelize.OpenFile(filename)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	defer f.Close()
+
+	sheets := f.GetSheetList()
+	if len(sheets) < 3 || sheets[0] != SheetName(meta) {
+		t.Fatalf("sheets = %v, want %s and two continuation sheets first", sheets, SheetName(meta))
+	}
+	raw := excelize.Options{RawCellValue: true}
+	for n, sheet := range sheets[:3] {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatalf("GetRows(%s): %v", sheet, err)
+		}
+		if rows[0][0] != "Date" || len(rows) != min(3, len(transfers)-2*n+1) {
+			t.Errorf("sheet %s has %d rows starting with %v, want a header and its transfers", sheet, len(rows), rows[0])
+		}
+	}
+
+	// The amount, the balance and the explorer link of the third transfer
+	// on the second sheet
+	second := sheets[1]
+	want := map[string]string{
+		"E2": "123456789012345678901234567",
+		"H2": "0x03",
+	}
+	for cell, text := range want {
+		got, err := f.GetCellValue(second, cell, raw)
+		if err != nil {
+			t.Fatalf("GetCellValue(%s): %v", cell, err)
+		}
+		if got != text {
+			t.Errorf("%s!%s = %q, want %q", second, cell, got, text)
+		}
+	}
+	assertNumber(t, f, second, "F2", "123456789.012345678901234567")
+	assertNumber(t, f, second, "G2", "123456789.01234567890123457")
+	formula, err := f.GetCellFormula(second, "H2")
+	if err != nil {
+		t.Fatalf("GetCellFormula: %v", err)
+	}
+	if formula != `HYPERLINK("https://etherscan.io/tx/0x03","0x03")` {
+		t.Errorf("%s!H2 formula = %s, want a link to the transaction page", second, formula)
+	}
+
+	// The largest transfer links to its row on the second sheet
+	ok, target, err := f.GetCellHyperLink(largestTransfersSheet, "H2")
+	if err != nil || !ok {
+		t.Fatalf("largest transfer has no link (%v)", err)
+	}
+	if wantTarget := fmt.Sprintf("'%s'!A2", second); target != wantTarget {
+		t.Errorf("largest transfer links to %s, want %s", target, wantTarget)
+	}
+}
+
+func TestRowLink(t *testing.T) {
+	sheets := []string{"WETH", "WETH (2)", "WETH (3)"}
+	perSheet := maxSheetRows - 1
+	tests := []struct {
+		i    int
+		want string
+	}{
+		{0, "'WETH'!A2"},
+		{perSheet - 1, fmt.Sprintf("'WETH'!A%d", maxSheetRows)},
+		{perSheet, "'WETH (2)'!A2"},
+		{2*perSheet + 4, "'WETH (3)'!A6"},
+	}
+	for _, tt := range tests {
+		if got := rowLink(sheets, tt.i); got != tt.want {
+			t.Errorf("rowLink(%d) = %s, want %s", tt.i, got, tt.want)
+		}
+	}
+}
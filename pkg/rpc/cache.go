@@ -0,0 +1,56 @@
+package rpc
+
+import "container/list"
+
+// blockTimeCacheSize bounds the block timestamps a client keeps. Transfers
+// arrive in block order and a date lookup takes a few dozen blocks, so only
+// recent blocks are looked up again.
+const blockTimeCacheSize = 10000
+
+// blockTimeCache keeps the timestamps of the most recently used blocks. It is
+// not safe for concurrent use.
+type blockTimeCache struct {
+	size  int
+	order *list.List // Entries, most recently used first
+	items map[uint64]*list.Element
+}
+
+// blockTime is a cache entry
+type blockTime struct {
+	block, timestamp uint64
+}
+
+func newBlockTimeCache(size int) *blockTimeCache {
+	return &blockTimeCache{size: size, order: list.New(), items: make(map[uint64]*list.Element)}
+}
+
+// get returns the cached timestamp of block
+func (c *blockTimeCache) get(block uint64) (uint64, bool) {
+	e, ok := c.items[block]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(blockTime).timestamp, true
+}
+
+// put caches the timestamp of block, dropping the least recently used block
+// when the cache is full
+func (c *blockTimeCache) put(block, timestamp uint64) {
+	if e, ok := c.items[block]; ok {
+		e.Value = blockTime{block, timestamp}
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[block] = c.order.PushFront(blockTime{block, timestamp})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(blockTime).block)
+	}
+}
+
+// len returns the number of cached blocks
+func (c *blockTimeCache) len() int {
+	return c.order.Len()
+}
@@ -0,0 +1,32 @@
+package rpc
+
+import "testing"
+
+func TestBlockTimeCache(t *testing.T) {
+	c := newBlockTimeCache(2)
+	c.put(1, 100)
+	c.put(2, 200)
+
+	// Reading block 1 makes block 2 the least recently used
+	if ts, ok := c.get(1); !ok || ts != 100 {
+		t.Fatalf("get(1) = %d, %v, want 100", ts, ok)
+	}
+	c.put(3, 300)
+	if c.len() != 2 {
+		t.Errorf("cache holds %d blocks, want 2", c.len())
+	}
+	if _, ok := c.get(2); ok {
+		t.Errorf("block 2 survived past the size of the cache")
+	}
+	for block, want := range map[uint64]uint64{1: 100, 3: 300} {
+		if ts, ok := c.get(block); !ok || ts != want {
+			t.Errorf("get(%d) = %d, %v, want %d", block, ts, ok, want)
+		}
+	}
+
+	// Updating a cached block does not grow the cache
+	c.put(3, 301)
+	if ts, _ := c.get(3); ts != 301 || c.len() != 2 {
+		t.Errorf("after updating block 3: timestamp %d with %d blocks, want 301 with 2", ts, c.len())
+	}
+}
@@ -0,0 +1,593 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+)
+
+// TransferTopic is keccak256("Transfer(address,address,uint256)")
+const TransferTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// balanceOfSelector is the ABI selector of balanceOf(address)
+const balanceOfSelector = "0x70a08231"
+
+// DefaultBlockRange is the initial number of blocks requested per eth_getLogs call
+const DefaultBlockRange = 5000
+
+// DefaultCallsPerSecond stays below the limits of public and free tier nodes
+const DefaultCallsPerSecond = 10
+
+// timestampBatchSize limits how many block headers are requested in one batch
+const timestampBatchSize = 100
+
+// Client reads ERC20 transfers straight from an Ethereum JSON-RPC node
+type Client struct {
+	URL        string
+	Contract   string
+	HTTPClient *http.Client
+	BlockRange int // Initial eth_getLogs block range, shrunk and grown adaptively
+
+	Retry   etherscan.RetryPolicy
+	Limiter *etherscan.RateLimiter // Shared by every request the client makes
+	Log     io.Writer              // Progress and retry messages, nil discards them
+
+	mu         sync.Mutex
+	blockTimes *blockTimeCache
+	nextID     int
+}
+
+// NewClient creates a new JSON-RPC client
+func NewClient(url, contract string) *Client {
+	return &Client{
+		URL:        url,
+		Contract:   contract,
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		BlockRange: DefaultBlockRange,
+		Retry:      etherscan.DefaultRetryPolicy,
+		Limiter:    etherscan.NewRateLimiter(DefaultCallsPerSecond),
+		Log:        os.Stdout,
+		blockTimes: newBlockTimeCache(blockTimeCacheSize),
+	}
+}
+
+// Error is a JSON-RPC error object returned by the node
+type Error struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("JSON-RPC error %d: %s", e.Code, e.Message)
+}
+
+// RateLimited reports whether the node rejected the call because of rate
+// limits. Some providers use the code of TooManyResults for both.
+func (e *Error) RateLimited() bool {
+	text := strings.ToLower(e.Message)
+	for _, marker := range []string{
+		"rate limit", "rate exceeded", "too many requests", "request count exceeded",
+	} {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return e.Code == 429
+}
+
+// Temporary reports whether the same call may succeed later, used by
+// etherscan.IsTemporary. Queries with too many results are split instead.
+func (e *Error) Temporary() bool {
+	return e.RateLimited() || e.Code == -32603 && !e.TooManyResults()
+}
+
+// TooManyResults reports whether the node refused a log query because the
+// block range or the result set was too large
+func (e *Error) TooManyResults() bool {
+	if e.RateLimited() {
+		return false
+	}
+	if e.Code == -32005 {
+		return true
+	}
+	text := strings.ToLower(e.Message)
+	for _, marker := range []string{
+		"too many", "more than", "limit exceeded", "response size",
+		"range is too large", "block range", "query timeout", "exceed",
+	} {
+		if strings.Contains(text, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      int           `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type response struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// logEntry is an element of the eth_getLogs result
+type logEntry struct {
+	Address          string   `json:"address"`
+	Topics           []string `json:"topics"`
+	Data             string   `json:"data"`
+	BlockNumber      string   `json:"blockNumber"`
+	TransactionHash  string   `json:"transactionHash"`
+	TransactionIndex string   `json:"transactionIndex"`
+	LogIndex         string   `json:"logIndex"`
+	Removed          bool     `json:"removed"`
+}
+
+// StreamTokenTransfers reads Transfer events to or from q.Address, one block
+// range at a time, and passes every non-empty range to fn. The range is halved
+// when the node reports too many results and grown again after small answers.
+func (c *Client) StreamTokenTransfers(ctx context.Context, q models.Query, fn func([]models.ERC20Transfer) error) (models.Progress, error) {
+	var progress models.Progress
+
+	head, err := c.BlockNumber(ctx)
+	if err != nil {
+		return progress, err
+	}
+	if q.EndBlock > 0 && uint64(q.EndBlock) < head {
+		head = uint64(q.EndBlock)
+	}
+
+	blockRange := c.BlockRange
+	if blockRange < 1 {
+		blockRange = DefaultBlockRange
+	}
+	maxRange := blockRange * 16
+
+	from := uint64(q.StartBlock)
+	for from <= head {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+
+		to := from + uint64(blockRange) - 1
+		if to > head {
+			to = head
+		}
+
+		fmt.Fprintf(etherscan.LogWriter(c.Log), "Reading logs of blocks %d-%d of %d\n", from, to, head)
+
+		logs, err := c.transferLogs(ctx, q.Contract, q.Address, from, to)
+		var rpcErr *Error
+		if errors.As(err, &rpcErr) && rpcErr.TooManyResults() && blockRange > 1 {
+			// Split the range and try again
+			blockRange /= 2
+			continue
+		}
+		if err != nil {
+			return progress, err
+		}
+
+		transfers, err := c.toTransfers(ctx, logs)
+		if err != nil {
+			return progress, err
+		}
+
+		if len(transfers) > 0 {
+			if err := fn(transfers); err != nil {
+				return progress, err
+			}
+
+			lastBlock, _ := models.StringToInt(transfers[len(transfers)-1].BlockNumber)
+			progress.Pages++
+			progress.Transfers += len(transfers)
+			progress.LastBlock = lastBlock
+		}
+
+		// Few results: the node can likely serve a wider range
+		if len(logs) < 1000 && blockRange < maxRange {
+			blockRange *= 2
+		}
+
+		from = to + 1
+	}
+
+	fmt.Fprintf(etherscan.LogWriter(c.Log), "Read %d transfers total\n", progress.Transfers)
+
+	return progress, nil
+}
+
+// BlockNumber returns the number of the most recent block
+func (c *Client) BlockNumber(ctx context.Context) (uint64, error) {
+	var hex string
+	if err := c.call(ctx, "eth_blockNumber", nil, &hex); err != nil {
+		return 0, err
+	}
+	return parseQuantity(hex)
+}
+
+// BlockNumberByTime returns the last block mined at or before t, or the first
+// block mined at or after t when after is set. Nodes have no index by time,
+// so this is a binary search over block timestamps.
+func (c *Client) BlockNumberByTime(ctx context.Context, t time.Time, after bool) (int, error) {
+	head, err := c.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	target := uint64(t.Unix())
+
+	// Find the first block past the target: at or after it, or strictly after it
+	lo, hi := uint64(0), head+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		times, err := c.loadBlockTimes(ctx, []uint64{mid})
+		if err != nil {
+			return 0, err
+		}
+		ts := times[mid]
+
+		if ts > target || after && ts == target {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	if after {
+		if lo > head {
+			return 0, fmt.Errorf("no block mined after %s yet", t.Format(time.RFC3339))
+		}
+		return int(lo), nil
+	}
+	if lo == 0 {
+		return 0, fmt.Errorf("no block mined before %s", t.Format(time.RFC3339))
+	}
+	return int(lo - 1), nil
+}
+
+// TokenBalance returns the current balanceOf(address) of the token contract
+// in base units
+func (c *Client) TokenBalance(ctx context.Context, contract, address string) (*big.Int, error) {
+	call := map[string]interface{}{
+		"to":   contract,
+		"data": balanceOfSelector + strings.TrimPrefix(addressTopic(address), "0x"),
+	}
+
+	var hex string
+	if err := c.call(ctx, "eth_call", []interface{}{call, "latest"}, &hex); err != nil {
+		return nil, err
+	}
+
+	balance, ok := new(big.Int).SetString(strings.TrimPrefix(hex, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("error parsing balance %q", hex)
+	}
+	return balance, nil
+}
+
+// transferLogs returns Transfer events sent or received by address within
+// the block range, ordered by block and log index. An empty contract matches
+// every token.
+func (c *Client) transferLogs(ctx context.Context, contract, address string, from, to uint64) ([]logEntry, error) {
+	topic := addressTopic(address)
+
+	// Topics are ANDed, so outgoing and incoming transfers need two queries
+	var logs []logEntry
+	for _, topics := range [][]interface{}{
+		{TransferTopic, topic},
+		{TransferTopic, nil, topic},
+	} {
+		filter := map[string]interface{}{
+			"fromBlock": quantity(from),
+			"toBlock":   quantity(to),
+			"topics":    topics,
+		}
+		if contract != "" {
+			filter["address"] = contract
+		}
+
+		var part []logEntry
+		if err := c.call(ctx, "eth_getLogs", []interface{}{filter}, &part); err != nil {
+			return nil, err
+		}
+		logs = append(logs, part...)
+	}
+
+	// Self transfers match both queries
+	seen := make(map[string]struct{}, len(logs))
+	unique := logs[:0]
+	for _, entry := range logs {
+		key := entry.TransactionHash + ":" + entry.LogIndex
+		if _, ok := seen[key]; ok || entry.Removed {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, entry)
+	}
+
+	sort.Slice(unique, func(i, j int) bool {
+		bi, _ := parseQuantity(unique[i].BlockNumber)
+		bj, _ := parseQuantity(unique[j].BlockNumber)
+		if bi != bj {
+			return bi < bj
+		}
+		li, _ := parseQuantity(unique[i].LogIndex)
+		lj, _ := parseQuantity(unique[j].LogIndex)
+		return li < lj
+	})
+
+	return unique, nil
+}
+
+// toTransfers decodes ERC20 Transfer logs in the Etherscan tokentx format
+func (c *Client) toTransfers(ctx context.Context, logs []logEntry) ([]models.ERC20Transfer, error) {
+	var blocks []uint64
+	for _, entry := range logs {
+		block, err := parseQuantity(entry.BlockNumber)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing block number: %v", err)
+		}
+		blocks = append(blocks, block)
+	}
+
+	times, err := c.loadBlockTimes(ctx, blocks)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make([]models.ERC20Transfer, 0, len(logs))
+	for i, entry := range logs {
+		// ERC721 Transfer has the token id as a third indexed topic
+		if len(entry.Topics) != 3 {
+			continue
+		}
+
+		value, ok := new(big.Int).SetString(strings.TrimPrefix(entry.Data, "0x"), 16)
+		if !ok {
+			return nil, fmt.Errorf("error parsing value %q of %s", entry.Data, entry.TransactionHash)
+		}
+		logIndex, err := parseQuantity(entry.LogIndex)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing log index: %v", err)
+		}
+		txIndex, err := parseQuantity(entry.TransactionIndex)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing transaction index: %v", err)
+		}
+
+		transfers = append(transfers, models.ERC20Transfer{
+			TimeStamp:        strconv.FormatUint(times[blocks[i]], 10),
+			From:             topicAddress(entry.Topics[1]),
+			To:               topicAddress(entry.Topics[2]),
+			Value:            value.String(),
+			Hash:             entry.TransactionHash,
+			BlockNumber:      strconv.FormatUint(blocks[i], 10),
+			LogIndex:         strconv.FormatUint(logIndex, 10),
+			TransactionIndex: strconv.FormatUint(txIndex, 10),
+			ContractAddress:  strings.ToLower(entry.Address),
+		})
+	}
+
+	return transfers, nil
+}
+
+// loadBlockTimes returns the timestamps of blocks. Blocks that are not cached
+// are fetched with batched eth_getBlockByNumber calls.
+func (c *Client) loadBlockTimes(ctx context.Context, blocks []uint64) (map[uint64]uint64, error) {
+	times := make(map[uint64]uint64, len(blocks))
+	var missing []uint64
+	c.mu.Lock()
+	for _, block := range blocks {
+		if _, ok := times[block]; ok {
+			continue
+		}
+		ts, ok := c.blockTimes.get(block)
+		if !ok {
+			missing = append(missing, block)
+		}
+		times[block] = ts
+	}
+	c.mu.Unlock()
+
+	for start := 0; start < len(missing); start += timestampBatchSize {
+		end := start + timestampBatchSize
+		if end > len(missing) {
+			end = len(missing)
+		}
+
+		calls := make([]request, 0, end-start)
+		for _, block := range missing[start:end] {
+			calls = append(calls, request{
+				Method: "eth_getBlockByNumber",
+				Params: []interface{}{quantity(block), false},
+			})
+		}
+
+		results, err := c.batch(ctx, calls)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, raw := range results {
+			var header struct {
+				Timestamp string `json:"timestamp"`
+			}
+			if err := json.Unmarshal(raw, &header); err != nil {
+				return nil, fmt.Errorf("error parsing block header: %v", err)
+			}
+			ts, err := parseQuantity(header.Timestamp)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing block timestamp: %v", err)
+			}
+
+			block := missing[start+i]
+			times[block] = ts
+			c.mu.Lock()
+			c.blockTimes.put(block, ts)
+			c.mu.Unlock()
+		}
+	}
+
+	return times, nil
+}
+
+// call performs a single JSON-RPC call and decodes its result
+func (c *Client) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	results, err := c.batch(ctx, []request{{Method: method, Params: params}})
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(results[0], result); err != nil {
+		return fmt.Errorf("error parsing %s result: %v", method, err)
+	}
+	return nil
+}
+
+// batch sends calls as one JSON-RPC request (a batch when there are several)
+// and returns their results in the same order. Transient failures are retried
+// according to the client retry policy.
+func (c *Client) batch(ctx context.Context, calls []request) ([]json.RawMessage, error) {
+	c.mu.Lock()
+	for i := range calls {
+		c.nextID++
+		calls[i].JSONRPC = "2.0"
+		calls[i].ID = c.nextID
+	}
+	c.mu.Unlock()
+
+	var results []json.RawMessage
+	err := etherscan.Retry(ctx, c.Retry, c.Limiter, c.Log, func() error {
+		var err error
+		results, err = c.send(ctx, calls)
+		return err
+	})
+	return results, err
+}
+
+// send makes a single attempt of a batch
+func (c *Client) send(ctx context.Context, calls []request) ([]json.RawMessage, error) {
+	var payload interface{} = calls
+	if len(calls) == 1 {
+		payload = calls[0]
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("error making request: %w", errors.Join(etherscan.ErrTransport, err))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, fmt.Errorf("error reading response: %w", errors.Join(etherscan.ErrTransport, err))
+	}
+
+	var responses []response
+	if len(calls) == 1 {
+		var single response
+		if err := json.Unmarshal(data, &single); err != nil {
+			if resp.StatusCode != http.StatusOK {
+				return nil, &etherscan.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+			}
+			return nil, fmt.Errorf("error unmarshalling response: %v", err)
+		}
+		responses = []response{single}
+	} else if err := json.Unmarshal(data, &responses); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &etherscan.HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		return nil, fmt.Errorf("error unmarshalling response: %v", err)
+	}
+
+	// Batch responses may come in any order
+	byID := make(map[int]response, len(responses))
+	for _, r := range responses {
+		byID[r.ID] = r
+	}
+
+	results := make([]json.RawMessage, len(calls))
+	for i, call := range calls {
+		r, ok := byID[call.ID]
+		if !ok && len(calls) == 1 && len(responses) == 1 {
+			r, ok = responses[0], true
+		}
+		if !ok {
+			return nil, fmt.Errorf("no response to %s call", call.Method)
+		}
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		results[i] = r.Result
+	}
+
+	return results, nil
+}
+
+// quantity encodes a number as a JSON-RPC hex quantity
+func quantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+// parseQuantity decodes a JSON-RPC hex quantity
+func parseQuantity(hex string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(hex, "0x"), 16, 64)
+}
+
+// addressTopic left-pads an address to a 32-byte topic
+func addressTopic(address string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.ToLower(strings.TrimPrefix(address, "0x"))
+}
+
+// topicAddress extracts the address from a 32-byte topic
+func topicAddress(topic string) string {
+	hex := strings.TrimPrefix(topic, "0x")
+	if len(hex) > 40 {
+		hex = hex[len(hex)-40:]
+	}
+	return "0x" + strings.ToLower(hex)
+}
@@ -0,0 +1,321 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+)
+
+const (
+	testAddress  = "0x1111111111111111111111111111111111111111"
+	testContract = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	otherAddress = "0x2222222222222222222222222222222222222222"
+)
+
+// node is a mock JSON-RPC node. eth_getLogs refuses ranges wider than
+// maxRange like providers with a result cap do, and the first failures
+// requests are answered with HTTP errors.
+type node struct {
+	head     uint64
+	logs     []logEntry
+	maxRange uint64
+	failures []int
+
+	mu       sync.Mutex
+	requests int
+	ranges   [][2]uint64 // Block ranges of every eth_getLogs call
+}
+
+func (n *node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	n.requests++
+	if n.requests <= len(n.failures) {
+		n.mu.Unlock()
+		w.WriteHeader(n.failures[n.requests-1])
+		return
+	}
+	n.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	var calls []request
+	if err := json.Unmarshal(body, &calls); err != nil {
+		var single request
+		if err := json.Unmarshal(body, &single); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(n.answer(single))
+		return
+	}
+
+	answers := make([]response, len(calls))
+	for i, call := range calls {
+		answers[i] = n.answer(call)
+	}
+	json.NewEncoder(w).Encode(answers)
+}
+
+func (n *node) answer(call request) response {
+	result := func(v interface{}) response {
+		raw, _ := json.Marshal(v)
+		return response{ID: call.ID, Result: raw}
+	}
+
+	switch call.Method {
+	case "eth_blockNumber":
+		return result(quantity(n.head))
+	case "eth_getBlockByNumber":
+		block, _ := parseQuantity(call.Params[0].(string))
+		return result(map[string]string{"timestamp": quantity(1_600_000_000 + block*12)})
+	case "eth_getLogs":
+		filter := call.Params[0].(map[string]interface{})
+		from, _ := parseQuantity(filter["fromBlock"].(string))
+		to, _ := parseQuantity(filter["toBlock"].(string))
+		n.mu.Lock()
+		n.ranges = append(n.ranges, [2]uint64{from, to})
+		n.mu.Unlock()
+		if to-from+1 > n.maxRange {
+			return response{ID: call.ID, Error: &Error{Code: -32005, Message: "query returned more than 10000 results"}}
+		}
+
+		topics := filter["topics"].([]interface{})
+		var logs []logEntry
+		for _, entry := range n.logs {
+			block, _ := parseQuantity(entry.BlockNumber)
+			if block < from || block > to {
+				continue
+			}
+			if t, ok := topics[1].(string); ok && t != entry.Topics[1] {
+				continue
+			}
+			if len(topics) > 2 && topics[2] != entry.Topics[2] {
+				continue
+			}
+			logs = append(logs, entry)
+		}
+		return result(logs)
+	}
+	return response{ID: call.ID, Error: &Error{Code: -32601, Message: "method not found"}}
+}
+
+func transferLog(block, logIndex uint64, from, to string, value int) logEntry {
+	return logEntry{
+		Address:          testContract,
+		Topics:           []string{TransferTopic, addressTopic(from), addressTopic(to)},
+		Data:             fmt.Sprintf("0x%064x", value),
+		BlockNumber:      quantity(block),
+		TransactionHash:  fmt.Sprintf("0x%064x", block*100+logIndex),
+		TransactionIndex: "0x0",
+		LogIndex:         quantity(logIndex),
+	}
+}
+
+func TestStreamSplitsRangesAndRetries(t *testing.T) {
+	n := &node{head: 1999, maxRange: 250, failures: []int{http.StatusTooManyRequests, http.StatusBadGateway}}
+	for i := uint64(0); i < 40; i++ {
+		from, to := otherAddress, testAddress
+		if i%2 == 1 {
+			from, to = testAddress, otherAddress
+		}
+		n.logs = append(n.logs, transferLog(1000+i*25, i%3, from, to, int(i+1)))
+	}
+	// Sent to itself, matched by both topic queries
+	n.logs = append(n.logs, transferLog(1990, 5, testAddress, testAddress, 99))
+
+	ts := httptest.NewServer(n)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, testContract)
+	client.BlockRange = 1000
+	client.Retry = etherscan.RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+	client.Limiter = nil
+
+	var got []models.ERC20Transfer
+	progress, err := client.StreamTokenTransfers(context.Background(), models.Query{Address: testAddress, StartBlock: 1000},
+		func(page []models.ERC20Transfer) error {
+			got = append(got, page...)
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("StreamTokenTransfers: %v", err)
+	}
+
+	if len(got) != len(n.logs) || progress.Transfers != len(n.logs) {
+		t.Fatalf("got %d transfers (progress %d), want %d", len(got), progress.Transfers, len(n.logs))
+	}
+	seen := make(map[string]bool)
+	lastBlock := 0
+	for _, tx := range got {
+		key := tx.Hash + ":" + tx.LogIndex
+		if seen[key] {
+			t.Errorf("transfer %s delivered twice", key)
+		}
+		seen[key] = true
+
+		block, _ := models.StringToInt(tx.BlockNumber)
+		if block < lastBlock {
+			t.Errorf("block %d delivered after block %d", block, lastBlock)
+		}
+		lastBlock = block
+	}
+	if progress.LastBlock != 1990 {
+		t.Errorf("last block = %d, want 1990", progress.LastBlock)
+	}
+
+	// The first range is refused, split once and refused again, then served
+	want := [][2]uint64{{1000, 1999}, {1000, 1499}, {1000, 1249}}
+	for i, r := range want {
+		if i >= len(n.ranges) || n.ranges[i] != r {
+			t.Fatalf("eth_getLogs ranges start with %v, want %v", n.ranges[:min(len(n.ranges), len(want))], want)
+		}
+	}
+	for _, r := range n.ranges {
+		if r[1] > 1999 {
+			t.Errorf("range %v goes past the head", r)
+		}
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		err            Error
+		tooMany, retry bool
+	}{
+		{Error{Code: -32005, Message: "query returned more than 10000 results"}, true, false},
+		{Error{Code: -32602, Message: "eth_getLogs block range is too large, max is 2000"}, true, false},
+		{Error{Code: -32005, Message: "daily request count exceeded, request rate limited"}, false, true},
+		{Error{Code: 429, Message: "Too Many Requests"}, false, true},
+		{Error{Code: -32603, Message: "internal error"}, false, true},
+		{Error{Code: -32601, Message: "the method eth_foo does not exist"}, false, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.TooManyResults(); got != tt.tooMany {
+			t.Errorf("%q: TooManyResults = %v, want %v", tt.err.Message, got, tt.tooMany)
+		}
+		if got := etherscan.IsTemporary(fmt.Errorf("call failed: %w", &tt.err)); got != tt.retry {
+			t.Errorf("%q: IsTemporary = %v, want %v", tt.err.Message, got, tt.retry)
+		}
+	}
+}
+
+func TestTopicAddress(t *testing.T) {
+	topic := addressTopic("0xABCDEF0000000000000000000000000000000001")
+	if len(topic) != 66 || !strings.HasSuffix(topic, "abcdef0000000000000000000000000000000001") {
+		t.Errorf("addressTopic = %s", topic)
+	}
+	if got := topicAddress(topic); got != "0xabcdef0000000000000000000000000000000001" {
+		t.Errorf("topicAddress = %s", got)
+	}
+}
+
+func TestStreamRejectsMalformedValue(t *testing.T) {
+	n := &node{head: 1999, maxRange: 10000}
+	entry := transferLog(1500, 0, otherAddress, testAddress, 1)
+	entry.Data = "0x"
+	n.logs = append(n.logs, entry)
+
+	ts := httptest.NewServer(n)
+	defer ts.Close()
+
+	client := NewClient(ts.URL, testContract)
+	client.Limiter = nil
+	_, err := client.StreamTokenTransfers(context.Background(), models.Query{Address: testAddress},
+		func(page []models.ERC20Transfer) error {
+			t.Errorf("got %d transfers of a log without a value", len(page))
+			return nil
+		})
+	if err == nil || !strings.Contains(err.Error(), "error parsing value") {
+		t.Errorf("StreamTokenTransfers error = %v, want a value parsing error", err)
+	}
+}
+
+func TestStreamGrowsDefaultRange(t *testing.T) {
+	n := &node{head: 199_999, maxRange: 1_000_000}
+	ts := httptest.NewServer(n)
+	defer ts.Close()
+
+	// No BlockRange starts at the default range and grows from it
+	client := NewClient(ts.URL, testContract)
+	client.BlockRange = 0
+	client.Limiter = nil
+	if _, err := client.StreamTokenTransfers(context.Background(), models.Query{Address: testAddress},
+		func([]models.ERC20Transfer) error { return nil }); err != nil {
+		t.Fatalf("StreamTokenTransfers: %v", err)
+	}
+
+	widest := uint64(0)
+	for _, r := range n.ranges {
+		widest = max(widest, r[1]-r[0]+1)
+	}
+	if first := n.ranges[0][1] - n.ranges[0][0] + 1; first != DefaultBlockRange {
+		t.Errorf("first range has %d blocks, want %d", first, DefaultBlockRange)
+	}
+	if widest <= DefaultBlockRange || widest > 16*DefaultBlockRange {
+		t.Errorf("widest range has %d blocks, want more than %d and at most %d",
+			widest, DefaultBlockRange, 16*DefaultBlockRange)
+	}
+}
+
+func TestBlockNumberByTime(t *testing.T) {
+	// The mock node mines block n at 1_600_000_000 + 12n
+	n := &node{head: 1000}
+	ts := httptest.NewServer(n)
+	defer ts.Close()
+	client := NewClient(ts.URL, testContract)
+	client.Limiter = nil
+	client.blockTimes = newBlockTimeCache(8)
+
+	at := func(seconds int64) time.Time { return time.Unix(1_600_000_000+seconds, 0) }
+	tests := []struct {
+		name    string
+		t       time.Time
+		after   bool
+		want    int
+		wantErr string
+	}{
+		{"exact block, at or before", at(120), false, 10, ""},
+		{"exact block, at or after", at(120), true, 10, ""},
+		{"between blocks, before", at(125), false, 10, ""},
+		{"between blocks, after", at(125), true, 11, ""},
+		{"genesis", at(0), false, 0, ""},
+		{"head", at(12_000), true, 1000, ""},
+		{"after the head, before", at(20_000), false, 1000, ""},
+		{"after the head, after", at(20_000), true, 0, "no block mined after"},
+		{"before genesis", at(-1), false, 0, "no block mined before"},
+		{"before genesis, after", at(-1), true, 0, ""},
+	}
+	for _, tt := range tests {
+		got, err := client.BlockNumberByTime(context.Background(), tt.t, tt.after)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: BlockNumberByTime = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+
+	// The search reads a logarithmic number of block timestamps
+	n.mu.Lock()
+	requests := n.requests
+	n.mu.Unlock()
+	if limit := len(tests) * 13; requests > limit {
+		t.Errorf("%d requests for %d searches, want at most %d", requests, len(tests), limit)
+	}
+	if cached := client.blockTimes.len(); cached > 8 {
+		t.Errorf("cached %d block timestamps, want at most 8", cached)
+	}
+}
@@ -0,0 +1,107 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"ethcrawler/pkg/models"
+)
+
+// Fake is an in-memory TransferSource replaying a fixed list of transfers,
+// for tests. Transfers must be in block order.
+type Fake struct {
+	Transfers []models.ERC20Transfer
+	PageSize  int   // Transfers per page, 0 means everything in one page
+	FailAfter int   // Return Err after this many pages, 0 means never fail
+	Err       error // Error returned once FailAfter pages were delivered
+
+	// Balances by lowercase contract, read by TokenBalance, which fails for
+	// contracts missing from the map
+	Balances map[string]*big.Int
+
+	mu      sync.Mutex
+	queries []models.Query
+}
+
+// NewFake creates a fake source replaying transfers in pages of pageSize
+func NewFake(pageSize int, transfers ...models.ERC20Transfer) *Fake {
+	return &Fake{Transfers: transfers, PageSize: pageSize}
+}
+
+// Queries returns the queries the fake has received so far
+func (f *Fake) Queries() []models.Query {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.Query(nil), f.queries...)
+}
+
+// StreamTokenTransfers replays the transfers of q.Address within the block
+// range. Contracts are only compared for transfers that carry one.
+func (f *Fake) StreamTokenTransfers(ctx context.Context, q models.Query, fn func([]models.ERC20Transfer) error) (models.Progress, error) {
+	var progress models.Progress
+
+	f.mu.Lock()
+	f.queries = append(f.queries, q)
+	f.mu.Unlock()
+
+	var matching []models.ERC20Transfer
+	for _, tx := range f.Transfers {
+		if !strings.EqualFold(tx.From, q.Address) && !strings.EqualFold(tx.To, q.Address) {
+			continue
+		}
+		if q.Contract != "" && tx.ContractAddress != "" && !strings.EqualFold(tx.ContractAddress, q.Contract) {
+			continue
+		}
+		block, err := models.StringToInt(tx.BlockNumber)
+		if err != nil {
+			return progress, err
+		}
+		if block < q.StartBlock || (q.EndBlock > 0 && block > q.EndBlock) {
+			continue
+		}
+		matching = append(matching, tx)
+	}
+
+	pageSize := f.PageSize
+	if pageSize <= 0 {
+		pageSize = len(matching)
+	}
+
+	for start := 0; start < len(matching); start += pageSize {
+		if err := ctx.Err(); err != nil {
+			return progress, err
+		}
+		if f.FailAfter > 0 && progress.Pages >= f.FailAfter {
+			return progress, f.Err
+		}
+
+		end := start + pageSize
+		if end > len(matching) {
+			end = len(matching)
+		}
+		page := matching[start:end]
+
+		if err := fn(page); err != nil {
+			return progress, err
+		}
+
+		progress.Pages++
+		progress.Transfers += len(page)
+		progress.LastBlock, _ = models.StringToInt(page[len(page)-1].BlockNumber)
+	}
+
+	return progress, nil
+}
+
+// TokenBalance returns the balance of contract from Balances. The fake keeps
+// a single balance per token, whatever the address.
+func (f *Fake) TokenBalance(ctx context.Context, contract, address string) (*big.Int, error) {
+	balance, ok := f.Balances[strings.ToLower(contract)]
+	if !ok {
+		return nil, fmt.Errorf("no balance of %s", contract)
+	}
+	return new(big.Int).Set(balance), nil
+}
@@ -0,0 +1,67 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"ethcrawler/pkg/models"
+)
+
+func TestFakeReplaysPages(t *testing.T) {
+	const address = "0x1111111111111111111111111111111111111111"
+	var transfers []models.ERC20Transfer
+	for block := 100; block < 110; block++ {
+		transfers = append(transfers, models.ERC20Transfer{
+			From:            "0x2222222222222222222222222222222222222222",
+			To:              address,
+			BlockNumber:     strconv.Itoa(block),
+			ContractAddress: "0xdac17f958d2ee523a2206206994597c13d831ec7",
+		})
+	}
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name      string
+		fake      *Fake
+		query     models.Query
+		pages     []int // Transfers per delivered page
+		lastBlock int
+		err       error
+	}{
+		{"one page", NewFake(0, transfers...), models.Query{Address: address}, []int{10}, 109, nil},
+		{"pages", NewFake(4, transfers...), models.Query{Address: address}, []int{4, 4, 2}, 109, nil},
+		{"block range", NewFake(4, transfers...), models.Query{Address: address, StartBlock: 103, EndBlock: 105}, []int{3}, 105, nil},
+		{"other address", NewFake(4, transfers...), models.Query{Address: "0x3333333333333333333333333333333333333333"}, nil, 0, nil},
+		{"other contract", NewFake(4, transfers...), models.Query{Address: address, Contract: "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48"}, nil, 0, nil},
+		{"failure", &Fake{Transfers: transfers, PageSize: 3, FailAfter: 2, Err: errStop}, models.Query{Address: address}, []int{3, 3}, 105, errStop},
+	}
+
+	for _, tt := range tests {
+		var pages []int
+		progress, err := tt.fake.StreamTokenTransfers(context.Background(), tt.query, func(page []models.ERC20Transfer) error {
+			pages = append(pages, len(page))
+			return nil
+		})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+		}
+		if len(pages) != len(tt.pages) {
+			t.Errorf("%s: pages %v, want %v", tt.name, pages, tt.pages)
+			continue
+		}
+		for i := range pages {
+			if pages[i] != tt.pages[i] {
+				t.Errorf("%s: pages %v, want %v", tt.name, pages, tt.pages)
+				break
+			}
+		}
+		if progress.Pages != len(tt.pages) || progress.LastBlock != tt.lastBlock {
+			t.Errorf("%s: progress %+v, want %d pages up to block %d", tt.name, progress, len(tt.pages), tt.lastBlock)
+		}
+		if queries := tt.fake.Queries(); len(queries) != 1 || queries[0] != tt.query {
+			t.Errorf("%s: queries %v, want [%v]", tt.name, queries, tt.query)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/blockscout"
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/rpc"
+)
+
+// DefaultName is the provider used when none is configured
+const DefaultName = "etherscan"
+
+// TransferSource fetches token transfers of an address within a block range
+// and passes them to fn page by page, in block order
+type TransferSource interface {
+	StreamTokenTransfers(ctx context.Context, q models.Query, fn func([]models.ERC20Transfer) error) (models.Progress, error)
+}
+
+// BalanceReader is implemented by sources that can read the current token
+// balance of an address, used to check reconstructed balances
+type BalanceReader interface {
+	TokenBalance(ctx context.Context, contract, address string) (*big.Int, error)
+}
+
+// BlockFinder is implemented by sources that can map a point in time to a
+// block number, used for date ranges
+type BlockFinder interface {
+	BlockNumberByTime(ctx context.Context, t time.Time, after bool) (int, error)
+}
+
+// Config carries the settings a provider may need
+type Config struct {
+	Chain     chains.Chain
+	APIKey    string
+	Contract  string
+	RPCURL    string
+	RateLimit float64 // Calls per second, 0 means the provider default
+
+	BlockscoutURL    string // Overrides the Blockscout endpoint of the chain preset
+	BlockscoutAPIKey string
+
+	Log io.Writer // Progress and retry messages, nil keeps the provider default
+}
+
+// Provider describes a named transfer source
+type Provider struct {
+	Name        string
+	Description string
+	NeedsAPIKey bool // The Etherscan API key must be configured
+	New         func(cfg Config) (TransferSource, error)
+}
+
+// providers holds all registered providers by name
+var providers = make(map[string]Provider)
+
+// Register makes a provider available by its name. It panics if the name is
+// already taken, like database/sql drivers do.
+func Register(p Provider) {
+	name := strings.ToLower(p.Name)
+	if _, dup := providers[name]; dup {
+		panic("source: Register called twice for provider " + name)
+	}
+	providers[name] = p
+}
+
+// Lookup returns the provider registered under name
+func Lookup(name string) (Provider, error) {
+	p, ok := providers[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return Provider{}, fmt.Errorf("unknown transfer source %q, available sources: %s",
+			name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// New creates the transfer source registered under name
+func New(name string, cfg Config) (TransferSource, error) {
+	p, err := Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.New(cfg)
+}
+
+// Names returns the names of all registered providers in alphabetical order
+func Names() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(Provider{
+		Name:        "etherscan",
+		Description: "Etherscan V2 API",
+		NeedsAPIKey: true,
+		New: func(cfg Config) (TransferSource, error) {
+			client := etherscan.NewClient(cfg.APIKey, cfg.Contract)
+			if cfg.Chain.APIURL != "" {
+				client.BaseURL = cfg.Chain.APIURL
+				client.ChainID = cfg.Chain.ChainID
+			}
+			if cfg.RateLimit > 0 {
+				client.Limiter = etherscan.NewRateLimiter(cfg.RateLimit)
+			}
+			if cfg.Log != nil {
+				client.Log = cfg.Log
+			}
+			return client, nil
+		},
+	})
+
+	Register(Provider{
+		Name:        "rpc",
+		Description: "JSON-RPC node (eth_getLogs)",
+		New: func(cfg Config) (TransferSource, error) {
+			if cfg.RPCURL == "" {
+				return nil, fmt.Errorf("JSON-RPC node URL is not set, configure RPC_URL")
+			}
+			client := rpc.NewClient(cfg.RPCURL, cfg.Contract)
+			if cfg.RateLimit > 0 {
+				client.Limiter = etherscan.NewRateLimiter(cfg.RateLimit)
+			}
+			if cfg.Log != nil {
+				client.Log = cfg.Log
+			}
+			return client, nil
+		},
+	})
+
+	Register(Provider{
+		Name:        "blockscout",
+		Description: "Blockscout explorer API",
+		New: func(cfg Config) (TransferSource, error) {
+			url := cfg.BlockscoutURL
+			if url == "" {
+				url = cfg.Chain.BlockscoutURL
+			}
+			if url == "" {
+				return nil, fmt.Errorf("no Blockscout instance known for %s, configure BLOCKSCOUT_URL", cfg.Chain.Title)
+			}
+			client := blockscout.NewClient(url, cfg.Contract)
+			client.ApiKey = cfg.BlockscoutAPIKey
+			if cfg.RateLimit > 0 {
+				client.Limiter = etherscan.NewRateLimiter(cfg.RateLimit)
+			}
+			if cfg.Log != nil {
+				client.Log = cfg.Log
+			}
+			return client, nil
+		},
+	})
+}
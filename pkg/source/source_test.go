@@ -0,0 +1,125 @@
+package source
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/blockscout"
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/rpc"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"etherscan", "etherscan", false},
+		{" RPC ", "rpc", false},
+		{"Blockscout", "blockscout", false},
+		{"", "", true},
+		{"covalent", "", true},
+	}
+
+	for _, tt := range tests {
+		p, err := Lookup(tt.name)
+		if tt.wantErr {
+			// The error lists the sources to choose from
+			if err == nil || !strings.Contains(err.Error(), strings.Join(Names(), ", ")) {
+				t.Errorf("Lookup(%q) error = %v, want an unknown source error listing %v", tt.name, err, Names())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Lookup(%q): %v", tt.name, err)
+			continue
+		}
+		if p.Name != tt.want {
+			t.Errorf("Lookup(%q) = %s, want %s", tt.name, p.Name, tt.want)
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	names := Names()
+	for _, name := range []string{"blockscout", "etherscan", "rpc"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("Names() = %v, missing %s", names, name)
+		}
+	}
+	if !slices.IsSorted(names) {
+		t.Errorf("Names() = %v, want sorted names", names)
+	}
+}
+
+func TestRegisterTwicePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering etherscan again did not panic")
+		}
+	}()
+	Register(Provider{Name: "Etherscan"})
+}
+
+func TestNewReportsMissingSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"rpc", Config{Chain: chains.Ethereum}, "RPC_URL"},
+		{"blockscout", Config{Chain: chains.Custom("devnet")}, "BLOCKSCOUT_URL"},
+		{"unknown", Config{Chain: chains.Ethereum}, "unknown transfer source"},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.name, tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("New(%s) error = %v, want one mentioning %s", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestProviderRateLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		want float64
+	}{
+		{"etherscan", 0, etherscan.DefaultCallsPerSecond},
+		{"etherscan", 2, 2},
+		{"rpc", 0, rpc.DefaultCallsPerSecond},
+		{"rpc", 25, 25},
+		{"blockscout", 0, blockscout.DefaultCallsPerSecond},
+		{"blockscout", 1, 1},
+	}
+
+	for _, tt := range tests {
+		src, err := New(tt.name, Config{
+			Chain:         chains.Ethereum,
+			RPCURL:        "http://localhost:8545",
+			BlockscoutURL: "http://localhost:4000/api",
+			RateLimit:     tt.rate,
+		})
+		if err != nil {
+			t.Fatalf("New(%s): %v", tt.name, err)
+		}
+
+		var limiter *etherscan.RateLimiter
+		switch client := src.(type) {
+		case *etherscan.Client:
+			limiter = client.Limiter
+		case *rpc.Client:
+			limiter = client.Limiter
+		case *blockscout.Client:
+			limiter = client.Limiter
+		default:
+			t.Fatalf("New(%s) returned %T", tt.name, src)
+		}
+		if got := limiter.CallsPerSecond(); got != tt.want {
+			t.Errorf("%s with -rate %g: %g calls per second, want %g", tt.name, tt.rate, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"ethcrawler/pkg/models"
+)
+
+const journalFileName = "journal.ndjson"
+
+// JournalPage is one completed page of a crawl that has not been committed
+// to the store yet
+type JournalPage struct {
+	Page      int                    `json:"page"`      // Logical page number, counting from 1
+	LastBlock int                    `json:"lastBlock"` // Block of the last transfer on the page
+	Transfers []models.ERC20Transfer `json:"transfers"`
+}
+
+// Journal records the pages of a running crawl, one JSON line per page, so
+// that an interrupted crawl can be resumed
+type Journal struct {
+	f *os.File
+}
+
+// CreateJournal starts an empty journal for key, replacing any previous one
+func (s *Store) CreateJournal(key Key) (*Journal, error) {
+	dir := s.path(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	f, err := os.Create(filepath.Join(dir, journalFileName))
+	if err != nil {
+		return nil, fmt.Errorf("error creating journal: %v", err)
+	}
+
+	return &Journal{f: f}, nil
+}
+
+// OpenJournal reads the journal of key and reopens it for appending. The
+// boolean is false if there is no journal to resume.
+func (s *Store) OpenJournal(key Key) (*Journal, []JournalPage, bool, error) {
+	path := filepath.Join(s.path(key), journalFileName)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error opening journal: %v", err)
+	}
+
+	// A torn last line means the crash happened while writing that page
+	var pages []JournalPage
+	err = eachLine(f, "journal", func(page JournalPage) error {
+		pages = append(pages, page)
+		return nil
+	})
+	if err != nil {
+		f.Close()
+		return nil, nil, false, err
+	}
+
+	if err := repairTail(f); err != nil {
+		f.Close()
+		return nil, nil, false, fmt.Errorf("error repairing journal: %v", err)
+	}
+
+	return &Journal{f: f}, pages, true, nil
+}
+
+// HasJournal reports whether key has a journal left by an interrupted crawl
+func (s *Store) HasJournal(key Key) bool {
+	_, err := os.Stat(filepath.Join(s.path(key), journalFileName))
+	return err == nil
+}
+
+// Append writes a completed page to the journal and flushes it to disk
+func (j *Journal) Append(page JournalPage) error {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return fmt.Errorf("error encoding journal page: %v", err)
+	}
+
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing journal: %v", err)
+	}
+
+	return j.f.Sync()
+}
+
+// Close closes the journal file, keeping it on disk
+func (j *Journal) Close() error {
+	return j.f.Close()
+}
+
+// RemoveJournal deletes the journal of key once its pages are committed
+func (s *Store) RemoveJournal(key Key) error {
+	err := os.Remove(filepath.Join(s.path(key), journalFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing journal: %v", err)
+	}
+	return nil
+}
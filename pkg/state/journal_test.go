@@ -0,0 +1,128 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"ethcrawler/pkg/models"
+)
+
+// appendPages starts a journal for key and writes the pages to it
+func appendPages(t *testing.T, s *Store, pages ...JournalPage) {
+	t.Helper()
+	j, err := s.CreateJournal(testKey)
+	if err != nil {
+		t.Fatalf("CreateJournal: %v", err)
+	}
+	defer j.Close()
+	for _, page := range pages {
+		if err := j.Append(page); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+}
+
+// appendRaw adds text to the journal file as a crash would leave it
+func appendRaw(t *testing.T, s *Store, text string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(s.path(testKey), journalFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalResume(t *testing.T) {
+	s := NewStore(t.TempDir())
+	if s.HasJournal(testKey) {
+		t.Fatalf("new key has a journal")
+	}
+	if _, _, found, err := s.OpenJournal(testKey); found || err != nil {
+		t.Fatalf("OpenJournal of a new key = found %v, %v", found, err)
+	}
+
+	appendPages(t, s,
+		JournalPage{Page: 1, LastBlock: 100, Transfers: []models.ERC20Transfer{transfer("100", "0")}},
+		JournalPage{Page: 2, LastBlock: 200, Transfers: []models.ERC20Transfer{transfer("200", "0"), transfer("200", "1")}},
+	)
+	if !s.HasJournal(testKey) {
+		t.Fatalf("journal is gone after Close")
+	}
+
+	j, pages, found, err := s.OpenJournal(testKey)
+	if err != nil || !found {
+		t.Fatalf("OpenJournal = found %v, %v", found, err)
+	}
+	if len(pages) != 2 || pages[1].LastBlock != 200 || len(pages[1].Transfers) != 2 {
+		t.Fatalf("resumed pages %+v, want pages 1 and 2 up to block 200", pages)
+	}
+
+	// The reopened journal appends after the resumed pages
+	if err := j.Append(JournalPage{Page: 3, LastBlock: 300}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	j.Close()
+	j, pages, _, err = s.OpenJournal(testKey)
+	if err != nil {
+		t.Fatalf("OpenJournal: %v", err)
+	}
+	j.Close()
+	if len(pages) != 3 || pages[2].Page != 3 {
+		t.Errorf("pages after appending %+v, want 3", pages)
+	}
+
+	if err := s.RemoveJournal(testKey); err != nil {
+		t.Fatalf("RemoveJournal: %v", err)
+	}
+	if s.HasJournal(testKey) {
+		t.Errorf("journal survived RemoveJournal")
+	}
+	if err := s.RemoveJournal(testKey); err != nil {
+		t.Errorf("removing a missing journal: %v", err)
+	}
+}
+
+func TestJournalTornPage(t *testing.T) {
+	s := NewStore(t.TempDir())
+	appendPages(t, s, JournalPage{Page: 1, LastBlock: 100})
+	appendRaw(t, s, `{"page":2,"lastBlock":200,"transfers":[{"hash":"0x`)
+
+	j, pages, _, err := s.OpenJournal(testKey)
+	if err != nil {
+		t.Fatalf("OpenJournal with a torn page: %v", err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("resumed %d pages, want the complete one", len(pages))
+	}
+
+	// The torn page is cut off, so the next page starts a line of its own
+	if err := j.Append(JournalPage{Page: 2, LastBlock: 200}); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	j.Close()
+	j, pages, _, err = s.OpenJournal(testKey)
+	if err != nil {
+		t.Fatalf("OpenJournal after resuming: %v", err)
+	}
+	j.Close()
+	if len(pages) != 2 || pages[1].LastBlock != 200 {
+		t.Errorf("pages after resuming %+v, want 1 and 2", pages)
+	}
+}
+
+func TestJournalCorruptPage(t *testing.T) {
+	s := NewStore(t.TempDir())
+	appendPages(t, s, JournalPage{Page: 1, LastBlock: 100})
+	appendRaw(t, s, "not a page\n")
+	appendRaw(t, s, `{"page":3,"lastBlock":300}`+"\n")
+
+	_, _, _, err := s.OpenJournal(testKey)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("OpenJournal with a corrupt line 2 = %v, want an error naming the line", err)
+	}
+}
@@ -0,0 +1,254 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/models"
+)
+
+const (
+	transfersFileName  = "transfers.ndjson"
+	checkpointFileName = "checkpoint.json"
+)
+
+// Key identifies synced data: transfers of one token for one address on one
+// chain, either the full history or the history from StartBlock on
+type Key struct {
+	Chain      string
+	Contract   string
+	Address    string
+	StartBlock int // First synced block, 0 for the full history
+}
+
+// Checkpoint records how far the data of a key has been synced
+type Checkpoint struct {
+	Chain     string    `json:"chain"`
+	Contract  string    `json:"contract"`
+	Address   string    `json:"address"`
+	From      int       `json:"from,omitempty"` // First stored block, 0 for the full history
+	SyncedTo  int       `json:"syncedTo"`       // All transfers up to this block are stored
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Store keeps synced transfers and checkpoints on disk, one directory per key
+type Store struct {
+	Dir string
+}
+
+// NewStore creates a store rooted at dir
+func NewStore(dir string) *Store {
+	return &Store{Dir: dir}
+}
+
+// path returns the directory holding the data of key. Partial histories live
+// next to the full one in from-<block> subdirectories.
+func (s *Store) path(key Key) string {
+	dir := filepath.Join(s.Dir,
+		strings.ToLower(key.Chain),
+		strings.ToLower(key.Contract),
+		strings.ToLower(key.Address))
+	if key.StartBlock > 0 {
+		dir = filepath.Join(dir, fmt.Sprintf("from-%d", key.StartBlock))
+	}
+	return dir
+}
+
+// Checkpoint returns the checkpoint of key. The boolean is false if the key
+// has never been synced.
+func (s *Store) Checkpoint(key Key) (Checkpoint, bool, error) {
+	var cp Checkpoint
+
+	data, err := os.ReadFile(filepath.Join(s.path(key), checkpointFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, false, nil
+	}
+	if err != nil {
+		return cp, false, fmt.Errorf("error reading checkpoint: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, false, fmt.Errorf("error parsing checkpoint: %v", err)
+	}
+
+	return cp, true, nil
+}
+
+// SaveCheckpoint atomically replaces the checkpoint of key
+func (s *Store) SaveCheckpoint(key Key, cp Checkpoint) error {
+	dir := s.path(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	cp.Chain = key.Chain
+	cp.Contract = strings.ToLower(key.Contract)
+	cp.Address = strings.ToLower(key.Address)
+	cp.From = key.StartBlock
+	cp.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding checkpoint: %v", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn checkpoint
+	tmp := filepath.Join(dir, checkpointFileName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, checkpointFileName)); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+
+	return nil
+}
+
+// Transfers returns all stored transfers of key in the order they were stored
+func (s *Store) Transfers(key Key) ([]models.ERC20Transfer, error) {
+	var transfers []models.ERC20Transfer
+	err := s.EachTransfer(key, func(tx models.ERC20Transfer) error {
+		transfers = append(transfers, tx)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// EachTransfer calls fn for every stored transfer of key in the order they
+// were stored, reading one line at a time. It stops at the first error of fn
+// and returns it.
+func (s *Store) EachTransfer(key Key, fn func(models.ERC20Transfer) error) error {
+	f, err := os.Open(filepath.Join(s.path(key), transfersFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening stored transfers: %v", err)
+	}
+	defer f.Close()
+
+	return eachLine(f, "stored transfers", fn)
+}
+
+// AppendTransfers adds transfers to the stored data of key and flushes them to disk
+func (s *Store) AppendTransfers(key Key, transfers []models.ERC20Transfer) error {
+	dir := s.path(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, transfersFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening stored transfers: %v", err)
+	}
+	defer f.Close()
+
+	// Finish or drop a torn line so it doesn't swallow the next record
+	if err := repairTail(f); err != nil {
+		return fmt.Errorf("error repairing stored transfers: %v", err)
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, tx := range transfers {
+		if err := enc.Encode(tx); err != nil {
+			return fmt.Errorf("error writing stored transfers: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing stored transfers: %v", err)
+	}
+
+	return f.Sync()
+}
+
+// Reset removes all stored data and the checkpoint of key. Resetting the full
+// history also drops the partial histories of the address.
+func (s *Store) Reset(key Key) error {
+	if err := os.RemoveAll(s.path(key)); err != nil {
+		return fmt.Errorf("error removing stored state: %v", err)
+	}
+	return nil
+}
+
+// repairTail prepares an NDJSON file for appending. A last line without a
+// newline was torn by a crash in the middle of a write: it is cut off, or
+// gets its newline if it is complete.
+func repairTail(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Look for the end of the last complete line from the end of the file
+	size := info.Size()
+	start := size
+	buf := make([]byte, 4096)
+	for start > 0 {
+		n := min(int64(len(buf)), start)
+		if _, err := f.ReadAt(buf[:n], start-n); err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			start += int64(i) + 1 - n
+			break
+		}
+		start -= n
+	}
+	if start == size {
+		return nil
+	}
+
+	tail := make([]byte, size-start)
+	if _, err := f.ReadAt(tail, start); err != nil {
+		return err
+	}
+	if json.Valid(tail) {
+		_, err = f.WriteString("\n")
+		return err
+	}
+	return f.Truncate(start)
+}
+
+// eachLine decodes NDJSON records of type T one line at a time and calls fn
+// for each, stopping at the first error of fn. A line that doesn't decode is
+// an error with its line number, unless it is the last one and has no
+// newline: that line was torn by a crash in the middle of a write and is
+// skipped.
+func eachLine[T any](r io.Reader, what string, fn func(T) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading %s: %v", what, err)
+		}
+		torn := err == io.EOF
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var record T
+			if err := json.Unmarshal(line, &record); err != nil {
+				if torn {
+					return nil
+				}
+				return fmt.Errorf("error reading %s: line %d is corrupt: %v", what, n, err)
+			}
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+		if torn {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/models"
+)
+
+var testKey = Key{
+	Chain:    "ethereum",
+	Contract: "0xDAC17F958D2EE523A2206206994597C13D831EC7",
+	Address:  "0x1111111111111111111111111111111111111111",
+}
+
+func transfer(block, logIndex string) models.ERC20Transfer {
+	return models.ERC20Transfer{
+		Hash:        "0x" + block + logIndex,
+		BlockNumber: block,
+		LogIndex:    logIndex,
+		From:        "0x2222222222222222222222222222222222222222",
+		To:          testKey.Address,
+		Value:       "100",
+	}
+}
+
+// hashes reads the hashes of the stored transfers of key
+func hashes(t *testing.T, s *Store, key Key) []string {
+	t.Helper()
+	transfers, err := s.Transfers(key)
+	if err != nil {
+		t.Fatalf("Transfers: %v", err)
+	}
+	var got []string
+	for _, tx := range transfers {
+		got = append(got, tx.Hash)
+	}
+	return got
+}
+
+func TestCheckpoint(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	if _, found, err := s.Checkpoint(testKey); err != nil || found {
+		t.Fatalf("Checkpoint of a new key = found %v, %v, want not found", found, err)
+	}
+
+	before := time.Now().UTC()
+	if err := s.SaveCheckpoint(testKey, Checkpoint{SyncedTo: 500}); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+	cp, found, err := s.Checkpoint(testKey)
+	if err != nil || !found {
+		t.Fatalf("Checkpoint = found %v, %v", found, err)
+	}
+	if cp.SyncedTo != 500 || cp.Chain != "ethereum" || cp.From != 0 {
+		t.Errorf("checkpoint = %+v, want synced to 500 on ethereum from the start", cp)
+	}
+	if cp.Contract != strings.ToLower(testKey.Contract) || cp.Address != testKey.Address {
+		t.Errorf("checkpoint of %s/%s, want lower case key", cp.Contract, cp.Address)
+	}
+	if cp.UpdatedAt.Before(before.Truncate(time.Second)) {
+		t.Errorf("UpdatedAt = %s, want at least %s", cp.UpdatedAt, before)
+	}
+
+	// A partial history is kept apart from the full one
+	partial := testKey
+	partial.StartBlock = 300
+	if _, found, _ := s.Checkpoint(partial); found {
+		t.Errorf("partial history found the checkpoint of the full one")
+	}
+	if err := s.SaveCheckpoint(partial, Checkpoint{SyncedTo: 400}); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+	if cp, _, _ := s.Checkpoint(partial); cp.From != 300 || cp.SyncedTo != 400 {
+		t.Errorf("partial checkpoint = %+v, want from 300 synced to 400", cp)
+	}
+
+	// Resetting the full history drops the partial one as well
+	if err := s.Reset(testKey); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	for _, key := range []Key{testKey, partial} {
+		if _, found, _ := s.Checkpoint(key); found {
+			t.Errorf("checkpoint from block %d survived Reset", key.StartBlock)
+		}
+	}
+}
+
+func TestAppendTransfers(t *testing.T) {
+	s := NewStore(t.TempDir())
+
+	if got := hashes(t, s, testKey); len(got) != 0 {
+		t.Fatalf("new key has transfers %v", got)
+	}
+	if err := s.AppendTransfers(testKey, []models.ERC20Transfer{transfer("100", "0"), transfer("100", "1")}); err != nil {
+		t.Fatalf("AppendTransfers: %v", err)
+	}
+	if err := s.AppendTransfers(testKey, []models.ERC20Transfer{transfer("200", "0")}); err != nil {
+		t.Fatalf("AppendTransfers: %v", err)
+	}
+
+	want := []string{"0x1000", "0x1001", "0x2000"}
+	if got := hashes(t, s, testKey); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("stored transfers %v, want %v", got, want)
+	}
+}
+
+func TestTornTransfersTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+		want []string
+	}{
+		{"torn line", `{"hash":"0x30`, []string{"0x1000", "0x2000"}},
+		{"complete line without newline", `{"hash":"0x3000","blockNumber":"300"}`, []string{"0x1000", "0x2000", "0x3000"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore(t.TempDir())
+			if err := s.AppendTransfers(testKey, []models.ERC20Transfer{transfer("100", "0"), transfer("200", "0")}); err != nil {
+				t.Fatalf("AppendTransfers: %v", err)
+			}
+
+			// A crash in the middle of a write leaves the last line unfinished
+			path := filepath.Join(s.path(testKey), transfersFileName)
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			if got := hashes(t, s, testKey); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("transfers with a torn tail %v, want %v", got, tt.want)
+			}
+
+			// The next append repairs the tail instead of burying it mid-file
+			if err := s.AppendTransfers(testKey, []models.ERC20Transfer{transfer("400", "0")}); err != nil {
+				t.Fatalf("AppendTransfers: %v", err)
+			}
+			want := append(tt.want, "0x4000")
+			if got := hashes(t, s, testKey); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("transfers after the next append %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCorruptTransfersLine(t *testing.T) {
+	s := NewStore(t.TempDir())
+	if err := s.AppendTransfers(testKey, []models.ERC20Transfer{transfer("100", "0")}); err != nil {
+		t.Fatalf("AppendTransfers: %v", err)
+	}
+
+	path := filepath.Join(s.path(testKey), transfersFileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("{\"hash\":\"0x20\x00garbage\n")
+	f.Close()
+	if err := s.AppendTransfers(testKey, []models.ERC20Transfer{transfer("300", "0")}); err != nil {
+		t.Fatalf("AppendTransfers: %v", err)
+	}
+
+	// Only the last line may be torn, anything else is lost data
+	_, err = s.Transfers(testKey)
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Transfers with a corrupt line 2 = %v, want an error naming the line", err)
+	}
+}
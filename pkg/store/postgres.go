@@ -0,0 +1,302 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// copyBatchSize is the number of transfers sent per COPY
+const copyBatchSize = 5000
+
+// migrationLock is the advisory lock key serializing migrations of
+// concurrent writers
+const migrationLock = 0x65746863 // "ethc"
+
+// postgresMigrations[i] upgrades the schema from version i to i+1. Applied
+// versions are recorded in schema_migrations. Transfers without log index
+// have a NULL log_index and are keyed by their content and occurrence
+// instead, since the negative log indexes depend on the address queried.
+var postgresMigrations = []string{
+	`CREATE TABLE tokens (
+		chain    text NOT NULL,
+		contract text NOT NULL,
+		symbol   text NOT NULL DEFAULT '',
+		name     text NOT NULL DEFAULT '',
+		decimals integer NOT NULL DEFAULT 0,
+		PRIMARY KEY (chain, contract)
+	);
+
+	CREATE TABLE addresses (
+		chain      text NOT NULL,
+		address    text NOT NULL,
+		label      text NOT NULL DEFAULT '',
+		updated_at timestamptz NOT NULL,
+		PRIMARY KEY (chain, address)
+	);
+
+	CREATE TABLE transfers (
+		chain        text NOT NULL,
+		hash         text NOT NULL,
+		log_index    integer,
+		occurrence   integer NOT NULL DEFAULT 0,
+		block        bigint NOT NULL,
+		time         timestamptz NOT NULL,
+		contract     text NOT NULL,
+		from_address text NOT NULL,
+		to_address   text NOT NULL,
+		value        numeric(78, 0) NOT NULL,
+		amount       numeric NOT NULL
+	);
+	CREATE UNIQUE INDEX transfers_log ON transfers (chain, hash, log_index);
+	CREATE UNIQUE INDEX transfers_unindexed ON transfers (chain, hash, contract, from_address, to_address, value, occurrence)
+		WHERE log_index IS NULL;
+	CREATE INDEX transfers_from_block ON transfers (chain, from_address, block);
+	CREATE INDEX transfers_to_block ON transfers (chain, to_address, block);
+	CREATE INDEX transfers_contract_block ON transfers (chain, contract, block);
+
+	CREATE TABLE checkpoints (
+		chain      text NOT NULL,
+		contract   text NOT NULL,
+		address    text NOT NULL,
+		from_block bigint NOT NULL DEFAULT 0,
+		synced_to  bigint NOT NULL,
+		updated_at timestamptz NOT NULL,
+		PRIMARY KEY (chain, contract, address, from_block)
+	);
+
+	CREATE VIEW address_transfers AS
+	SELECT a.chain, a.address, a.label, t.block, t.log_index, t.time, t.hash,
+		t.from_address, t.to_address,
+		CASE
+			WHEN t.from_address = a.address AND t.to_address = a.address THEN 'self'
+			WHEN t.to_address = a.address THEN 'in'
+			ELSE 'out'
+		END AS direction,
+		t.contract, k.symbol AS token, t.value, t.amount, t.occurrence
+	FROM addresses a
+	JOIN transfers t ON t.chain = a.chain AND (t.from_address = a.address OR t.to_address = a.address)
+	LEFT JOIN tokens k ON k.chain = t.chain AND k.contract = t.contract;`,
+}
+
+// stagingColumns are the columns of the temporary table COPY writes to
+var stagingColumns = []string{
+	"chain", "hash", "log_index", "occurrence", "block", "time", "contract", "from_address", "to_address", "value", "amount",
+}
+
+// Postgres is a PostgreSQL database of synced transfers
+type Postgres struct {
+	conn *pgx.Conn
+}
+
+// OpenPostgres connects to the database of dsn and migrates its schema. The
+// DSN is a postgres:// URL or a list of key=value settings.
+func OpenPostgres(ctx context.Context, dsn string) (*Postgres, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to PostgreSQL: %v", err)
+	}
+
+	p := &Postgres{conn: conn}
+	if err := p.migrate(ctx); err != nil {
+		conn.Close(ctx)
+		return nil, err
+	}
+	return p, nil
+}
+
+// PostgresTarget describes the database of dsn without its password, for
+// messages
+func PostgresTarget(dsn string) (string, error) {
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return "", fmt.Errorf("invalid PostgreSQL DSN: %v", err)
+	}
+	return fmt.Sprintf("postgres://%s@%s:%d/%s", cfg.User, cfg.Host, cfg.Port, cfg.Database), nil
+}
+
+// Close closes the connection
+func (p *Postgres) Close(ctx context.Context) error {
+	return p.conn.Close(ctx)
+}
+
+// migrate applies the migrations the database has not seen yet. An advisory
+// lock keeps concurrent writers from applying the same migration twice.
+func (p *Postgres) migrate(ctx context.Context) error {
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error migrating database: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return fmt.Errorf("error locking migrations: %v", err)
+	}
+	_, err = tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    integer PRIMARY KEY,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations: %v", err)
+	}
+
+	var version int
+	if err := tx.QueryRow(ctx, "SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	if version > len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, len(postgresMigrations))
+	}
+
+	for ; version < len(postgresMigrations); version++ {
+		if _, err := tx.Exec(ctx, postgresMigrations[version]); err != nil {
+			return fmt.Errorf("error migrating database to version %d: %v", version+1, err)
+		}
+		if _, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version+1); err != nil {
+			return fmt.Errorf("error migrating database to version %d: %v", version+1, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error migrating database: %v", err)
+	}
+	return nil
+}
+
+// Save upserts the address, its tokens, transfers and checkpoint in one
+// transaction, so saving the same data again changes nothing. Transfers are
+// copied in batches to a temporary table and merged from there. It returns
+// the number of transfers written.
+func (p *Postgres) Save(ctx context.Context, s Snapshot) (int, error) {
+	chain := strings.ToLower(s.Chain)
+	address := strings.ToLower(s.Address)
+	now := time.Now().UTC()
+
+	tx, err := p.conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// An empty label keeps the label given by an earlier run
+	_, err = tx.Exec(ctx, `INSERT INTO addresses (chain, address, label, updated_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chain, address) DO UPDATE SET
+			label = CASE WHEN excluded.label = '' THEN addresses.label ELSE excluded.label END,
+			updated_at = excluded.updated_at`,
+		chain, address, s.Label, now)
+	if err != nil {
+		return 0, fmt.Errorf("error saving address: %v", err)
+	}
+
+	if cp := s.Checkpoint; cp != nil {
+		_, err = tx.Exec(ctx, `INSERT INTO checkpoints (chain, contract, address, from_block, synced_to, updated_at) VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (chain, contract, address, from_block) DO UPDATE SET
+				synced_to = excluded.synced_to,
+				updated_at = excluded.updated_at`,
+			chain, strings.ToLower(cp.Contract), address, cp.FromBlock, cp.SyncedTo, cp.UpdatedAt.UTC())
+		if err != nil {
+			return 0, fmt.Errorf("error saving checkpoint: %v", err)
+		}
+	}
+
+	tokens := &pgx.Batch{}
+	seenTokens := make(map[string]bool)
+	for _, t := range s.Transfers {
+		contract := strings.ToLower(t.Contract)
+		if seenTokens[contract] {
+			continue
+		}
+		seenTokens[contract] = true
+		tokens.Queue(`INSERT INTO tokens (chain, contract, symbol, name, decimals) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (chain, contract) DO UPDATE SET
+				symbol = excluded.symbol,
+				name = excluded.name,
+				decimals = excluded.decimals`,
+			chain, contract, t.TokenSymbol, t.TokenName, t.Decimals)
+	}
+	if tokens.Len() > 0 {
+		if err := tx.SendBatch(ctx, tokens).Close(); err != nil {
+			return 0, fmt.Errorf("error saving tokens: %v", err)
+		}
+	}
+
+	// Amounts go through text to keep every digit
+	_, err = tx.Exec(ctx, `CREATE TEMP TABLE staged_transfers (
+		chain text, hash text, log_index integer, occurrence integer, block bigint, time timestamptz,
+		contract text, from_address text, to_address text, value text, amount text
+	) ON COMMIT DROP`)
+	if err != nil {
+		return 0, fmt.Errorf("error creating staging table: %v", err)
+	}
+
+	occurrence := occurrences(s.Transfers)
+	for start := 0; start < len(s.Transfers); start += copyBatchSize {
+		end := min(start+copyBatchSize, len(s.Transfers))
+		if err := stageTransfers(ctx, tx, chain, s.Transfers[start:end], occurrence[start:end]); err != nil {
+			return 0, err
+		}
+	}
+
+	// DISTINCT ON guards against a transfer listed twice, which ON CONFLICT
+	// cannot update twice in one statement. Transfers with a log index
+	// conflict on it, the others on their content.
+	_, err = tx.Exec(ctx, `INSERT INTO transfers (chain, hash, log_index, occurrence, block, time, contract, from_address, to_address, value, amount)
+		SELECT DISTINCT ON (chain, hash, log_index)
+			chain, hash, log_index, occurrence, block, time, contract, from_address, to_address, value::numeric, amount::numeric
+		FROM staged_transfers
+		WHERE log_index IS NOT NULL
+		ON CONFLICT (chain, hash, log_index) DO UPDATE SET
+			block = excluded.block,
+			time = excluded.time,
+			contract = excluded.contract,
+			from_address = excluded.from_address,
+			to_address = excluded.to_address,
+			value = excluded.value,
+			amount = excluded.amount`)
+	if err != nil {
+		return 0, fmt.Errorf("error saving transfers: %v", err)
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO transfers (chain, hash, log_index, occurrence, block, time, contract, from_address, to_address, value, amount)
+		SELECT DISTINCT ON (chain, hash, contract, from_address, to_address, value::numeric, occurrence)
+			chain, hash, log_index, occurrence, block, time, contract, from_address, to_address, value::numeric, amount::numeric
+		FROM staged_transfers
+		WHERE log_index IS NULL
+		ON CONFLICT (chain, hash, contract, from_address, to_address, value, occurrence) WHERE log_index IS NULL DO UPDATE SET
+			block = excluded.block,
+			time = excluded.time,
+			amount = excluded.amount`)
+	if err != nil {
+		return 0, fmt.Errorf("error saving transfers without log index: %v", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return len(s.Transfers), nil
+}
+
+// stageTransfers copies a batch of transfers and their occurrences to the
+// staging table
+func stageTransfers(ctx context.Context, tx pgx.Tx, chain string, transfers []models.FormattedTransfer, occurrence []int) error {
+	rows := make([][]any, len(transfers))
+	for i, t := range transfers {
+		var logIndex any
+		if t.LogIndex >= 0 {
+			logIndex = int32(t.LogIndex)
+		}
+		rows[i] = []any{
+			chain, strings.ToLower(t.Hash), logIndex, int32(occurrence[i]), int64(t.BlockNumber), time.Unix(t.TimeStamp, 0).UTC(),
+			strings.ToLower(t.Contract), strings.ToLower(t.From), strings.ToLower(t.To), t.Value, t.Amount().String(),
+		}
+	}
+
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"staged_transfers"}, stagingColumns, pgx.CopyFromRows(rows)); err != nil {
+		return fmt.Errorf("error copying transfers: %v", err)
+	}
+	return nil
+}
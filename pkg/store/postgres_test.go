@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// testPostgres returns the DSN of a fresh schema in the database of
+// POSTGRES_TEST_DSN, dropped after the test. Tests are skipped without it.
+func testPostgres(t *testing.T) (string, *pgx.Conn) {
+	t.Helper()
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("error connecting to PostgreSQL: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(ctx) })
+
+	schema := fmt.Sprintf("ethcrawler_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("error creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := conn.Exec(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("error dropping schema: %v", err)
+		}
+	})
+	if _, err := conn.Exec(ctx, "SET search_path TO "+schema); err != nil {
+		t.Fatalf("error setting search path: %v", err)
+	}
+
+	// Every connection of the test works in the schema
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "search_path=" + schema
+	default:
+		dsn += " search_path=" + schema
+	}
+	return dsn, conn
+}
+
+// count returns the number of rows of a table in the test schema
+func count(t *testing.T, conn *pgx.Conn, table string) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow(context.Background(), "SELECT count(*) FROM "+table).Scan(&n); err != nil {
+		t.Fatalf("error counting %s: %v", table, err)
+	}
+	return n
+}
+
+func TestPostgresMigrateTwice(t *testing.T) {
+	dsn, conn := testPostgres(t)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		db, err := OpenPostgres(ctx, dsn)
+		if err != nil {
+			t.Fatalf("OpenPostgres #%d: %v", i+1, err)
+		}
+		db.Close(ctx)
+	}
+
+	if n := count(t, conn, "schema_migrations"); n != len(postgresMigrations) {
+		t.Errorf("schema_migrations has %d rows, want %d", n, len(postgresMigrations))
+	}
+	var version int
+	if err := conn.QueryRow(ctx, "SELECT max(version) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("error reading schema version: %v", err)
+	}
+	if version != len(postgresMigrations) {
+		t.Errorf("schema version = %d, want %d", version, len(postgresMigrations))
+	}
+}
+
+func TestPostgresSaveTwice(t *testing.T) {
+	dsn, conn := testPostgres(t)
+	ctx := context.Background()
+
+	const contract = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+	address := "0x1111111111111111111111111111111111111111"
+	transfer := func(i int) models.FormattedTransfer {
+		return models.FormattedTransfer{
+			From:        "0x2222222222222222222222222222222222222222",
+			To:          address,
+			Value:       "123456789012345678901234567",
+			Hash:        fmt.Sprintf("0x%064x", i/3),
+			TimeStamp:   1700000000 + int64(i),
+			BlockNumber: 19000000 + i/3,
+			LogIndex:    -1 - i%3, // Three transfers per transaction without log index
+			Contract:    contract,
+			TokenName:   "Wrapped Ether",
+			TokenSymbol: "WETH",
+			Decimals:    18,
+			Direction:   models.DirectionIn,
+		}
+	}
+
+	// More than one COPY batch, and a transfer listed twice
+	var transfers []models.FormattedTransfer
+	for i := 0; i < 2*copyBatchSize+1; i++ {
+		transfers = append(transfers, transfer(i))
+	}
+	transfers = append(transfers, transfer(0))
+	unique := len(transfers) - 1
+
+	snapshot := Snapshot{
+		Chain:      "Ethereum",
+		Address:    address,
+		Label:      "Treasury",
+		Checkpoint: &Checkpoint{Contract: contract, SyncedTo: 19003334, UpdatedAt: time.Now()},
+		Transfers:  transfers,
+	}
+
+	db, err := OpenPostgres(ctx, dsn)
+	if err != nil {
+		t.Fatalf("OpenPostgres: %v", err)
+	}
+	defer db.Close(ctx)
+
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			// A run without a label keeps the earlier one
+			snapshot.Label = ""
+		}
+		written, err := db.Save(ctx, snapshot)
+		if err != nil {
+			t.Fatalf("Save #%d: %v", i+1, err)
+		}
+		if written != len(transfers) {
+			t.Errorf("Save #%d wrote %d transfers, want %d", i+1, written, len(transfers))
+		}
+	}
+
+	for table, want := range map[string]int{"transfers": unique, "addresses": 1, "tokens": 1, "checkpoints": 1} {
+		if n := count(t, conn, table); n != want {
+			t.Errorf("%s has %d rows, want %d", table, n, want)
+		}
+	}
+
+	var label, amount, value string
+	err = conn.QueryRow(ctx, `SELECT label, amount::text, value::text FROM address_transfers
+		WHERE chain = 'ethereum' AND hash = $1 AND log_index IS NULL AND occurrence = 2`, transfers[1].Hash).Scan(&label, &amount, &value)
+	if err != nil {
+		t.Fatalf("error reading transfer: %v", err)
+	}
+	if label != "Treasury" || amount != "123456789.012345678901234567" || value != "123456789012345678901234567" {
+		t.Errorf("stored label %q, amount %s and value %s", label, amount, value)
+	}
+}
+
+func TestPostgresConcurrentOpen(t *testing.T) {
+	dsn, conn := testPostgres(t)
+	ctx := context.Background()
+
+	// Writers starting at the same time on an empty database
+	const writers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := OpenPostgres(ctx, dsn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- db.Close(ctx)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("OpenPostgres: %v", err)
+		}
+	}
+
+	var versions, distinct int
+	err := conn.QueryRow(ctx, "SELECT count(*), count(DISTINCT version) FROM schema_migrations").Scan(&versions, &distinct)
+	if err != nil {
+		t.Fatalf("error reading schema_migrations: %v", err)
+	}
+	if versions != len(postgresMigrations) || distinct != versions {
+		t.Errorf("schema_migrations has %d rows for %d versions, want one row per each of %d versions",
+			versions, distinct, len(postgresMigrations))
+	}
+}
+
+func TestPostgresUnindexedTransfersOfSeveralAddresses(t *testing.T) {
+	dsn, conn := testPostgres(t)
+	ctx := context.Background()
+
+	db, err := OpenPostgres(ctx, dsn)
+	if err != nil {
+		t.Fatalf("OpenPostgres: %v", err)
+	}
+	defer db.Close(ctx)
+
+	for _, snapshot := range sharedTransaction() {
+		if _, err := db.Save(ctx, snapshot); err != nil {
+			t.Fatalf("Save %s: %v", snapshot.Address, err)
+		}
+	}
+
+	var values string
+	err = conn.QueryRow(ctx, `SELECT string_agg(value::text, ',' ORDER BY value) FROM transfers WHERE log_index IS NULL`).Scan(&values)
+	if err != nil {
+		t.Fatalf("error reading transfers: %v", err)
+	}
+	if values != "5000000,7000000" {
+		t.Errorf("stored values %s, want 5000000,7000000", values)
+	}
+}
@@ -0,0 +1,236 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the pure Go "sqlite" driver
+)
+
+// DefaultFileName is the database written by -format sqlite when no file is
+// given. It is shared by all addresses and chains.
+const DefaultFileName = "ethcrawler.db"
+
+// sqliteSchemaVersion is stored in PRAGMA user_version and bumped with each
+// entry of sqliteMigrations
+const sqliteSchemaVersion = 1
+
+// sqliteMigrations[i] upgrades the schema from version i to i+1. Transfers
+// without log index have a NULL log_index and are keyed by their content and
+// occurrence instead, since the negative log indexes depend on the address
+// queried.
+var sqliteMigrations = []string{
+	`CREATE TABLE tokens (
+		chain    TEXT NOT NULL,
+		contract TEXT NOT NULL,
+		symbol   TEXT NOT NULL DEFAULT '',
+		name     TEXT NOT NULL DEFAULT '',
+		decimals INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (chain, contract)
+	);
+
+	CREATE TABLE addresses (
+		chain      TEXT NOT NULL,
+		address    TEXT NOT NULL,
+		label      TEXT NOT NULL DEFAULT '',
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (chain, address)
+	);
+
+	CREATE TABLE transfers (
+		chain        TEXT NOT NULL,
+		hash         TEXT NOT NULL,
+		log_index    INTEGER,
+		occurrence   INTEGER NOT NULL DEFAULT 0,
+		block        INTEGER NOT NULL,
+		timestamp    INTEGER NOT NULL,
+		time         TEXT NOT NULL,
+		contract     TEXT NOT NULL,
+		from_address TEXT NOT NULL,
+		to_address   TEXT NOT NULL,
+		value        TEXT NOT NULL,
+		amount       TEXT NOT NULL,
+		UNIQUE (chain, hash, log_index)
+	);
+	CREATE INDEX transfers_from_block ON transfers (chain, from_address, block);
+	CREATE INDEX transfers_to_block ON transfers (chain, to_address, block);
+	CREATE INDEX transfers_contract_block ON transfers (chain, contract, block);
+	CREATE UNIQUE INDEX transfers_unindexed ON transfers (chain, hash, contract, from_address, to_address, value, occurrence)
+		WHERE log_index IS NULL;
+
+	CREATE TABLE checkpoints (
+		chain      TEXT NOT NULL,
+		contract   TEXT NOT NULL,
+		address    TEXT NOT NULL,
+		from_block INTEGER NOT NULL DEFAULT 0,
+		synced_to  INTEGER NOT NULL,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (chain, contract, address, from_block)
+	);
+
+	CREATE VIEW address_transfers AS
+	SELECT a.chain, a.address, a.label, t.block, t.log_index, t.time, t.timestamp, t.hash,
+		t.from_address, t.to_address,
+		CASE
+			WHEN t.from_address = a.address AND t.to_address = a.address THEN 'self'
+			WHEN t.to_address = a.address THEN 'in'
+			ELSE 'out'
+		END AS direction,
+		t.contract, k.symbol AS token, t.value, t.amount, t.occurrence
+	FROM addresses a
+	JOIN transfers t ON t.chain = a.chain AND (t.from_address = a.address OR t.to_address = a.address)
+	LEFT JOIN tokens k ON k.chain = t.chain AND k.contract = t.contract;`,
+}
+
+// SQLite is an SQLite database of synced transfers
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens or creates the database at path and migrates its schema
+func OpenSQLite(path string) (*SQLite, error) {
+	// WAL and a busy timeout let batch mode write several addresses at once
+	dsn := "file:" + path + "?_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	d := &SQLite{db: db}
+	if err := d.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// Close closes the database
+func (d *SQLite) Close() error {
+	return d.db.Close()
+}
+
+// migrate applies the migrations the database has not seen yet
+func (d *SQLite) migrate() error {
+	var version int
+	if err := d.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	if version > sqliteSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, sqliteSchemaVersion)
+	}
+
+	for ; version < sqliteSchemaVersion; version++ {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return fmt.Errorf("error migrating database: %v", err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error migrating database to version %d: %v", version+1, err)
+		}
+		// PRAGMA does not accept parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error migrating database to version %d: %v", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error migrating database to version %d: %v", version+1, err)
+		}
+	}
+	return nil
+}
+
+// Save upserts the address, its tokens, transfers and checkpoint in one
+// transaction, so saving the same data again changes nothing. It returns the
+// number of transfers written.
+func (d *SQLite) Save(ctx context.Context, s Snapshot) (int, error) {
+	chain := strings.ToLower(s.Chain)
+	address := strings.ToLower(s.Address)
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// An empty label keeps the label given by an earlier run
+	_, err = tx.ExecContext(ctx, `INSERT INTO addresses (chain, address, label, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (chain, address) DO UPDATE SET
+			label = CASE WHEN excluded.label = '' THEN addresses.label ELSE excluded.label END,
+			updated_at = excluded.updated_at`,
+		chain, address, s.Label, now)
+	if err != nil {
+		return 0, fmt.Errorf("error saving address: %v", err)
+	}
+
+	if cp := s.Checkpoint; cp != nil {
+		_, err = tx.ExecContext(ctx, `INSERT INTO checkpoints (chain, contract, address, from_block, synced_to, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT (chain, contract, address, from_block) DO UPDATE SET
+				synced_to = excluded.synced_to,
+				updated_at = excluded.updated_at`,
+			chain, strings.ToLower(cp.Contract), address, cp.FromBlock, cp.SyncedTo, cp.UpdatedAt.UTC().Format(time.RFC3339))
+		if err != nil {
+			return 0, fmt.Errorf("error saving checkpoint: %v", err)
+		}
+	}
+
+	tokenStmt, err := tx.PrepareContext(ctx, `INSERT INTO tokens (chain, contract, symbol, name, decimals) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (chain, contract) DO UPDATE SET
+			symbol = excluded.symbol,
+			name = excluded.name,
+			decimals = excluded.decimals`)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing token statement: %v", err)
+	}
+	defer tokenStmt.Close()
+
+	// Transfers with a log index conflict on it, the others on their content
+	transferStmt, err := tx.PrepareContext(ctx, `INSERT INTO transfers (chain, hash, log_index, occurrence, block, timestamp, time, contract, from_address, to_address, value, amount)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (chain, hash, log_index) DO UPDATE SET
+			block = excluded.block,
+			timestamp = excluded.timestamp,
+			time = excluded.time,
+			contract = excluded.contract,
+			from_address = excluded.from_address,
+			to_address = excluded.to_address,
+			value = excluded.value,
+			amount = excluded.amount
+		ON CONFLICT (chain, hash, contract, from_address, to_address, value, occurrence) WHERE log_index IS NULL DO UPDATE SET
+			block = excluded.block,
+			timestamp = excluded.timestamp,
+			time = excluded.time,
+			amount = excluded.amount`)
+	if err != nil {
+		return 0, fmt.Errorf("error preparing transfer statement: %v", err)
+	}
+	defer transferStmt.Close()
+
+	seenTokens := make(map[string]bool)
+	occurrence := occurrences(s.Transfers)
+	for i, t := range s.Transfers {
+		contract := strings.ToLower(t.Contract)
+		if !seenTokens[contract] {
+			seenTokens[contract] = true
+			if _, err := tokenStmt.ExecContext(ctx, chain, contract, t.TokenSymbol, t.TokenName, t.Decimals); err != nil {
+				return 0, fmt.Errorf("error saving token %s: %v", contract, err)
+			}
+		}
+
+		_, err := transferStmt.ExecContext(ctx, chain, strings.ToLower(t.Hash), logIndex(t), occurrence[i], t.BlockNumber,
+			t.TimeStamp, time.Unix(t.TimeStamp, 0).UTC().Format(time.RFC3339), contract,
+			strings.ToLower(t.From), strings.ToLower(t.To), t.Value, t.Amount().String())
+		if err != nil {
+			return 0, fmt.Errorf("error saving transfer %s: %v", t.Hash, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %v", err)
+	}
+	return len(s.Transfers), nil
+}
@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ethcrawler/pkg/models"
+)
+
+// countRows returns the number of rows of a table
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("error counting %s: %v", table, err)
+	}
+	return n
+}
+
+func TestSQLiteMigrateTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultFileName)
+
+	for i := 0; i < 2; i++ {
+		db, err := OpenSQLite(path)
+		if err != nil {
+			t.Fatalf("OpenSQLite #%d: %v", i+1, err)
+		}
+		var version int
+		if err := db.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+			t.Fatalf("error reading schema version: %v", err)
+		}
+		if version != sqliteSchemaVersion {
+			t.Errorf("schema version after open #%d = %d, want %d", i+1, version, sqliteSchemaVersion)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+}
+
+func TestSQLiteSaveTwice(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), DefaultFileName))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer db.Close()
+
+	const contract = "0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2"
+	address := "0x1111111111111111111111111111111111111111"
+	transfer := func(i int) models.FormattedTransfer {
+		return models.FormattedTransfer{
+			From:        "0x2222222222222222222222222222222222222222",
+			To:          address,
+			Value:       "123456789012345678901234567",
+			Hash:        fmt.Sprintf("0x%064x", i/3),
+			TimeStamp:   1700000000 + int64(i),
+			BlockNumber: 19000000 + i/3,
+			LogIndex:    -1 - i%3, // Three transfers per transaction without log index
+			Contract:    contract,
+			TokenName:   "Wrapped Ether",
+			TokenSymbol: "WETH",
+			Decimals:    18,
+			Direction:   models.DirectionIn,
+		}
+	}
+
+	// A transfer listed twice
+	var transfers []models.FormattedTransfer
+	for i := 0; i < 9; i++ {
+		transfers = append(transfers, transfer(i))
+	}
+	transfers = append(transfers, transfer(0))
+	unique := len(transfers) - 1
+
+	snapshot := Snapshot{
+		Chain:      "Ethereum",
+		Address:    address,
+		Label:      "Treasury",
+		Checkpoint: &Checkpoint{Contract: contract, SyncedTo: 19000002, UpdatedAt: time.Now()},
+		Transfers:  transfers,
+	}
+
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			// A run without a label keeps the earlier one, the checkpoint moves on
+			snapshot.Label = ""
+			snapshot.Checkpoint.SyncedTo = 19000010
+		}
+		written, err := db.Save(ctx, snapshot)
+		if err != nil {
+			t.Fatalf("Save #%d: %v", i+1, err)
+		}
+		if written != len(transfers) {
+			t.Errorf("Save #%d wrote %d transfers, want %d", i+1, written, len(transfers))
+		}
+	}
+
+	for table, want := range map[string]int{"transfers": unique, "addresses": 1, "tokens": 1, "checkpoints": 1} {
+		if n := countRows(t, db.db, table); n != want {
+			t.Errorf("%s has %d rows, want %d", table, n, want)
+		}
+	}
+
+	var syncedTo int
+	if err := db.db.QueryRow("SELECT synced_to FROM checkpoints").Scan(&syncedTo); err != nil {
+		t.Fatalf("error reading checkpoint: %v", err)
+	}
+	if syncedTo != 19000010 {
+		t.Errorf("checkpoint synced to %d, want 19000010", syncedTo)
+	}
+
+	var label, direction, amount, value string
+	err = db.db.QueryRow(`SELECT label, direction, amount, value FROM address_transfers
+		WHERE chain = 'ethereum' AND hash = ? AND log_index IS NULL AND occurrence = 2`, transfers[1].Hash).Scan(&label, &direction, &amount, &value)
+	if err != nil {
+		t.Fatalf("error reading transfer: %v", err)
+	}
+	if label != "Treasury" || direction != "in" || amount != "123456789.012345678901234567" || value != "123456789012345678901234567" {
+		t.Errorf("stored label %q, direction %s, amount %s and value %s", label, direction, amount, value)
+	}
+}
+
+// sharedTransaction returns the snapshots of two addresses taking part in a
+// transaction of a source without log indexes. The sender sees both transfers
+// and numbers them -1 and -2, the second recipient only sees its own and
+// numbers it -1.
+func sharedTransaction() []Snapshot {
+	const (
+		sender    = "0x1111111111111111111111111111111111111111"
+		first     = "0x2222222222222222222222222222222222222222"
+		second    = "0x3333333333333333333333333333333333333333"
+		contract  = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+		hash      = "0x00000000000000000000000000000000000000000000000000000000000000aa"
+		timestamp = 1700000000
+	)
+	transfer := func(to, value string, logIndex int, direction models.Direction) models.FormattedTransfer {
+		return models.FormattedTransfer{
+			From: sender, To: to, Value: value, Hash: hash, TimeStamp: timestamp,
+			BlockNumber: 19000000, LogIndex: logIndex, Contract: contract,
+			TokenSymbol: "USDT", Decimals: 6, Direction: direction,
+		}
+	}
+
+	return []Snapshot{
+		{Chain: "Ethereum", Address: sender, Transfers: []models.FormattedTransfer{
+			transfer(first, "5000000", -1, models.DirectionOut),
+			transfer(second, "7000000", -2, models.DirectionOut),
+		}},
+		{Chain: "Ethereum", Address: second, Transfers: []models.FormattedTransfer{
+			transfer(second, "7000000", -1, models.DirectionIn),
+		}},
+	}
+}
+
+func TestSQLiteUnindexedTransfersOfSeveralAddresses(t *testing.T) {
+	ctx := context.Background()
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), DefaultFileName))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer db.Close()
+
+	for _, snapshot := range sharedTransaction() {
+		if _, err := db.Save(ctx, snapshot); err != nil {
+			t.Fatalf("Save %s: %v", snapshot.Address, err)
+		}
+	}
+
+	rows, err := db.db.Query("SELECT to_address, value FROM transfers WHERE log_index IS NULL ORDER BY value")
+	if err != nil {
+		t.Fatalf("error reading transfers: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var to, value string
+		if err := rows.Scan(&to, &value); err != nil {
+			t.Fatalf("error reading transfer: %v", err)
+		}
+		got = append(got, to[:4]+":"+value)
+	}
+	if want := []string{"0x22:5000000", "0x33:7000000"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("stored transfers %v, want %v", got, want)
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	tx := func(hash, to string, logIndex int) models.FormattedTransfer {
+		return models.FormattedTransfer{Hash: hash, To: to, Value: "1", LogIndex: logIndex}
+	}
+	transfers := []models.FormattedTransfer{
+		tx("0xa", "0x1", 4),  // Log index known
+		tx("0xa", "0x2", -1), // Only transfer to 0x2
+		tx("0xa", "0x3", -2), // Two identical transfers to 0x3
+		tx("0xa", "0x3", -3),
+		tx("0xa", "0x3", -2), // Listed twice
+		tx("0xb", "0x3", -1), // Another transaction
+	}
+	want := []int{0, 1, 1, 2, 1, 1}
+	if got := occurrences(transfers); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("occurrences = %v, want %v", got, want)
+	}
+}
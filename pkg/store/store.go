@@ -0,0 +1,73 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"ethcrawler/pkg/models"
+)
+
+// Checkpoint mirrors a sync checkpoint of the work directory
+type Checkpoint struct {
+	Contract  string // Token contract or "all"
+	FromBlock int    // First synced block, 0 for the full history
+	SyncedTo  int
+	UpdatedAt time.Time
+}
+
+// Snapshot is the data of one address to write
+type Snapshot struct {
+	Chain      string
+	Address    string
+	Label      string
+	Checkpoint *Checkpoint // Nil if the address has not been synced
+	Transfers  []models.FormattedTransfer
+}
+
+// logIndex returns the log index of a transfer to store, nil if the source
+// did not report it
+func logIndex(t models.FormattedTransfer) any {
+	if t.LogIndex < 0 {
+		return nil
+	}
+	return t.LogIndex
+}
+
+// occurrences numbers the transfers without log index among the identical
+// transfers of their transaction: 1, 2, ... in the order of their negative
+// log indexes, 0 for transfers with a log index. The negative indexes depend
+// on the other transfers a query returned for the transaction, so different
+// addresses number different transfers alike. Identical transfers always
+// involve the same addresses and are returned together, so their occurrence
+// is the same for every query. A transfer listed twice keeps its number.
+func occurrences(transfers []models.FormattedTransfer) []int {
+	indexes := make(map[string][]int) // Negative log indexes per content
+	for _, t := range transfers {
+		if t.LogIndex >= 0 {
+			continue
+		}
+		key := contentKey(t)
+		if !slices.Contains(indexes[key], t.LogIndex) {
+			indexes[key] = append(indexes[key], t.LogIndex)
+		}
+	}
+
+	numbers := make([]int, len(transfers))
+	for i, t := range transfers {
+		if t.LogIndex >= 0 {
+			continue
+		}
+		for _, index := range indexes[contentKey(t)] {
+			if index >= t.LogIndex {
+				numbers[i]++
+			}
+		}
+	}
+	return numbers
+}
+
+// contentKey identifies the transfers of a transaction that are identical
+func contentKey(t models.FormattedTransfer) string {
+	return strings.ToLower(t.Hash+":"+t.Contract+":"+t.From+":"+t.To) + ":" + t.Value
+}
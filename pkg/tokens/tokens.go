@@ -0,0 +1,249 @@
+package tokens
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"ethcrawler/pkg/models"
+)
+
+const (
+	// OverridesFileName is the user maintained table of token metadata, kept in
+	// the work directory and keyed by chain and contract
+	OverridesFileName = "token_overrides.json"
+
+	cacheFileName = "tokens.json"
+)
+
+// Metadata describes an ERC20 token
+type Metadata struct {
+	Contract string `json:"contract"`
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Decimals int    `json:"decimals"`
+}
+
+// builtin holds the preset stablecoins, so that sources without token
+// metadata such as JSON-RPC still get correct amounts for them
+var builtin = map[string]map[string]Metadata{
+	"ethereum": {
+		"0xdac17f958d2ee523a2206206994597c13d831ec7": {Symbol: "USDT", Name: "Tether USD", Decimals: 6},
+		"0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48": {Symbol: "USDC", Name: "USD Coin", Decimals: 6},
+	},
+	"bsc": {
+		"0x55d398326f99059ff775485246999027b3197955": {Symbol: "USDT", Name: "Tether USD", Decimals: 18},
+		"0x8ac76a51cc950d9822d68b83fe1ad97b32cd580d": {Symbol: "USDC", Name: "USD Coin", Decimals: 18},
+	},
+	"polygon": {
+		"0xc2132d05d31c914a87c6611c10748aeb04b58e8f": {Symbol: "USDT", Name: "Tether USD", Decimals: 6},
+		"0x3c499c542cef5e3811e1192ce70d8cc03d5c3359": {Symbol: "USDC", Name: "USD Coin", Decimals: 6},
+	},
+	"arbitrum": {
+		"0xfd086bc7cd5c481dcc9c85ebe478a1c0b69fcbb9": {Symbol: "USDT", Name: "Tether USD", Decimals: 6},
+		"0xaf88d065e77c8cc2239327c5edb3a432268e5831": {Symbol: "USDC", Name: "USD Coin", Decimals: 6},
+	},
+	"optimism": {
+		"0x94b008aa00579c1307b0ef2c499ad98a8ce58e58": {Symbol: "USDT", Name: "Tether USD", Decimals: 6},
+		"0x0b2c639c533813f4aa9d7837caf62653d097ff85": {Symbol: "USDC", Name: "USD Coin", Decimals: 6},
+	},
+	"avalanche": {
+		"0x9702230a8ea53601f5cd2dc00fdbc13d4df4a8c7": {Symbol: "USDT", Name: "Tether USD", Decimals: 6},
+		"0xb97ef9ef8734c71904d8002f8b6bc66dd9c48a6e": {Symbol: "USDC", Name: "USD Coin", Decimals: 6},
+	},
+}
+
+// Resolver looks up token metadata of one chain. Overrides win over
+// everything else, then come the built-in presets and finally metadata
+// learned from tokentx results, which is cached on disk between runs.
+// It is safe for concurrent use.
+type Resolver struct {
+	Dir   string // Work directory holding the overrides and the cache
+	Chain string
+	Log   io.Writer // Warnings about unknown tokens
+
+	mu        sync.Mutex
+	overrides map[string]Metadata
+	cache     map[string]Metadata
+	dirty     bool
+	warned    map[string]bool
+}
+
+// NewResolver loads the overrides and the cached metadata of chain from dir
+func NewResolver(dir, chain string) (*Resolver, error) {
+	r := &Resolver{
+		Dir:       dir,
+		Chain:     strings.ToLower(chain),
+		Log:       os.Stdout,
+		overrides: make(map[string]Metadata),
+		cache:     make(map[string]Metadata),
+		warned:    make(map[string]bool),
+	}
+
+	// Overrides: {"<chain>": {"<contract>": {"symbol": ..., "decimals": ...}}}
+	var overrides map[string]map[string]Metadata
+	if err := readJSON(filepath.Join(dir, OverridesFileName), &overrides); err != nil {
+		return nil, fmt.Errorf("error reading token overrides: %v", err)
+	}
+	for chainName, tokens := range overrides {
+		if !strings.EqualFold(chainName, r.Chain) {
+			continue
+		}
+		for contract, md := range tokens {
+			md.Contract = strings.ToLower(contract)
+			r.overrides[md.Contract] = md
+		}
+	}
+
+	var cached []Metadata
+	if err := readJSON(r.cachePath(), &cached); err != nil {
+		return nil, fmt.Errorf("error reading token cache: %v", err)
+	}
+	for _, md := range cached {
+		r.cache[strings.ToLower(md.Contract)] = md
+	}
+
+	return r, nil
+}
+
+// Learn records the metadata carried by tokentx results
+func (r *Resolver) Learn(transfers []models.ERC20Transfer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, tx := range transfers {
+		if tx.ContractAddress == "" || tx.TokenDecimal == "" {
+			continue
+		}
+		decimals, err := strconv.Atoi(tx.TokenDecimal)
+		if err != nil || decimals < 0 {
+			continue
+		}
+
+		md := Metadata{
+			Contract: strings.ToLower(tx.ContractAddress),
+			Symbol:   tx.TokenSymbol,
+			Name:     tx.TokenName,
+			Decimals: decimals,
+		}
+		if r.cache[md.Contract] != md {
+			r.cache[md.Contract] = md
+			r.dirty = true
+		}
+	}
+}
+
+// Resolve returns the metadata of contract. The boolean is false if the
+// token is unknown.
+func (r *Resolver) Resolve(contract string) (Metadata, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resolve(contract)
+}
+
+// resolve is Resolve without locking
+func (r *Resolver) resolve(contract string) (Metadata, bool) {
+	contract = strings.ToLower(contract)
+	if md, ok := r.overrides[contract]; ok {
+		return md, true
+	}
+	if md, ok := builtin[r.Chain][contract]; ok {
+		md.Contract = contract
+		return md, true
+	}
+	md, ok := r.cache[contract]
+	return md, ok
+}
+
+// Annotate fills token metadata into formatted transfers. Transfers without a
+// contract, e.g. stored before contracts were recorded, are attributed to
+// contract. Unknown tokens keep amounts in base units and are reported once.
+func (r *Resolver) Annotate(transfers []models.FormattedTransfer, contract string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range transfers {
+		tx := &transfers[i]
+		if tx.Contract == "" {
+			tx.Contract = strings.ToLower(contract)
+		}
+
+		md, ok := r.resolve(tx.Contract)
+		if !ok {
+			if !r.warned[tx.Contract] {
+				r.warned[tx.Contract] = true
+				fmt.Fprintf(r.Log, "Unknown decimals for token %s, amounts are shown in base units. Add it to %s to fix this.\n",
+					tx.Contract, filepath.Join(r.Dir, OverridesFileName))
+			}
+			md = Metadata{Contract: tx.Contract}
+		}
+
+		tx.Decimals = md.Decimals
+		if md.Symbol != "" {
+			tx.TokenSymbol = md.Symbol
+		}
+		if md.Name != "" {
+			tx.TokenName = md.Name
+		}
+	}
+}
+
+// Save writes learned metadata to the cache if anything changed
+func (r *Resolver) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+
+	cached := make([]Metadata, 0, len(r.cache))
+	for _, md := range r.cache {
+		cached = append(cached, md)
+	}
+	sort.Slice(cached, func(i, j int) bool { return cached[i].Contract < cached[j].Contract })
+
+	data, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding token cache: %v", err)
+	}
+
+	path := r.cachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating token cache directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing token cache: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing token cache: %v", err)
+	}
+
+	r.dirty = false
+	return nil
+}
+
+// cachePath returns the cache file of the chain
+func (r *Resolver) cachePath() string {
+	return filepath.Join(r.Dir, r.Chain, cacheFileName)
+}
+
+// readJSON decodes a JSON file into v, a missing file is not an error
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,121 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ethcrawler/pkg/models"
+)
+
+const (
+	usdt = "0xdac17f958d2ee523a2206206994597c13d831ec7"
+	weth = "0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2"
+	dai  = "0x6b175474e89094c44da98b954eedeac495271d0f"
+)
+
+// newResolver creates a resolver of Ethereum in a fresh work directory with
+// the given overrides file content
+func newResolver(t *testing.T, overrides string) *Resolver {
+	t.Helper()
+	dir := t.TempDir()
+	if overrides != "" {
+		if err := os.WriteFile(filepath.Join(dir, OverridesFileName), []byte(overrides), 0644); err != nil {
+			t.Fatalf("error writing overrides: %v", err)
+		}
+	}
+	r, err := NewResolver(dir, "Ethereum")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	return r
+}
+
+func TestResolve(t *testing.T) {
+	r := newResolver(t, `{
+		"ethereum": {"0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2": {"symbol": "WETH", "decimals": 18}},
+		"bsc": {"`+dai+`": {"symbol": "BSC-DAI", "decimals": 18}}
+	}`)
+	r.Learn([]models.ERC20Transfer{
+		{ContractAddress: weth, TokenSymbol: "WETH9", TokenDecimal: "9"},
+		{ContractAddress: usdt, TokenSymbol: "FAKE", TokenDecimal: "18"},
+		{ContractAddress: dai, TokenSymbol: "DAI", TokenName: "Dai Stablecoin", TokenDecimal: "18"},
+		{ContractAddress: "0x0000000000000000000000000000000000000001", TokenSymbol: "BAD", TokenDecimal: "x"},
+		{ContractAddress: "0x0000000000000000000000000000000000000002", TokenSymbol: "NONE"},
+	})
+
+	tests := []struct {
+		name     string
+		contract string
+		symbol   string
+		decimals int
+		found    bool
+	}{
+		{"override wins over learned", "0xC02AAA39B223FE8D0A0E5C4F27EAD9083C756CC2", "WETH", 18, true},
+		{"built-in wins over learned", usdt, "USDT", 6, true},
+		{"learned", dai, "DAI", 18, true},
+		{"invalid decimals", "0x0000000000000000000000000000000000000001", "", 0, false},
+		{"no decimals", "0x0000000000000000000000000000000000000002", "", 0, false},
+		{"unknown", "0x0000000000000000000000000000000000000003", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		md, ok := r.Resolve(tt.contract)
+		if ok != tt.found || md.Symbol != tt.symbol || md.Decimals != tt.decimals {
+			t.Errorf("%s: Resolve = %+v, %v, want %s with %d decimals, %v", tt.name, md, ok, tt.symbol, tt.decimals, tt.found)
+		}
+	}
+}
+
+func TestSaveAndReload(t *testing.T) {
+	r := newResolver(t, "")
+	r.Learn([]models.ERC20Transfer{{ContractAddress: "0x6B175474E89094C44Da98b954EedeAC495271d0F", TokenSymbol: "DAI", TokenDecimal: "18"}})
+	if err := r.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	reloaded, err := NewResolver(r.Dir, "ethereum")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	if md, ok := reloaded.Resolve(dai); !ok || md.Symbol != "DAI" || md.Decimals != 18 {
+		t.Errorf("reloaded Resolve = %+v, %v, want DAI with 18 decimals", md, ok)
+	}
+
+	// Other chains have caches of their own
+	other, err := NewResolver(r.Dir, "polygon")
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	if _, ok := other.Resolve(dai); ok {
+		t.Errorf("metadata learned on Ethereum resolved on Polygon")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	r := newResolver(t, "")
+	r.Learn([]models.ERC20Transfer{{ContractAddress: dai, TokenSymbol: "DAI", TokenName: "Dai Stablecoin", TokenDecimal: "18"}})
+
+	transfers := []models.FormattedTransfer{
+		{Contract: dai, TokenSymbol: "dai"},
+		{Contract: "", TokenSymbol: "old"}, // Stored before contracts were recorded
+		{Contract: "0x0000000000000000000000000000000000000003", TokenSymbol: "XYZ", Decimals: 9},
+	}
+	r.Annotate(transfers, "0xDAC17F958D2EE523A2206206994597C13D831EC7")
+
+	want := []struct {
+		contract, symbol, name string
+		decimals               int
+	}{
+		{dai, "DAI", "Dai Stablecoin", 18},
+		{usdt, "USDT", "Tether USD", 6},
+		{"0x0000000000000000000000000000000000000003", "XYZ", "", 0}, // Unknown, amounts stay in base units
+	}
+	for i, w := range want {
+		tx := transfers[i]
+		if tx.Contract != w.contract || tx.TokenSymbol != w.symbol || tx.TokenName != w.name || tx.Decimals != w.decimals {
+			t.Errorf("transfer %d = %s %s %q %d, want %s %s %q %d", i,
+				tx.Contract, tx.TokenSymbol, tx.TokenName, tx.Decimals, w.contract, w.symbol, w.name, w.decimals)
+		}
+	}
+}
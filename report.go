@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/ledger"
+	"ethcrawler/pkg/output"
+)
+
+// printReport печатает по каждому токену число трансферов, приход, расход и
+// восстановленный баланс. Отчет печатается целиком, чтобы отчеты адресов
+// пакетного режима не перемешивались.
+func printReport(result crawlResult) error {
+	transfers, err := result.Rows.Collect()
+	if err != nil {
+		return withCode(ExitOutput, fmt.Errorf("error reading transfers: %v", err))
+	}
+
+	var b strings.Builder
+
+	name := result.Address
+	if result.Label != "" {
+		name = fmt.Sprintf("%s (%s)", result.Label, result.Address)
+	}
+	fmt.Fprintf(&b, "\n%sReport for %s on %s%s\n", etherscan.ColorGreen, name, result.Meta.Chain.Title, etherscan.ColorReset)
+
+	groups := output.GroupByToken(transfers)
+	if len(groups) == 0 {
+		b.WriteString("No transactions\n")
+	}
+	for _, group := range groups {
+		summary := group.Summary()
+		fmt.Fprintf(&b, "%s (%s)\n", group.Label(), group.Contract)
+		fmt.Fprintf(&b, "  Transfers: %d, from %s to %s\n", summary.Transfers, summary.First, summary.Last)
+		fmt.Fprintf(&b, "  Inflow:    %s\n", summary.Inflow)
+		fmt.Fprintf(&b, "  Outflow:   %s\n", summary.Outflow)
+		fmt.Fprintf(&b, "  Net:       %s\n", summary.Net())
+
+		if l, ok := ledger.Find(result.Ledgers, group.Contract); ok {
+			fmt.Fprintf(&b, "  Balance:   %s -> %s\n", l.Opening, l.Closing)
+			if l.Negative > 0 {
+				fmt.Fprintf(&b, "  %sBalance is negative after %d transfers, transfers are probably missing or the opening balance is wrong%s\n",
+					etherscan.ColorRed, l.Negative, etherscan.ColorReset)
+			}
+		}
+	}
+
+	fmt.Fprint(console, b.String())
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/etherscan"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/source"
+	"ethcrawler/pkg/state"
+)
+
+// syncOptions управляет тем, с какого места начинается загрузка
+type syncOptions struct {
+	Full   bool // Удалить сохраненные данные и загрузить всю историю заново
+	Resume bool // Продолжить прерванную загрузку по журналу
+
+	// Диапазон блоков результата, 0 — без ограничения. Загружается история
+	// ключа до EndBlock, а возвращаются только трансферы из диапазона.
+	StartBlock int
+	EndBlock   int
+}
+
+// syncTransfers дозагружает трансферы начиная с сохраненного чекпоинта.
+// Каждая загруженная страница пишется в журнал, а в хранилище данные попадают
+// только после успешного завершения загрузки. Сохраненная история не
+// читается в память, а загруженные трансферы держатся в ней до переноса в
+// хранилище. При прерывании возвращает вместе с ошибкой уже
+// загруженные, но не сохраненные трансферы, которые идут в истории после
+// сохраненных. Ошибки чтения и записи данных синхронизации несут код ExitOutput.
+func syncTransfers(ctx context.Context, src source.TransferSource, store *state.Store, key state.Key, opts syncOptions) ([]models.ERC20Transfer, models.Progress, error) {
+	var progress models.Progress
+
+	if opts.Full && !opts.Resume {
+		if err := store.Reset(key); err != nil {
+			return nil, progress, withCode(ExitOutput, err)
+		}
+	}
+
+	checkpoint, found, err := store.Checkpoint(key)
+	if err != nil {
+		return nil, progress, withCode(ExitOutput, err)
+	}
+
+	query := models.Query{
+		Address:    key.Address,
+		Contract:   key.Contract,
+		StartBlock: key.StartBlock,
+		EndBlock:   opts.EndBlock,
+	}
+	if key.Contract == chains.AllTokens {
+		query.Contract = ""
+	}
+	if found && checkpoint.SyncedTo >= key.StartBlock {
+		query.StartBlock = checkpoint.SyncedTo + 1
+	}
+
+	// Одни и те же трансферы могут прийти повторно: из журнала, уже
+	// сохраненные в хранилище или на границе блока при продолжении загрузки.
+	// Ключи считаются отдельно для хранилища, журнала и загрузки: каждый из
+	// них нумерует одинаковые трансферы заново. Из хранилища нужны только
+	// ключи трансферов после чекпоинта, они остаются, если запись прервалась
+	// до его сохранения, и тогда те же трансферы есть и в журнале.
+	seen := make(map[string]struct{})
+	storedKeys := models.NewTransferKeys()
+	stored := 0
+	err = store.EachTransfer(key, func(tx models.ERC20Transfer) error {
+		stored++
+		if block, err := models.StringToInt(tx.BlockNumber); err == nil && block < query.StartBlock {
+			return nil
+		}
+		seen[storedKeys.Key(tx)] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, progress, withCode(ExitOutput, err)
+	}
+
+	if found && checkpoint.SyncedTo >= key.StartBlock {
+		if opts.EndBlock > 0 && checkpoint.SyncedTo >= opts.EndBlock && !opts.Resume {
+			fmt.Fprintf(console, "%sFound %d stored transactions covering the requested blocks%s\n",
+				etherscan.ColorGreen, stored, etherscan.ColorReset)
+			return nil, progress, nil
+		}
+		fmt.Fprintf(console, "%sFound %d stored transactions, fetching new ones from block %d%s\n",
+			etherscan.ColorGreen, stored, query.StartBlock, etherscan.ColorReset)
+	}
+
+	var fetched []models.ERC20Transfer
+	addNew := func(page []models.ERC20Transfer, keys *models.TransferKeys) []models.ERC20Transfer {
+		var fresh []models.ERC20Transfer
+		for _, tx := range page {
+			key := keys.Key(tx)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			fresh = append(fresh, tx)
+		}
+		fetched = append(fetched, fresh...)
+		return fresh
+	}
+
+	journal, pages, err := openJournal(store, key, opts.Resume)
+	if err != nil {
+		return nil, progress, withCode(ExitOutput, err)
+	}
+	defer journal.Close()
+
+	// Восстанавливаем страницы из журнала и продолжаем с последнего блока
+	journalKeys := models.NewTransferKeys()
+	for _, page := range pages {
+		addNew(page.Transfers, journalKeys)
+		progress.Pages = page.Page
+		progress.LastBlock = page.LastBlock
+	}
+	if len(pages) > 0 {
+		query.StartBlock = progress.LastBlock
+		fmt.Fprintf(console, "%sResuming download after page %d from block %d (%d transactions recovered)%s\n",
+			etherscan.ColorYellow, progress.Pages, query.StartBlock, len(fetched), etherscan.ColorReset)
+	}
+
+	pageNumber := progress.Pages
+	streamedKeys := models.NewTransferKeys()
+	streamed, err := src.StreamTokenTransfers(ctx, query,
+		func(page []models.ERC20Transfer) error {
+			fresh := addNew(page, streamedKeys)
+
+			lastBlock, err := models.StringToInt(page[len(page)-1].BlockNumber)
+			if err != nil {
+				return fmt.Errorf("error converting block number: %v", err)
+			}
+
+			pageNumber++
+			return withCode(ExitOutput, journal.Append(state.JournalPage{
+				Page:      pageNumber,
+				LastBlock: lastBlock,
+				Transfers: fresh,
+			}))
+		})
+
+	progress.Pages += streamed.Pages
+	progress.Transfers = len(fetched)
+	if streamed.Transfers > 0 {
+		progress.LastBlock = streamed.LastBlock
+	}
+	if err != nil {
+		return fetched, progress, err
+	}
+
+	// Загрузка завершена: переносим журнал в хранилище и сдвигаем чекпоинт
+	if err := store.AppendTransfers(key, fetched); err != nil {
+		return fetched, progress, withCode(ExitOutput, err)
+	}
+	if progress.Transfers > 0 {
+		checkpoint.SyncedTo = progress.LastBlock
+	}
+	if err := store.SaveCheckpoint(key, checkpoint); err != nil {
+		return nil, progress, withCode(ExitOutput, err)
+	}
+	if err := store.RemoveJournal(key); err != nil {
+		return nil, progress, withCode(ExitOutput, err)
+	}
+
+	return nil, progress, nil
+}
+
+// requireStored проверяет, что трансферы ключа уже загружались, когда
+// источник данных не используется
+func requireStored(store *state.Store, key state.Key) error {
+	_, found, err := store.Checkpoint(key)
+	if err != nil {
+		return withCode(ExitOutput, err)
+	}
+	if !found {
+		return withCode(ExitValidation, fmt.Errorf("no downloaded %s transfers of %s, run ethcrawler fetch first", key.Chain, key.Address))
+	}
+	return nil
+}
+
+// inBlockRange сообщает, входит ли трансфер в диапазон блоков opts
+func inBlockRange(tx models.ERC20Transfer, opts syncOptions) bool {
+	if opts.StartBlock <= 0 && opts.EndBlock <= 0 {
+		return true
+	}
+
+	block, err := models.StringToInt(tx.BlockNumber)
+	if err != nil {
+		return false
+	}
+	return block >= opts.StartBlock && (opts.EndBlock <= 0 || block <= opts.EndBlock)
+}
+
+// openJournal открывает журнал прерванной загрузки при -resume или начинает новый
+func openJournal(store *state.Store, key state.Key, resume bool) (*state.Journal, []state.JournalPage, error) {
+	if resume {
+		journal, pages, found, err := store.OpenJournal(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if found {
+			return journal, pages, nil
+		}
+		fmt.Fprintf(console, "%sNo interrupted download to resume, starting a new one%s\n",
+			etherscan.ColorYellow, etherscan.ColorReset)
+	} else if store.HasJournal(key) {
+		fmt.Fprintf(console, "%sDiscarding an interrupted download, use -resume to continue it instead%s\n",
+			etherscan.ColorYellow, etherscan.ColorReset)
+	}
+
+	journal, err := store.CreateJournal(key)
+	return journal, nil, err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"ethcrawler/pkg/chains"
+	"ethcrawler/pkg/models"
+	"ethcrawler/pkg/source"
+	"ethcrawler/pkg/state"
+)
+
+const (
+	testAddress  = "0x1111111111111111111111111111111111111111"
+	otherAddress = "0x2222222222222222222222222222222222222222"
+)
+
+// usdtTransfer builds a USDT transfer of value base units
+func usdtTransfer(block, logIndex int, from, to, value string) models.ERC20Transfer {
+	tx := models.ERC20Transfer{
+		TimeStamp:       strconv.Itoa(1700000000 + block*12),
+		From:            from,
+		To:              to,
+		Value:           value,
+		Hash:            "0x" + strconv.Itoa(block),
+		BlockNumber:     strconv.Itoa(block),
+		ContractAddress: chains.Ethereum.USDT,
+		TokenName:       "Tether USD",
+		TokenSymbol:     "USDT",
+		TokenDecimal:    "6",
+	}
+	if logIndex >= 0 {
+		tx.LogIndex = strconv.Itoa(logIndex)
+	}
+	return tx
+}
+
+func testKey() state.Key {
+	return state.Key{Chain: chains.Ethereum.Name, Contract: chains.Ethereum.USDT, Address: testAddress}
+}
+
+// assertUnique fails when a transfer occurs twice in transfers
+func assertUnique(t *testing.T, transfers []models.ERC20Transfer) {
+	t.Helper()
+	keys := models.NewTransferKeys()
+	seen := make(map[string]bool)
+	for _, tx := range transfers {
+		key := keys.Key(tx)
+		if seen[key] {
+			t.Errorf("transfer %s returned twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+// historyOf returns the stored transfers of key followed by the pending ones
+// an interrupted sync returned
+func historyOf(t *testing.T, store *state.Store, key state.Key, pending []models.ERC20Transfer) []models.ERC20Transfer {
+	t.Helper()
+	transfers, err := store.Transfers(key)
+	if err != nil {
+		t.Fatalf("reading stored transfers: %v", err)
+	}
+	return append(transfers, pending...)
+}
+
+func TestSyncResumesFromCheckpoint(t *testing.T) {
+	store := state.NewStore(t.TempDir())
+	key := testKey()
+	fake := source.NewFake(2,
+		usdtTransfer(100, 0, otherAddress, testAddress, "1000000"),
+		usdtTransfer(150, 3, testAddress, otherAddress, "200000"),
+		usdtTransfer(200, 1, otherAddress, testAddress, "5000000"),
+		usdtTransfer(300, 0, otherAddress, testAddress, "70000"),
+	)
+
+	pending, _, err := syncTransfers(context.Background(), fake, store, key, syncOptions{})
+	if err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("completed sync left %d transfers unstored", len(pending))
+	}
+	if transfers := historyOf(t, store, key, pending); len(transfers) != 4 {
+		t.Fatalf("first sync returned %d transfers, want 4", len(transfers))
+	}
+
+	// New transfers arrive, the next sync only asks for blocks past the checkpoint
+	fake.Transfers = append(fake.Transfers,
+		usdtTransfer(400, 2, testAddress, otherAddress, "100"),
+		usdtTransfer(500, 0, otherAddress, testAddress, "42"),
+	)
+	pending, progress, err := syncTransfers(context.Background(), fake, store, key, syncOptions{})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	transfers := historyOf(t, store, key, pending)
+	if len(transfers) != 6 || progress.Transfers != 2 {
+		t.Errorf("second sync returned %d transfers with %d new, want 6 with 2 new", len(transfers), progress.Transfers)
+	}
+	assertUnique(t, transfers)
+
+	queries := fake.Queries()
+	if queries[0].StartBlock != 0 || queries[1].StartBlock != 301 {
+		t.Errorf("queries start at blocks %d and %d, want 0 and 301", queries[0].StartBlock, queries[1].StartBlock)
+	}
+
+	checkpoint, found, err := store.Checkpoint(key)
+	if err != nil || !found || checkpoint.SyncedTo != 500 {
+		t.Errorf("checkpoint = %+v (found %v, error %v), want synced to 500", checkpoint, found, err)
+	}
+
+	// Nothing new: the stored history comes back unchanged
+	pending, _, err = syncTransfers(context.Background(), fake, store, key, syncOptions{})
+	if err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	if transfers := historyOf(t, store, key, pending); len(transfers) != 6 {
+		t.Errorf("third sync returned %d transfers, want 6", len(transfers))
+	}
+	if start := fake.Queries()[2].StartBlock; start != 501 {
+		t.Errorf("third query starts at block %d, want 501", start)
+	}
+}
+
+func TestSyncResumesFromJournal(t *testing.T) {
+	store := state.NewStore(t.TempDir())
+	key := testKey()
+	// The second page ends inside block 200, which holds three identical
+	// payouts without log index
+	fake := source.NewFake(2,
+		usdtTransfer(100, 0, otherAddress, testAddress, "1000000"),
+		usdtTransfer(150, 3, testAddress, otherAddress, "200000"),
+		usdtTransfer(200, -1, otherAddress, testAddress, "500"),
+		usdtTransfer(200, -1, otherAddress, testAddress, "500"),
+		usdtTransfer(200, -1, otherAddress, testAddress, "500"),
+		usdtTransfer(300, 0, otherAddress, testAddress, "70000"),
+	)
+	fake.FailAfter = 2
+	fake.Err = errors.New("connection reset by peer")
+
+	pending, progress, err := syncTransfers(context.Background(), fake, store, key, syncOptions{})
+	if !errors.Is(err, fake.Err) {
+		t.Fatalf("interrupted sync error = %v, want %v", err, fake.Err)
+	}
+	transfers := historyOf(t, store, key, pending)
+	if len(transfers) != 4 || progress.LastBlock != 200 {
+		t.Fatalf("interrupted sync returned %d transfers up to block %d, want 4 up to 200", len(transfers), progress.LastBlock)
+	}
+	if !store.HasJournal(key) {
+		t.Fatal("interrupted sync left no journal")
+	}
+	if _, found, _ := store.Checkpoint(key); found {
+		t.Error("interrupted sync saved a checkpoint")
+	}
+
+	fake.FailAfter = 0
+	pending, _, err = syncTransfers(context.Background(), fake, store, key, syncOptions{Resume: true})
+	if err != nil {
+		t.Fatalf("resumed sync: %v", err)
+	}
+	transfers = historyOf(t, store, key, pending)
+	if len(transfers) != 6 {
+		t.Errorf("resumed sync returned %d transfers, want 6", len(transfers))
+	}
+	assertUnique(t, transfers)
+
+	// The journal ends inside block 200, so the download restarts at it
+	if start := fake.Queries()[1].StartBlock; start != 200 {
+		t.Errorf("resumed query starts at block %d, want 200", start)
+	}
+	if store.HasJournal(key) {
+		t.Error("completed sync kept the journal")
+	}
+	checkpoint, found, err := store.Checkpoint(key)
+	if err != nil || !found || checkpoint.SyncedTo != 300 {
+		t.Errorf("checkpoint = %+v (found %v, error %v), want synced to 300", checkpoint, found, err)
+	}
+}
+
+func TestSyncAfterCrashBeforeCheckpoint(t *testing.T) {
+	store := state.NewStore(t.TempDir())
+	key := testKey()
+	fake := source.NewFake(0,
+		usdtTransfer(100, 0, otherAddress, testAddress, "1000000"),
+		usdtTransfer(200, 1, otherAddress, testAddress, "5000000"),
+	)
+	if _, _, err := syncTransfers(context.Background(), fake, store, key, syncOptions{}); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+
+	// A crash after moving a download into the store, before its checkpoint
+	// was saved, leaves transfers past the checkpoint that come back again
+	late := []models.ERC20Transfer{
+		usdtTransfer(300, 0, testAddress, otherAddress, "200000"),
+		usdtTransfer(300, 1, testAddress, otherAddress, "300000"),
+	}
+	if err := store.AppendTransfers(key, late); err != nil {
+		t.Fatalf("AppendTransfers: %v", err)
+	}
+	fake.Transfers = append(fake.Transfers, late...)
+	fake.Transfers = append(fake.Transfers, usdtTransfer(400, 0, otherAddress, testAddress, "42"))
+
+	pending, progress, err := syncTransfers(context.Background(), fake, store, key, syncOptions{})
+	if err != nil {
+		t.Fatalf("second sync: %v", err)
+	}
+	transfers := historyOf(t, store, key, pending)
+	if len(transfers) != 5 || progress.Transfers != 1 {
+		t.Errorf("second sync returned %d transfers with %d new, want 5 with 1 new", len(transfers), progress.Transfers)
+	}
+	assertUnique(t, transfers)
+}
+
+func TestSyncResumeAfterCrashBeforeCheckpoint(t *testing.T) {
+	store := state.NewStore(t.TempDir())
+	key := testKey()
+	fake := source.NewFake(2,
+		usdtTransfer(100, 0, otherAddress, testAddress, "1000000"),
+		usdtTransfer(150, 3, testAddress, otherAddress, "200000"),
+		usdtTransfer(200, -1, otherAddress, testAddress, "500"),
+		usdtTransfer(200, -1, otherAddress, testAddress, "500"),
+		usdtTransfer(200, -1, otherAddress, testAddress, "500"),
+		usdtTransfer(300, 0, otherAddress, testAddress, "70000"),
+	)
+	fake.FailAfter = 2
+	fake.Err = errors.New("connection reset by peer")
+
+	pending, _, err := syncTransfers(context.Background(), fake, store, key, syncOptions{})
+	if !errors.Is(err, fake.Err) {
+		t.Fatalf("interrupted sync error = %v, want %v", err, fake.Err)
+	}
+
+	// The journal was moved into the store, but the process died before the
+	// checkpoint was saved, so the unindexed payouts are both stored and in
+	// the journal
+	if err := store.AppendTransfers(key, pending); err != nil {
+		t.Fatalf("AppendTransfers: %v", err)
+	}
+
+	fake.FailAfter = 0
+	pending, _, err = syncTransfers(context.Background(), fake, store, key, syncOptions{Resume: true})
+	if err != nil {
+		t.Fatalf("resumed sync: %v", err)
+	}
+	transfers := historyOf(t, store, key, pending)
+	if len(transfers) != 6 {
+		t.Errorf("resumed sync returned %d transfers, want 6", len(transfers))
+	}
+	assertUnique(t, transfers)
+}